@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/prober"
+	"payer-status-io/internal/scheduler"
+)
+
+// Exit codes shared by the subcommands
+const (
+	exitOK        = 0
+	exitUnhealthy = 1 // A config that does not load, or a probe that failed
+	exitUsage     = 2 // Bad flags, or a probe selector matching nothing
+)
+
+// command is a CLI subcommand of the server binary
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+// commands returns the subcommands by name. Without one, the binary runs
+// the server.
+func commands() map[string]command {
+	return map[string]command{
+		"validate": {"Load and validate a config file", runValidate},
+		"probe":    {"Probe endpoints once and report the results", runProbe},
+		"list":     {"Show the resolved schedule of every endpoint", runList},
+		"export":   {"Dump recent probe results from a running server", runExport},
+		"schema":   {"Print the JSON Schema of the config file", runSchema},
+	}
+}
+
+// runCommand runs the subcommand named by args[0], if there is one
+func runCommand(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	cmd, ok := commands()[args[0]]
+	if !ok {
+		return 0, false
+	}
+	return cmd.run(args[1:]), true
+}
+
+// newFlagSet creates the flags common to every subcommand
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", getEnv("CONFIG_PATH", defaultConfigPath), "Path to the payer configuration file")
+	return fs, configPath
+}
+
+// parseArgs parses a subcommand's flags, rejecting stray arguments
+func parseArgs(fs *flag.FlagSet, args []string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return false
+	}
+	return true
+}
+
+// loadConfig loads and validates a config file without logging
+func loadConfig(path string) (*config.Config, error) {
+	loader, err := newLoader(path)
+	if err != nil {
+		return nil, err
+	}
+	return loader.GetConfig(), nil
+}
+
+// newLoader returns a quiet loader that has loaded path
+func newLoader(path string) (*config.Loader, error) {
+	loader := config.NewLoader(path, zap.NewNop())
+	if err := loader.Load(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// runValidate checks a config file, listing its endpoints and warnings
+func runValidate(args []string) int {
+	fs, configPath := newFlagSet("validate")
+	quiet := fs.Bool("quiet", false, "Only print errors and warnings")
+	if !parseArgs(fs, args) {
+		return exitUsage
+	}
+
+	loader, err := newLoader(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+	cfg := loader.GetConfig()
+
+	refs := cfg.EndpointRefs()
+	if !*quiet {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PAYER\tTYPE\tMETHOD\tSCHEDULE\tURL")
+		for _, ref := range refs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ref.Payer, ref.Endpoint.Type,
+				ref.Endpoint.GetMethod(), describeSchedule(ref.Endpoint), ref.Endpoint.GetURL())
+		}
+		w.Flush()
+	}
+
+	warnings := cfg.Warnings()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	fmt.Printf("%s: %d files, %d payers, %d endpoints, %d warnings\n",
+		*configPath, len(loader.Files()), len(cfg.Payers), len(refs), len(warnings))
+	return exitOK
+}
+
+// runProbe probes the selected endpoints once. The exit code is non-zero if
+// any probe was unhealthy.
+func runProbe(args []string) int {
+	fs, configPath := newFlagSet("probe")
+	var selector scheduler.Selector
+	fs.StringVar(&selector.Payer, "payer", "", "Only probe endpoints of this payer")
+	fs.StringVar(&selector.Type, "type", "", "Only probe endpoints of this type")
+	fs.StringVar(&selector.URL, "url", "", "Only probe the endpoint with this URL")
+	selector.Labels = make(map[string]string)
+	fs.Var(labelFlag(selector.Labels), "label", "Only probe endpoints with this key=value label (repeatable)")
+	asJSON := fs.Bool("json", false, "Print results as JSON")
+	if !parseArgs(fs, args) {
+		return exitUsage
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+
+	var tasks []*scheduler.Task
+	for _, ref := range cfg.EndpointRefs() {
+		if selector.Matches(ref.Payer, ref.Endpoint) {
+			tasks = append(tasks, &scheduler.Task{Key: ref.Key, Payer: ref.Payer, Endpoint: ref.Endpoint})
+		}
+	}
+	if len(tasks) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no endpoints match")
+		return exitUsage
+	}
+
+	results := probeOnce(cfg.Server, tasks)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PAYER\tTYPE\tSTATUS\tLATENCY\tURL\tERROR")
+		for _, result := range results {
+			status := "-"
+			if result.StatusCode > 0 {
+				status = strconv.Itoa(result.StatusCode)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%dms\t%s\t%s\n", result.Payer, result.Type,
+				status, result.LatencyMS, result.URL, result.Err)
+		}
+		w.Flush()
+	}
+
+	unhealthy := 0
+	for _, result := range results {
+		if !result.Healthy() {
+			unhealthy++
+		}
+	}
+	if unhealthy > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d probes unhealthy\n", unhealthy, len(results))
+		return exitUnhealthy
+	}
+	return exitOK
+}
+
+// labelFlag collects repeated key=value flags into a map
+type labelFlag map[string]string
+
+func (f labelFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for key, value := range f {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f labelFlag) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	f[key] = v
+	return nil
+}
+
+// probeOnce runs every task concurrently under the configured ceilings and
+// returns the results in task order
+func probeOnce(settings config.ServerConfig, tasks []*scheduler.Task) []*config.ProbeResult {
+	httpProber := prober.New(zap.NewNop(), settings.ProbeTimeout)
+	httpProber.Configure(settings)
+	defer httpProber.Close()
+
+	gate := scheduler.NewGate(gateLimits(settings))
+	results := make([]*config.ProbeResult, len(tasks))
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func(i int, task *scheduler.Task) {
+			defer wg.Done()
+			release, err := gate.Acquire(context.Background(), httpProber.Hostname(task.Endpoint))
+			if err != nil {
+				results[i] = &config.ProbeResult{Timestamp: time.Now(), Payer: task.Payer,
+					Type: task.Endpoint.Type, URL: task.Endpoint.GetURL(), Labels: task.Endpoint.Labels, Err: err.Error()}
+				return
+			}
+			results[i] = httpProber.ProbeTask(context.Background(), task)
+			release()
+		}(i, task)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runList prints when each endpoint would next be probed
+func runList(args []string) int {
+	fs, configPath := newFlagSet("list")
+	asJSON := fs.Bool("json", false, "Print the schedule as JSON")
+	if !parseArgs(fs, args) {
+		return exitUsage
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+
+	s := scheduler.New(zap.NewNop(), 1)
+	if err := s.Configure(cfg.Server); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+	s.LoadConfig(cfg)
+	statuses := s.Status()
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(statuses)
+		return exitOK
+	}
+
+	schedules := make(map[string]string)
+	for _, ref := range cfg.EndpointRefs() {
+		schedules[ref.Payer+"\x00"+ref.Endpoint.Type+"\x00"+ref.Endpoint.GetURL()] = describeSchedule(ref.Endpoint)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PAYER\tTYPE\tSCHEDULE\tNEXT RUN\tURL")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Payer, status.Type,
+			schedules[status.Payer+"\x00"+status.Type+"\x00"+status.URL],
+			status.NextRun.Format(time.RFC3339), status.URL)
+	}
+	w.Flush()
+	return exitOK
+}
+
+// describeSchedule summarizes an endpoint's schedule for display
+func describeSchedule(endpoint config.Endpoint) string {
+	var desc string
+	if endpoint.IsCron() {
+		desc = "cron " + endpoint.Cron
+	} else {
+		desc = "every " + endpoint.GetSchedule().String()
+	}
+	if len(endpoint.Profiles) > 0 {
+		desc += fmt.Sprintf(" (+%d profiles)", len(endpoint.Profiles))
+	}
+	if endpoint.Timezone != "" {
+		desc += " " + endpoint.Timezone
+	}
+	return desc
+}
+
+// runExport dumps the recent probe results of a running server: those kept
+// for resuming WebSocket clients, as many as ws_replay_buffer allows
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:"+config.DefaultServerConfig().WSPort, "Base URL of the running server")
+	format := fs.String("format", "json", "Output format: json or csv")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	payer := fs.String("payer", "", "Only export results of this payer")
+	endpointType := fs.String("type", "", "Only export results of this endpoint type")
+	if !parseArgs(fs, args) {
+		return exitUsage
+	}
+	if *format != "json" && *format != "csv" {
+		fmt.Fprintln(os.Stderr, "error: format must be json or csv")
+		return exitUsage
+	}
+
+	query := url.Values{}
+	if *payer != "" {
+		query.Set("payer", *payer)
+	}
+	if *endpointType != "" {
+		query.Set("type", *endpointType)
+	}
+	historyURL := strings.TrimSuffix(*server, "/") + "/api/history"
+	if len(query) > 0 {
+		historyURL += "?" + query.Encode()
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(historyURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "error: server returned %s\n", resp.Status)
+		return exitUnhealthy
+	}
+
+	var history struct {
+		Results []*config.ProbeResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to decode history: %v\n", err)
+		return exitUnhealthy
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return exitUnhealthy
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(history.Results); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return exitUnhealthy
+		}
+		return exitOK
+	}
+
+	if err := writeHistoryCSV(out, history.Results); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+	return exitOK
+}
+
+// writeHistoryCSV writes one row per probe result
+func writeHistoryCSV(out io.Writer, results []*config.ProbeResult) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"ts", "payer", "type", "url", "status_code", "latency_ms",
+		"healthy", "error", "muted", "paused", "correlation_id"})
+
+	for _, result := range results {
+		w.Write([]string{
+			result.Timestamp.Format(time.RFC3339Nano), result.Payer, result.Type, result.URL,
+			strconv.Itoa(result.StatusCode), strconv.FormatInt(result.LatencyMS, 10),
+			strconv.FormatBool(result.Healthy()), result.Err,
+			strconv.FormatBool(result.Muted), strconv.FormatBool(result.Paused),
+			result.CorrelationID,
+		})
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// runSchema prints the JSON Schema of the config file format, for editors
+// to validate and complete payer_status.yaml with
+func runSchema(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	if !parseArgs(fs, args) {
+		return exitUsage
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return exitUnhealthy
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(config.Schema()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitUnhealthy
+	}
+	return exitOK
+}
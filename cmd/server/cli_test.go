@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+// run runs a subcommand with its output discarded, returning its exit code
+func run(t *testing.T, args ...string) int {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devNull, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	code, ok := runCommand(args)
+	if !ok {
+		t.Fatalf("%q is not a command", args[0])
+	}
+	return code
+}
+
+// writeConfig writes a config file with a healthy and an unhealthy
+// endpoint, returning its path
+func writeConfig(t *testing.T) string {
+	t.Helper()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(healthy.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+
+	path := filepath.Join(t.TempDir(), "payer_status.yaml")
+	data := `payers:
+  - name: Acme
+    endpoints:
+      - {type: login, url: "` + healthy.URL + `/login", schedule: 5m}
+      - {type: search, url: "` + failing.URL + `/search", schedule: 5m}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunCommandDispatch(t *testing.T) {
+	for _, args := range [][]string{nil, {}, {"serve"}, {"--config", "x.yaml"}} {
+		if _, ok := runCommand(args); ok {
+			t.Errorf("runCommand(%q) ran a subcommand", args)
+		}
+	}
+}
+
+func TestUsageListsEveryCommand(t *testing.T) {
+	var out bytes.Buffer
+	flag.CommandLine.SetOutput(&out)
+	defer flag.CommandLine.SetOutput(nil)
+	usage()
+
+	for name, cmd := range commands() {
+		if !strings.Contains(out.String(), name+strings.Repeat(" ", 11-len(name))+cmd.summary) {
+			t.Errorf("usage does not list %s", name)
+		}
+	}
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	valid := writeConfig(t)
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("payers:\n  - name: Acme\n    endpoints: [{type: login, url: ftp://x}]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"validate", "--config", valid}, exitOK},
+		{[]string{"validate", "--config", valid, "--quiet"}, exitOK},
+		{[]string{"validate", "--config", missing}, exitUnhealthy},
+		{[]string{"validate", "--config", invalid}, exitUnhealthy},
+		{[]string{"validate", "--no-such-flag"}, exitUsage},
+
+		{[]string{"probe", "--config", valid, "--type", "login"}, exitOK},
+		{[]string{"probe", "--config", valid, "--type", "login", "--json"}, exitOK},
+		{[]string{"probe", "--config", valid}, exitUnhealthy},
+		{[]string{"probe", "--config", valid, "--type", "search"}, exitUnhealthy},
+		{[]string{"probe", "--config", missing}, exitUnhealthy},
+		{[]string{"probe", "--config", invalid}, exitUnhealthy},
+		{[]string{"probe", "--config", valid, "--payer", "Nobody"}, exitUsage},
+		{[]string{"probe", "--config", valid, "--label", "region"}, exitUsage},
+
+		{[]string{"list", "--config", valid}, exitOK},
+		{[]string{"list", "--config", valid, "--json"}, exitOK},
+		{[]string{"list", "--config", missing}, exitUnhealthy},
+		{[]string{"list", "--config", invalid}, exitUnhealthy},
+		{[]string{"list", "--config", valid, "extra"}, exitUsage},
+		{[]string{"list", "--json=maybe"}, exitUsage},
+
+		{[]string{"export", "--server", unreachable.URL}, exitUnhealthy},
+		{[]string{"export", "--format", "xml"}, exitUsage},
+
+		{[]string{"schema", "-o", filepath.Join(t.TempDir(), "schema.json")}, exitOK},
+		{[]string{"schema", "-o"}, exitUsage},
+	}
+	for _, tt := range tests {
+		if got := run(t, tt.args...); got != tt.want {
+			t.Errorf("%q exited %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestLabelFlag(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   map[string]string
+		err    bool
+	}{
+		{[]string{"region=east"}, map[string]string{"region": "east"}, false},
+		{[]string{"region=east", "tier=gold"}, map[string]string{"region": "east", "tier": "gold"}, false},
+		{[]string{"region=east", "region=west"}, map[string]string{"region": "west"}, false},
+		{[]string{"empty="}, map[string]string{"empty": ""}, false},
+		{[]string{"url=a=b"}, map[string]string{"url": "a=b"}, false},
+		{[]string{"region"}, nil, true},
+		{[]string{"=east"}, nil, true},
+	}
+	for _, tt := range tests {
+		labels := make(map[string]string)
+		var err error
+		for _, value := range tt.values {
+			if err = labelFlag(labels).Set(value); err != nil {
+				break
+			}
+		}
+		if (err != nil) != tt.err {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.values, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if len(labels) != len(tt.want) {
+			t.Errorf("Set(%q) = %v, want %v", tt.values, labels, tt.want)
+			continue
+		}
+		for key, value := range tt.want {
+			if labels[key] != value {
+				t.Errorf("Set(%q) = %v, want %v", tt.values, labels, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	ts := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/history" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.RawQuery
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": []*config.ProbeResult{
+			{Timestamp: ts, Payer: "Acme", Type: "login", URL: "https://acme.example.com/", LatencyMS: 120, StatusCode: 200},
+			{Timestamp: ts.Add(time.Minute), Payer: "Acme", Type: "login", URL: "https://acme.example.com/", Err: "timeout", Muted: true},
+		}})
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "history.csv")
+	if code := run(t, "export", "--server", server.URL+"/", "--payer", "Acme", "--format", "csv", "-o", output); code != exitOK {
+		t.Fatalf("export exited %d", code)
+	}
+	if query != "payer=Acme" {
+		t.Errorf("export queried %q, want payer=Acme", query)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ts,payer,type,url,status_code,latency_ms,healthy,error,muted,paused,correlation_id",
+		"2024-01-10T12:00:00Z,Acme,login,https://acme.example.com/,200,120,true,,false,false,",
+		"2024-01-10T12:01:00Z,Acme,login,https://acme.example.com/,0,0,false,timeout,true,false,",
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("export wrote %d rows, want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		if got := strings.Join(row, ","); got != want[i] {
+			t.Errorf("row %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
@@ -3,37 +3,52 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 
+	"payer-status-io/internal/auth"
+	"payer-status-io/internal/broker"
+	"payer-status-io/internal/cluster"
 	"payer-status-io/internal/config"
 	"payer-status-io/internal/hub"
 	"payer-status-io/internal/metrics"
 	"payer-status-io/internal/prober"
 	"payer-status-io/internal/scheduler"
+	"payer-status-io/internal/webhook"
 )
 
 const (
-	defaultConfigPath = "./docs/payer_status.yaml"
-	defaultWSPort     = "8080"
-	defaultMetricsPort = "9090"
-	defaultLogLevel   = "info"
-	workerPoolSize    = 50
-	taskChannelSize   = 1000
-	probeTimeout      = 10 * time.Second
+	defaultConfigPath  = "./docs/payer_status.yaml"
+	defaultConfigCache = "./data/config_cache.yaml"
+	defaultConfigPoll  = time.Minute
 )
 
 func main() {
+	// Subcommands run once and exit instead of starting the server
+	if code, ok := runCommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
+	sourceOpts, overrides := parseFlags()
+
 	// Initialize logger
-	logger := initLogger()
+	logger, logLevel := initLogger()
 	defer logger.Sync()
 
 	logger.Info("Starting Payer Status WebSocket Health Monitor")
@@ -43,34 +58,159 @@ func main() {
 		os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
-	// Load configuration
-	configPath := getEnv("CONFIG_PATH", defaultConfigPath)
-	configLoader := config.NewLoader(configPath, logger)
+	// Load configuration; server settings are the file's, then environment
+	// variables, then command-line flags
+	configLoader := config.NewLoader(sourceOpts.path, logger)
+	if source := sourceOpts.source(); source != nil {
+		configLoader.SetSource(source)
+		configLoader.SetCachePath(sourceOpts.cache)
+	}
+	configLoader.SetOverrides(overrides)
 	cfg := configLoader.MustLoad()
+	settings := cfg.Server
+	logLevel.SetLevel(parseLogLevel(settings.LogLevel))
 
 	// Initialize core components
-	metricsCollector := metrics.New(logger)
+	metricsCollector := metrics.New(logger, settings.MetricLabelKeys())
 	wsHub := hub.New(logger)
-	taskScheduler := scheduler.New(logger, taskChannelSize)
-	httpProber := prober.New(logger, probeTimeout)
+	wsHub.SetPayers(cfg.Payers)
+	wsHub.SetReplaySize(settings.WSReplayBuffer)
+	if err := configureWebSocketAuth(wsHub, settings); err != nil {
+		logger.Fatal("Invalid WebSocket authentication settings", zap.Error(err))
+	}
+	if settings.WSAPIKeysFile == "" && settings.WSJWKSFile == "" {
+		logger.Warn("WebSocket authentication is disabled; set ws_api_keys_file or ws_jwks_file to require it")
+	}
+
+	// Deliver the broadcast stream to the configured webhooks too
+	webhooks := webhook.New(logger)
+	webhooks.SetObserver(metricsCollector)
+	if err := webhooks.Configure(cfg.Webhooks, settings.WebhookDeadLetterPath); err != nil {
+		logger.Fatal("Invalid webhook settings", zap.Error(err))
+	}
+	wsHub.AddSink(webhooks)
+
+	// And to the configured NATS and MQTT brokers
+	brokers := broker.New(logger)
+	brokers.SetObserver(metricsCollector)
+	if err := brokers.Configure(cfg.Publishers); err != nil {
+		logger.Fatal("Invalid publisher settings", zap.Error(err))
+	}
+	wsHub.AddSink(brokers)
+
+	taskScheduler := scheduler.New(logger, settings.TaskChannelSize)
+	httpProber := prober.New(logger, settings.ProbeTimeout)
+	httpProber.Configure(settings)
+
+	// Global and per-host ceilings on in-flight probes and request rate
+	probeGate := scheduler.NewGate(gateLimits(settings))
+	probeGate.SetObserver(metricsCollector)
+	taskScheduler.SetGate(probeGate, httpProber.Hostname)
+
+	// Configure back-pressure handling and export scheduling accounting
+	taskScheduler.SetObserver(metricsCollector)
+	if err := taskScheduler.Configure(settings); err != nil {
+		logger.Fatal("Invalid scheduler settings", zap.Error(err))
+	}
 
 	// Load initial configuration into scheduler
 	taskScheduler.LoadConfig(cfg)
 
-	// Set up configuration hot-reload
+	// Restore pauses and mutes from the previous run
+	if err := taskScheduler.SetStatePath(settings.StatePath); err != nil {
+		logger.Error("Failed to restore scheduler state", zap.Error(err))
+	}
+
+	// In a cluster only the leader runs scheduled probes; the others stand
+	// by and relay its results to their own clients
+	var node *cluster.Node
+	if settings.ClusterLockFile != "" {
+		taskScheduler.SetStandby(true)
+		node = cluster.New(logger, cluster.NewFileCoordinator(settings.ClusterLockFile), wsHub, settings)
+		node.OnLeadershipChange(func(leader bool) {
+			// Take over the pauses and mutes the previous leader saved
+			if leader {
+				if err := taskScheduler.SetStatePath(settings.StatePath); err != nil {
+					logger.Error("Failed to restore scheduler state", zap.Error(err))
+				}
+			}
+			taskScheduler.SetStandby(!leader)
+		})
+		wsHub.AddSink(node)
+	}
+
+	// Set up configuration hot-reload. Configs the scheduler cannot run
+	// with are rejected before they replace the current one.
+	configLoader.OnConfigCheck(func(newCfg *config.Config) error {
+		if _, err := auth.Load(newCfg.Server); err != nil {
+			return err
+		}
+		if err := webhook.Check(newCfg.Webhooks); err != nil {
+			return err
+		}
+		if err := broker.Check(newCfg.Publishers); err != nil {
+			return err
+		}
+		return scheduler.CheckSettings(newCfg.Server)
+	})
+	// applied holds the settings of the last configuration applied, so each
+	// reload warns only about the restart-only settings it changes itself
+	applied := settings
 	configLoader.OnConfigChange(func(newCfg *config.Config) {
 		logger.Info("Configuration changed, reloading scheduler")
+
+		// Apply the runtime settings that are safe to change while running
+		next := newCfg.Server
+		logLevel.SetLevel(parseLogLevel(next.LogLevel))
+		httpProber.Configure(next)
+		probeGate.SetLimits(gateLimits(next))
+		if err := configureWebSocketAuth(wsHub, next); err != nil {
+			logger.Error("Failed to apply WebSocket authentication settings", zap.Error(err))
+		}
+		if err := taskScheduler.Configure(next); err != nil {
+			logger.Error("Failed to apply scheduler settings", zap.Error(err))
+		}
+		if keys := applied.RestartRequired(&next); len(keys) > 0 {
+			logger.Warn("Changed server settings take effect on restart", zap.Strings("settings", keys))
+		}
+		applied = next
+
 		taskScheduler.LoadConfig(newCfg)
-		metricsCollector.RecordConfigReload(true)
+		wsHub.SetPayers(newCfg.Payers)
+		if err := webhooks.Configure(newCfg.Webhooks, next.WebhookDeadLetterPath); err != nil {
+			logger.Error("Failed to apply webhook settings", zap.Error(err))
+		}
+		if err := brokers.Configure(newCfg.Publishers); err != nil {
+			logger.Error("Failed to apply publisher settings", zap.Error(err))
+		}
+	})
+	configLoader.OnReload(func(err error) {
+		metricsCollector.RecordConfigReload(err == nil)
 	})
 	configLoader.WatchForChanges(ctx)
+	if sourceOpts.remote() {
+		configLoader.Poll(ctx, sourceOpts.poll)
+	} else if settings.WatchConfig {
+		if err := configLoader.WatchFiles(ctx, settings.WatchDebounce); err != nil {
+			logger.Error("Failed to watch configuration files", zap.Error(err))
+		}
+	}
+
+	// Allow WebSocket clients to trigger on-demand probes, which a cluster
+	// follower sends on to the leader
+	probe := probeTrigger(taskScheduler)
+	if node != nil {
+		node.SetTrigger(probe)
+		probe = node.Trigger
+	}
+	wsHub.SetTrigger(probe)
 
 	// Start worker pool for probe execution
-	startWorkerPool(ctx, logger, taskScheduler, httpProber, wsHub, metricsCollector)
+	startWorkerPool(ctx, logger, settings.Workers, taskScheduler, httpProber, wsHub, metricsCollector)
 
 	// Create HTTP servers
-	wsServer := createWebSocketServer(wsHub, configLoader, logger)
-	metricsServer := createMetricsServer(metricsCollector, logger)
+	wsServer := createWebSocketServer(settings.WSPort, wsHub, configLoader, taskScheduler, node, logger)
+	metricsServer := createMetricsServer(settings.MetricsPort, metricsCollector, logger)
 
 	// Start all services using errgroup for coordinated shutdown
 	g, gCtx := errgroup.WithContext(ctx)
@@ -80,6 +220,23 @@ func main() {
 		return wsHub.Run(gCtx)
 	})
 
+	// Start webhook delivery
+	g.Go(func() error {
+		return webhooks.Run(gCtx)
+	})
+
+	// Start broker publishing
+	g.Go(func() error {
+		return brokers.Run(gCtx)
+	})
+
+	// Start cluster leader election
+	if node != nil {
+		g.Go(func() error {
+			return node.Run(gCtx)
+		})
+	}
+
 	// Start task scheduler
 	g.Go(func() error {
 		return taskScheduler.Start(gCtx)
@@ -87,7 +244,7 @@ func main() {
 
 	// Start WebSocket server
 	g.Go(func() error {
-		logger.Info("Starting WebSocket server", zap.String("port", getEnv("WS_PORT", defaultWSPort)))
+		logger.Info("Starting WebSocket server", zap.String("port", settings.WSPort))
 		if err := wsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			return fmt.Errorf("WebSocket server failed: %w", err)
 		}
@@ -96,7 +253,7 @@ func main() {
 
 	// Start metrics server
 	g.Go(func() error {
-		logger.Info("Starting metrics server", zap.String("port", getEnv("METRICS_PORT", defaultMetricsPort)))
+		logger.Info("Starting metrics server", zap.String("port", settings.MetricsPort))
 		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			return fmt.Errorf("metrics server failed: %w", err)
 		}
@@ -158,12 +315,12 @@ func main() {
 }
 
 // startWorkerPool starts the worker pool for executing probe tasks
-func startWorkerPool(ctx context.Context, logger *zap.Logger, scheduler *scheduler.Scheduler, 
+func startWorkerPool(ctx context.Context, logger *zap.Logger, workers int, scheduler *scheduler.Scheduler, 
 	prober *prober.Prober, hub *hub.Hub, metrics *metrics.Metrics) {
 	
 	taskChan := scheduler.GetTaskChannel()
 	
-	for i := 0; i < workerPoolSize; i++ {
+	for i := 0; i < workers; i++ {
 		go func(workerID int) {
 			logger.Debug("Starting worker", zap.Int("worker_id", workerID))
 			
@@ -179,10 +336,15 @@ func startWorkerPool(ctx context.Context, logger *zap.Logger, scheduler *schedul
 						return
 					}
 					
-					// Execute probe with timeout context
-					probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
-					result := prober.ProbeTask(probeCtx, task)
-					cancel()
+					// Execute probe; the prober applies the endpoint's timeout.
+					// The scheduler took the task's gate slot before dispatch.
+					result := prober.ProbeTask(ctx, task)
+					task.Release()
+
+					result.Muted = scheduler.IsMuted(task.Payer, task.Endpoint)
+
+					// Hand the result back to an on-demand trigger, if any
+					task.Complete(result)
 					
 					// Record metrics
 					metrics.RecordProbe(result)
@@ -195,15 +357,33 @@ func startWorkerPool(ctx context.Context, logger *zap.Logger, scheduler *schedul
 		}(i)
 	}
 	
-	logger.Info("Worker pool started", zap.Int("workers", workerPoolSize))
+	logger.Info("Worker pool started", zap.Int("workers", workers))
 }
 
 // createWebSocketServer creates the WebSocket HTTP server
-func createWebSocketServer(hub *hub.Hub, configLoader *config.Loader, logger *zap.Logger) *http.Server {
+func createWebSocketServer(port string, hub *hub.Hub, configLoader *config.Loader, taskScheduler *scheduler.Scheduler, node *cluster.Node, logger *zap.Logger) *http.Server {
 	mux := http.NewServeMux()
 	
 	// WebSocket endpoint
 	mux.HandleFunc("/ws", hub.HandleWebSocket)
+
+	// Server-Sent Events mirror of the WebSocket stream
+	mux.HandleFunc("/events", hub.HandleEvents)
+
+	// Results relayed from the cluster leader to the followers, and probe
+	// requests the followers send the leader
+	if node != nil {
+		mux.HandleFunc(cluster.RelayPath, node.HandleRelay)
+		mux.HandleFunc(cluster.TriggerPath, node.HandleTrigger)
+	}
+
+	// Controls, on-demand probes and rollbacks apply on the cluster leader
+	leaderOnly := func(handler http.HandlerFunc) http.HandlerFunc {
+		if node == nil {
+			return handler
+		}
+		return node.Forward(handler)
+	}
 	
 	// Static file serving for web client
 	mux.Handle("/", http.FileServer(http.Dir("./web/")))
@@ -258,6 +438,78 @@ func createWebSocketServer(hub *hub.Hub, configLoader *config.Loader, logger *za
 		json.NewEncoder(w).Encode(response)
 	})
 	
+	// Recent configuration reloads and what they changed
+	mux.HandleFunc("/api/reloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(configLoader.Reloads())
+	})
+	
+	// Applied config versions and rollback
+	mux.HandleFunc("/api/config/versions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(configLoader.Versions())
+	})
+	mux.HandleFunc("/api/config/rollback", mutating(hub, logger, false, leaderOnly(handleConfigRollback(configLoader, logger))))
+	
+	// JSON Schema of the config file, for editor integration
+	mux.HandleFunc("/api/config/schema", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(config.Schema())
+	})
+	
+	// Endpoint schedule and control state
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		
+		// Optional filters, e.g. ?payer=GEHA&label=region=Iowa
+		sel := selectorFromQuery(r.URL.Query())
+		endpoints := make([]scheduler.EndpointStatus, 0)
+		for _, status := range taskScheduler.Status() {
+			if sel.Matches(status.Payer, config.Endpoint{Type: status.Type, URL: status.URL, Labels: status.Labels}) {
+				endpoints = append(endpoints, status)
+			}
+		}
+		
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"endpoints": endpoints,
+			"controls":  taskScheduler.Controls(),
+		})
+	})
+	
+	// Recent probe results, as kept for resuming WebSocket clients
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		
+		// Same filters as /api/status
+		sel := selectorFromQuery(r.URL.Query())
+		results := make([]*config.ProbeResult, 0)
+		for _, result := range hub.History() {
+			if sel.Matches(result.Payer, config.Endpoint{Type: result.Type, URL: result.URL, Labels: result.Labels}) {
+				results = append(results, result)
+			}
+		}
+		
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": results,
+		})
+	})
+	
+	// On-demand probe trigger
+	mux.HandleFunc("/api/probe", mutating(hub, logger, true, leaderOnly(handleProbeTrigger(taskScheduler, logger))))
+	
+	// Runtime pause, resume, mute and unmute
+	mux.HandleFunc("/api/controls", mutating(hub, logger, false, leaderOnly(handleControls(taskScheduler, hub, logger))))
+	
 	// Debug endpoints (as per .windsurfrules)
 	mux.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
 		stats := hub.GetStats()
@@ -268,7 +520,7 @@ func createWebSocketServer(hub *hub.Hub, configLoader *config.Loader, logger *za
 	})
 
 	return &http.Server{
-		Addr:         ":" + getEnv("WS_PORT", defaultWSPort),
+		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -276,13 +528,349 @@ func createWebSocketServer(hub *hub.Hub, configLoader *config.Loader, logger *za
 	}
 }
 
+// probeTrigger runs the probe requests of WebSocket clients. A request
+// whose endpoints are all throttled fails, as no result would follow.
+func probeTrigger(taskScheduler *scheduler.Scheduler) hub.TriggerFunc {
+	return func(req hub.ProbeRequest) (string, error) {
+		trigger, err := taskScheduler.Trigger(scheduler.TriggerRequest{
+			Selector:      scheduler.Selector{Payer: req.Payer, Type: req.Type, URL: req.URL, Labels: req.Labels},
+			CorrelationID: req.CorrelationID,
+		})
+		if err != nil {
+			return "", err
+		}
+		if trigger.Dispatched == 0 {
+			return "", fmt.Errorf("%w; retry in %s", hub.ErrThrottled, trigger.RetryAfter().Round(time.Second))
+		}
+		return trigger.CorrelationID, nil
+	}
+}
+
+// handleProbeTrigger probes the selected endpoints immediately. With
+// wait=false it returns the correlation ID to watch for on the stream;
+// otherwise it blocks until every dispatched probe has completed, however
+// long they wait for a worker or the concurrency ceilings, or until the
+// client gives up.
+func handleProbeTrigger(taskScheduler *scheduler.Scheduler, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		query := r.URL.Query()
+		req := scheduler.TriggerRequest{
+			Selector:      selectorFromQuery(query),
+			CorrelationID: query.Get("correlation_id"),
+		}
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+				return
+			}
+		}
+
+		// Clients limited to certain payers must name one of theirs
+		if principal, _ := r.Context().Value(principalKey{}).(*auth.Principal); principal != nil && principal.Payers != nil {
+			if req.Payer == "" || !principal.AllowsPayer(req.Payer) {
+				writeJSONError(w, http.StatusForbidden, "not allowed to probe payer "+strconv.Quote(req.Payer))
+				return
+			}
+		}
+
+		trigger, err := taskScheduler.Trigger(req)
+		switch {
+		case errors.Is(err, scheduler.ErrEmptySelector):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		case errors.Is(err, scheduler.ErrNoMatch):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		case err != nil:
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		if trigger.Dispatched == 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(trigger.RetryAfter().Seconds())+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(trigger)
+			return
+		}
+
+		response := struct {
+			*scheduler.Trigger
+			Results []*config.ProbeResult `json:"results,omitempty"`
+		}{Trigger: trigger}
+
+		status := http.StatusAccepted
+		if query.Get("wait") != "false" {
+			status = http.StatusOK
+			// Probes may outlast the server's WriteTimeout. Each one is
+			// bounded by its endpoint's timeout once it starts, and workers
+			// complete the ones they abandon at shutdown.
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+			for len(response.Results) < trigger.Dispatched {
+				select {
+				case result := <-trigger.Results:
+					response.Results = append(response.Results, result)
+				case <-r.Context().Done():
+					logger.Debug("Client stopped waiting for triggered probes",
+						zap.String("correlation_id", trigger.CorrelationID))
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// controlRequest is the body accepted by POST /api/controls
+type controlRequest struct {
+	scheduler.Selector
+	Action   string    `json:"action"` // pause, resume, mute, unmute
+	Until    time.Time `json:"until,omitempty"`
+	Duration string    `json:"duration,omitempty"` // Alternative to until, e.g. "2h"
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// handleControls lists (GET), applies (POST) and removes by ID (DELETE)
+// runtime endpoint controls
+func handleControls(taskScheduler *scheduler.Scheduler, wsHub *hub.Hub, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(taskScheduler.Controls())
+
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if id == "" || !taskScheduler.RemoveControl(id) {
+				writeJSONError(w, http.StatusNotFound, "no control with id "+id)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodPost:
+			var req controlRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+				return
+			}
+
+			expiresAt := req.Until
+			if req.Duration != "" {
+				d, err := time.ParseDuration(req.Duration)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, "invalid duration: "+err.Error())
+					return
+				}
+				expiresAt = time.Now().Add(d)
+			}
+
+			switch req.Action {
+			case "pause", "mute":
+				control, err := taskScheduler.AddControl(scheduler.ControlAction(req.Action), req.Selector, expiresAt, req.Reason)
+				switch {
+				case errors.Is(err, scheduler.ErrNoMatch):
+					writeJSONError(w, http.StatusNotFound, err.Error())
+					return
+				case err != nil:
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+
+				if control.Action == scheduler.ControlPause {
+					broadcastPaused(taskScheduler, wsHub, req.Selector)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(control)
+
+			case "resume", "unmute":
+				action := scheduler.ControlPause
+				if req.Action == "unmute" {
+					action = scheduler.ControlMute
+				}
+
+				removed, err := taskScheduler.RemoveControls(action, req.Selector)
+				switch {
+				case errors.Is(err, scheduler.ErrControlNotFound):
+					writeJSONError(w, http.StatusNotFound, err.Error())
+					return
+				case err != nil:
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+
+				// Probe resumed endpoints right away so the stream shows them live again
+				if action == scheduler.ControlPause {
+					if _, err := taskScheduler.Trigger(scheduler.TriggerRequest{Selector: req.Selector}); err != nil {
+						logger.Debug("Could not probe resumed endpoints", zap.Error(err))
+					}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+
+			default:
+				writeJSONError(w, http.StatusBadRequest, "action must be pause, resume, mute or unmute")
+			}
+
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// broadcastPaused tells stream clients that the selected endpoints stopped probing
+func broadcastPaused(taskScheduler *scheduler.Scheduler, wsHub *hub.Hub, sel scheduler.Selector) {
+	now := time.Now()
+	for _, status := range taskScheduler.Status() {
+		if !status.Paused || !sel.Matches(status.Payer, config.Endpoint{Type: status.Type, URL: status.URL, Labels: status.Labels}) {
+			continue
+		}
+		wsHub.Broadcast(&config.ProbeResult{
+			Timestamp: now,
+			Payer:     status.Payer,
+			Type:      status.Type,
+			URL:       status.URL,
+			Labels:    status.Labels,
+			Muted:     status.Muted,
+			Paused:    true,
+		})
+	}
+}
+
+// selectorFromQuery reads payer, type, url and label query parameters
+func selectorFromQuery(query url.Values) scheduler.Selector {
+	return scheduler.Selector{
+		Payer:  query.Get("payer"),
+		Type:   query.Get("type"),
+		URL:    query.Get("url"),
+		Labels: labelsFromQuery(query),
+	}
+}
+
+// labelsFromQuery reads repeated label=key=value query parameters
+func labelsFromQuery(query url.Values) map[string]string {
+	var labels map[string]string
+	for _, pair := range query["label"] {
+		key, value, _ := strings.Cut(pair, "=")
+		if key == "" {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// handleConfigRollback restores a previously applied config version
+func handleConfigRollback(configLoader *config.Loader, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+
+		var req struct {
+			Version int `json:"version"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Version < 1 {
+			writeJSONError(w, http.StatusBadRequest, "body must be {\"version\": <n>}")
+			return
+		}
+
+		version, err := configLoader.Rollback(req.Version)
+		switch {
+		case errors.Is(err, config.ErrVersionNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		case errors.Is(err, config.ErrConfigRejected):
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			logger.Error("Config rollback failed", zap.Error(err))
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(version)
+	}
+}
+
+// principalKey is the request context key of the authenticated principal
+type principalKey struct{}
+
+// mutating guards a route that changes server state. Reads pass through.
+// Other requests need the credentials WebSocket clients use, and POSTs
+// must declare a JSON body, which a cross-site form cannot send without a
+// CORS preflight this server never grants. Credentials limited to certain
+// payers are refused unless payerScoped, in which case the handler checks
+// the payer against the principal in the request context.
+func mutating(wsHub *hub.Hub, logger *zap.Logger, payerScoped bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		principal, err := wsHub.Authenticator().Authenticate(r)
+		if err != nil {
+			logger.Warn("API authentication failed",
+				zap.String("path", r.URL.Path),
+				zap.String("remote_addr", r.RemoteAddr),
+				zap.Error(err))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="payer-status"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if principal.Payers != nil && !payerScoped {
+			writeJSONError(w, http.StatusForbidden, "credentials limited to certain payers cannot change server state")
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeJSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// writeJSONError writes an error response in the API's JSON format
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   http.StatusText(status),
+		"message": message,
+	})
+}
+
 // createMetricsServer creates the Prometheus metrics HTTP server
-func createMetricsServer(metrics *metrics.Metrics, logger *zap.Logger) *http.Server {
+func createMetricsServer(port string, metrics *metrics.Metrics, logger *zap.Logger) *http.Server {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", metrics.Handler())
 	
 	return &http.Server{
-		Addr:         ":" + getEnv("METRICS_PORT", defaultMetricsPort),
+		Addr:         ":" + port,
 		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
@@ -290,9 +878,140 @@ func createMetricsServer(metrics *metrics.Metrics, logger *zap.Logger) *http.Ser
 	}
 }
 
-// initLogger initializes the structured logger
-func initLogger() *zap.Logger {
-	logLevel := getEnv("LOG_LEVEL", defaultLogLevel)
+// sourceOptions selects where the configuration comes from
+type sourceOptions struct {
+	path   string        // Local file, or file within the Git checkout
+	url    string        // Configuration service URL
+	gitDir string        // Git checkout directory
+	cache  string        // Last good remote document
+	poll   time.Duration // How often remote sources are polled
+}
+
+// remote reports whether the configuration comes from a polled source
+func (o sourceOptions) remote() bool {
+	return o.url != "" || o.gitDir != ""
+}
+
+// source builds the configured remote source, or nil for a local file
+func (o sourceOptions) source() config.ConfigSource {
+	switch {
+	case o.url != "":
+		source := config.NewHTTPSource(o.url)
+		if token := os.Getenv("CONFIG_TOKEN"); token != "" {
+			source.Header.Set("Authorization", "Bearer "+token)
+		}
+		return source
+	case o.gitDir != "":
+		return config.NewGitSource(o.gitDir, o.path)
+	default:
+		return nil
+	}
+}
+
+// parseFlags reads the config source and any server setting overrides from
+// the command line. Only flags that were set override the config file.
+func parseFlags() (sourceOptions, map[string]string) {
+	var opts sourceOptions
+	flag.StringVar(&opts.path, "config", getEnv("CONFIG_PATH", defaultConfigPath), "Path to the payer configuration file, relative to --config-git if set (env CONFIG_PATH)")
+	flag.StringVar(&opts.url, "config-url", os.Getenv("CONFIG_URL"), "Fetch the configuration from this URL instead of a file (env CONFIG_URL)")
+	flag.StringVar(&opts.gitDir, "config-git", os.Getenv("CONFIG_GIT"), "Read the configuration from this Git checkout, pulling it on each poll (env CONFIG_GIT)")
+	flag.StringVar(&opts.cache, "config-cache", getEnv("CONFIG_CACHE", defaultConfigCache), "Last good remote configuration, used when the source is down at startup (env CONFIG_CACHE)")
+	poll := defaultConfigPoll
+	if d, err := time.ParseDuration(os.Getenv("CONFIG_POLL")); err == nil {
+		poll = d
+	}
+	flag.DurationVar(&opts.poll, "config-poll", poll, "How often a remote configuration source is polled (env CONFIG_POLL)")
+
+	settings := config.ServerSettings()
+	values := make(map[string]*string, len(settings))
+	keys := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		help := setting.Help + " (env " + setting.Env + ")"
+		if setting.Bool {
+			values[setting.Flag] = new(string)
+			flag.Var(boolFlag{values[setting.Flag]}, setting.Flag, help)
+		} else {
+			values[setting.Flag] = flag.String(setting.Flag, "", help)
+		}
+		keys[setting.Flag] = setting.Key
+	}
+	flag.Usage = usage
+	flag.Parse()
+
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := keys[f.Name]; ok {
+			overrides[key] = *values[f.Name]
+		}
+	})
+
+	return opts, overrides
+}
+
+// boolFlag is a string-valued flag that may be given without a value
+type boolFlag struct {
+	value *string
+}
+
+func (f boolFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f boolFlag) Set(value string) error {
+	*f.value = value
+	return nil
+}
+
+func (f boolFlag) IsBoolFlag() bool { return true }
+
+// usage prints the server flags followed by the subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n       %s <command> [flags]\n\nFlags:\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+
+	fmt.Fprintf(out, "\nCommands:\n")
+	cmds := commands()
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, cmds[name].summary)
+	}
+}
+
+// configureWebSocketAuth loads the API keys and JWKS named by the settings
+// and applies them, with the allowed origins, to the hub
+func configureWebSocketAuth(wsHub *hub.Hub, settings config.ServerConfig) error {
+	authenticator, err := auth.Load(settings)
+	if err != nil {
+		return err
+	}
+	wsHub.SetAuthenticator(authenticator)
+	wsHub.SetOriginPatterns(settings.WSOriginPatterns())
+	return nil
+}
+
+// gateLimits converts server settings into global and per-host gate limits
+func gateLimits(settings config.ServerConfig) (scheduler.Limits, scheduler.Limits) {
+	return scheduler.Limits{
+			MaxInFlight:       settings.MaxInFlight,
+			RequestsPerSecond: settings.MaxRPS,
+		}, scheduler.Limits{
+			MaxInFlight:       settings.HostMaxInFlight,
+			RequestsPerSecond: settings.HostMaxRPS,
+		}
+}
+
+// initLogger initializes the structured logger. The level starts from
+// LOG_LEVEL and can be changed later through the returned AtomicLevel.
+func initLogger() (*zap.Logger, zap.AtomicLevel) {
+	logLevel := getEnv("LOG_LEVEL", config.DefaultServerConfig().LogLevel)
 	
 	var config zap.Config
 	if logLevel == "debug" {
@@ -301,24 +1020,28 @@ func initLogger() *zap.Logger {
 		config = zap.NewProductionConfig()
 	}
 	
-	// Parse log level
-	switch logLevel {
-	case "debug":
-		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	case "info":
-		config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-	case "warn":
-		config.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
-	case "error":
-		config.Level = zap.NewAtomicLevelAt(zap.ErrorLevel)
-	}
+	config.Level = zap.NewAtomicLevelAt(parseLogLevel(logLevel))
 	
 	logger, err := config.Build()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize logger: %v", err))
 	}
 	
-	return logger
+	return logger, config.Level
+}
+
+// parseLogLevel maps a configured level name to a zap level
+func parseLogLevel(level string) zapcore.Level {
+	switch level {
+	case "debug":
+		return zap.DebugLevel
+	case "warn":
+		return zap.WarnLevel
+	case "error":
+		return zap.ErrorLevel
+	default:
+		return zap.InfoLevel
+	}
 }
 
 // getEnv gets an environment variable with a default value
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+	"payer-status-io/internal/scheduler"
+)
+
+func TestWebSocketProbeThrottled(t *testing.T) {
+	cfg := &config.Config{Payers: []config.Payer{{
+		Name:      "Acme",
+		Endpoints: []config.Endpoint{{Type: "login", URL: "https://acme.example.com/", Schedule: time.Hour}},
+	}}}
+	taskScheduler := scheduler.New(zap.NewNop(), 10)
+	taskScheduler.LoadConfig(cfg)
+
+	wsHub := hub.New(zap.NewNop())
+	wsHub.SetPayers(cfg.Payers)
+	wsHub.SetTrigger(probeTrigger(taskScheduler))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wsHub.Run(ctx)
+	}()
+	server := httptest.NewServer(http.HandlerFunc(wsHub.HandleWebSocket))
+	defer func() {
+		server.Close()
+		cancel()
+		<-done
+	}()
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dialCancel()
+	conn, _, err := websocket.Dial(dialCtx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{Subprotocols: []string{hub.ProtocolV1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	probe := func() map[string]interface{} {
+		t.Helper()
+		if err := wsjson.Write(dialCtx, conn, map[string]string{"action": "probe", "id": "p", "payer": "Acme"}); err != nil {
+			t.Fatal(err)
+		}
+		var reply map[string]interface{}
+		if err := wsjson.Read(dialCtx, conn, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := probe(); reply["correlation_id"] == nil {
+		t.Fatalf("first probe got %v, want it accepted", reply)
+	}
+	reply := probe()
+	if reply["code"] != hub.ErrorThrottled || reply["correlation_id"] != nil {
+		t.Errorf("probe within the minimum gap got %v, want a throttled error", reply)
+	}
+	if message, _ := reply["message"].(string); !strings.Contains(message, "retry in 30s") {
+		t.Errorf("throttled message %q does not say when to retry", message)
+	}
+}
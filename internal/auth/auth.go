@@ -0,0 +1,193 @@
+// Package auth authenticates WebSocket clients with API keys or JWTs and
+// limits which payers they may see
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"payer-status-io/internal/config"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries no token or key
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned for an unknown key or a bad token
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated client
+type Principal struct {
+	Subject string   // Key name or JWT subject
+	Payers  []string // Payers the client may see, nil for all
+}
+
+// AllowsPayer reports whether the client may see results for payer
+func (p *Principal) AllowsPayer(payer string) bool {
+	if p == nil || p.Payers == nil {
+		return true
+	}
+	for _, allowed := range p.Payers {
+		if allowed == payer {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is one entry of the API keys file
+type APIKey struct {
+	Name   string   `yaml:"name"`
+	Key    string   `yaml:"key"`              // ${VAR} is expanded
+	Payers []string `yaml:"payers,omitempty"` // Empty means every payer
+}
+
+// apiKeysFile is the format of ws_api_keys_file
+type apiKeysFile struct {
+	Keys []APIKey `yaml:"keys"`
+}
+
+// Authenticator checks the credentials of WebSocket clients. Without API
+// keys or a JWKS it is disabled and lets everyone in.
+type Authenticator struct {
+	keys     []APIKey
+	jwks     *JWKS
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// Load builds an Authenticator from the server settings, reading the API
+// keys and JWKS files they name
+func Load(settings config.ServerConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		issuer:   settings.WSJWTIssuer,
+		audience: settings.WSJWTAudience,
+		now:      time.Now,
+	}
+
+	if settings.WSAPIKeysFile != "" {
+		keys, err := loadAPIKeys(settings.WSAPIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+	}
+
+	if settings.WSJWKSFile != "" {
+		jwks, err := LoadJWKS(settings.WSJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = jwks
+	}
+
+	return a, nil
+}
+
+// loadAPIKeys reads and checks an API keys file
+func loadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file %s: %w", path, err)
+	}
+
+	var file apiKeysFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file %s: %w", path, err)
+	}
+
+	for i := range file.Keys {
+		key := &file.Keys[i]
+		key.Key = os.ExpandEnv(key.Key)
+		if key.Name == "" {
+			return nil, fmt.Errorf("API keys file %s: key at index %d has no name", path, i)
+		}
+		if len(key.Key) < 16 {
+			return nil, fmt.Errorf("API keys file %s: key %s is shorter than 16 characters", path, key.Name)
+		}
+	}
+	return file.Keys, nil
+}
+
+// Enabled reports whether clients must authenticate
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.keys) > 0 || a.jwks != nil)
+}
+
+// Authenticate checks the credentials of a request. API keys are read from
+// the X-API-Key header or api_key query parameter; JWTs from a bearer
+// Authorization header or the access_token query parameter, since browsers
+// cannot set headers on a WebSocket upgrade.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if !a.Enabled() {
+		return &Principal{}, nil
+	}
+
+	query := r.URL.Query()
+	if key := firstNonEmpty(r.Header.Get("X-API-Key"), query.Get("api_key")); key != "" {
+		return a.checkAPIKey(key)
+	}
+
+	token := query.Get("access_token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = strings.TrimSpace(bearer)
+	}
+	if token != "" {
+		return a.checkJWT(token)
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// checkAPIKey looks the key up, comparing in constant time
+func (a *Authenticator) checkAPIKey(key string) (*Principal, error) {
+	var match *APIKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(a.keys[i].Key), []byte(key)) == 1 {
+			match = &a.keys[i]
+		}
+	}
+	if match == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	principal := &Principal{Subject: match.Name}
+	if len(match.Payers) > 0 {
+		principal.Payers = match.Payers
+	}
+	return principal, nil
+}
+
+// checkJWT verifies a token against the JWKS and reads its payers claim
+func (a *Authenticator) checkJWT(token string) (*Principal, error) {
+	if a.jwks == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims, err := a.jwks.Verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if err := claims.check(a.now(), a.issuer, a.audience); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	return &Principal{Subject: claims.Subject, Payers: claims.Payers}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register the hashes used by verifySignature
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far exp and nbf may be off before a token is rejected
+const clockSkew = time.Minute
+
+// ecCurveBits pairs each ECDSA algorithm with its curve size
+var ecCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// jwk is one key of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS is a set of public keys that verify JWT signatures
+type JWKS struct {
+	keys []verifyKey
+}
+
+// verifyKey is a parsed JWK
+type verifyKey struct {
+	kid string
+	alg string // Empty when the JWK does not pin an algorithm
+	key crypto.PublicKey
+}
+
+// LoadJWKS reads a JWKS file. RSA, EC (P-256, P-384, P-521) and Ed25519
+// keys are supported; keys marked for encryption are skipped.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file %s: %w", path, err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %s: %w", path, err)
+	}
+
+	jwks := &JWKS{}
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS file %s: key %d (%s): %w", path, i, k.Kid, err)
+		}
+		jwks.keys = append(jwks.keys, verifyKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(jwks.keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s has no signing keys", path)
+	}
+	return jwks, nil
+}
+
+// publicKey converts the JWK to a Go public key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid e")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Claims are the JWT claims the server reads
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Payers    []string `json:"payers"` // Absent means every payer
+}
+
+// audience accepts the aud claim as a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("aud must be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+// check validates the time, issuer and audience claims
+func (c *Claims) check(now time.Time, issuer, aud string) error {
+	if c.ExpiresAt == nil {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(time.Unix(*c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("token has expired")
+	}
+	if c.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(*c.NotBefore, 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if aud != "" {
+		found := false
+		for _, a := range c.Audience {
+			found = found || a == aud
+		}
+		if !found {
+			return fmt.Errorf("token is not for audience %q", aud)
+		}
+	}
+	return nil
+}
+
+// Verify checks a compact JWT's signature and returns its claims. Only
+// asymmetric algorithms are accepted, so a leaked JWKS cannot mint tokens.
+func (s *JWKS) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	key, err := s.find(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	return &claims, nil
+}
+
+// find picks the key named by kid, or the only key when the token has none
+func (s *JWKS) find(kid, alg string) (crypto.PublicKey, error) {
+	for _, k := range s.keys {
+		if k.kid != kid && !(kid == "" && len(s.keys) == 1) {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			return nil, fmt.Errorf("key %q is not for %s", kid, alg)
+		}
+		return k.key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// verifySignature checks a signature made with one of the RS, PS, ES or
+// EdDSA algorithms
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	invalid := errors.New("invalid signature")
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		default:
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		if err != nil {
+			return invalid
+		}
+
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" || ecCurveBits[alg] != pub.Curve.Params().BitSize {
+			return fmt.Errorf("algorithm %s does not match an EC %s key", alg, pub.Curve.Params().Name)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		sig := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, sig) {
+			return invalid
+		}
+
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("algorithm %s does not match an Ed25519 key", alg)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return invalid
+		}
+
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
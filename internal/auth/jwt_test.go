@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+var testNow = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "payer-status"
+)
+
+// signer is a private key with the JWK of its public half
+type signer struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// testKeys are generated once; RSA key generation is slow
+var testKeys = func() map[string]*signer {
+	rsaKey := mustKey(rsa.GenerateKey(rand.Reader, 2048))
+	otherRSA := mustKey(rsa.GenerateKey(rand.Reader, 2048))
+	keys := map[string]*signer{
+		"rsa":       {kid: "rsa", key: rsaKey},
+		"rsa-other": {kid: "rsa", key: otherRSA},
+		"rs-pinned": {kid: "rs-pinned", alg: "RS256", key: rsaKey},
+	}
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		name := curve.Params().Name
+		keys[name] = &signer{kid: name, key: mustKey(ecdsa.GenerateKey(curve, rand.Reader))}
+		keys[name+"-other"] = &signer{kid: name, key: mustKey(ecdsa.GenerateKey(curve, rand.Reader))}
+	}
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherEd, _ := ed25519.GenerateKey(rand.Reader)
+	keys["ed25519"] = &signer{kid: "ed25519", key: ed}
+	keys["ed25519-other"] = &signer{kid: "ed25519", key: otherEd}
+	return keys
+}()
+
+func mustKey[K any](key K, err error) K {
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// jwk returns the public JWK of the signer
+func (s *signer) jwk() map[string]string {
+	encode := base64.RawURLEncoding.EncodeToString
+	k := map[string]string{"kid": s.kid, "use": "sig"}
+	if s.alg != "" {
+		k["alg"] = s.alg
+	}
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		k["kty"], k["n"], k["e"] = "RSA", encode(pub.N.Bytes()), encode(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		k["kty"], k["crv"] = "EC", pub.Curve.Params().Name
+		k["x"], k["y"] = encode(pub.X.FillBytes(make([]byte, size))), encode(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		k["kty"], k["crv"], k["x"] = "OKP", "Ed25519", encode(pub)
+	}
+	return k
+}
+
+// sign makes a compact JWT with the given header algorithm and claims
+func (s *signer) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	signed := segment(t, map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"}) + "." + segment(t, claims)
+
+	var hash crypto.Hash
+	switch alg[len(alg)-3:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	digest := []byte(signed)
+	if hash != 0 {
+		h := hash.New()
+		h.Write(digest)
+		digest = h.Sum(nil)
+	}
+
+	var signature []byte
+	var err error
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		if alg[:2] == "PS" {
+			signature, err = rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		}
+	case *ecdsa.PrivateKey:
+		var r, sig *big.Int
+		r, sig, err = ecdsa.Sign(rand.Reader, key, digest)
+		if err == nil {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = append(r.FillBytes(make([]byte, size)), sig.FillBytes(make([]byte, size))...)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func segment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// validClaims are accepted by the authenticator from newAuthenticator
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":    "dashboard",
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"exp":    testNow.Add(time.Hour).Unix(),
+		"nbf":    testNow.Add(-time.Hour).Unix(),
+		"payers": []string{"Acme"},
+	}
+}
+
+// writeJWKS writes the public keys of the named signers to path
+func writeJWKS(t *testing.T, path string, names ...string) {
+	t.Helper()
+	set := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	for _, name := range names {
+		set.Keys = append(set.Keys, testKeys[name].jwk())
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newAuthenticator loads an authenticator trusting the named signers, with
+// a fixed clock
+func newAuthenticator(t *testing.T, path string, names ...string) *Authenticator {
+	t.Helper()
+	writeJWKS(t, path, names...)
+	a, err := Load(config.ServerConfig{WSJWKSFile: path, WSJWTIssuer: testIssuer, WSJWTAudience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+func authenticate(a *Authenticator, token string) (*Principal, error) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(r)
+}
+
+var trusted = []string{"rsa", "rs-pinned", "P-256", "P-384", "P-521", "ed25519"}
+
+func TestJWTAlgorithms(t *testing.T) {
+	a := newAuthenticator(t, filepath.Join(t.TempDir(), "jwks.json"), trusted...)
+
+	tests := []struct {
+		alg   string
+		key   string // Signs the token
+		other string // Same kid and type, but not in the JWKS
+	}{
+		{"RS256", "rsa", "rsa-other"},
+		{"RS384", "rsa", "rsa-other"},
+		{"RS512", "rsa", "rsa-other"},
+		{"PS256", "rsa", "rsa-other"},
+		{"PS384", "rsa", "rsa-other"},
+		{"PS512", "rsa", "rsa-other"},
+		{"ES256", "P-256", "P-256-other"},
+		{"ES384", "P-384", "P-384-other"},
+		{"ES512", "P-521", "P-521-other"},
+		{"EdDSA", "ed25519", "ed25519-other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			token := testKeys[tt.key].sign(t, tt.alg, validClaims())
+			principal, err := authenticate(a, token)
+			if err != nil {
+				t.Fatalf("valid token rejected: %v", err)
+			}
+			if principal.Subject != "dashboard" || len(principal.Payers) != 1 || principal.Payers[0] != "Acme" {
+				t.Errorf("principal = %+v, want dashboard limited to Acme", principal)
+			}
+
+			forged := testKeys[tt.other].sign(t, tt.alg, validClaims())
+			tampered := token[:len(token)-4] + "AAAA"
+			claims := validClaims()
+			claims["payers"] = nil
+			parts := strings.Split(token, ".")
+			swapped := parts[0] + "." + segment(t, claims) + "." + parts[2]
+
+			for name, bad := range map[string]string{
+				"signed by another key": forged,
+				"tampered signature":    tampered,
+				"tampered claims":       swapped,
+				"signature stripped":    parts[0] + "." + parts[1] + ".",
+			} {
+				if _, err := authenticate(a, bad); !errors.Is(err, ErrInvalidCredentials) {
+					t.Errorf("%s: err = %v, want ErrInvalidCredentials", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTAlgorithmConfusion(t *testing.T) {
+	a := newAuthenticator(t, filepath.Join(t.TempDir(), "jwks.json"), trusted...)
+	claims := segment(t, validClaims())
+
+	// An HMAC keyed with the RSA public key, as published in the JWKS
+	public, err := x509.MarshalPKIXPublicKey(testKeys["rsa"].key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs256 := segment(t, map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + claims
+	mac := hmac.New(sha256.New, public)
+	mac.Write([]byte(hs256))
+	hs256 += "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", segment(t, map[string]string{"alg": "none", "kid": "rsa"}) + "." + claims + "."},
+		{"alg none without kid", segment(t, map[string]string{"alg": "none"}) + "." + claims + "."},
+		{"alg NONE", segment(t, map[string]string{"alg": "NONE", "kid": "rsa"}) + "." + claims + "."},
+		{"HS256 keyed with the public key", hs256},
+		{"RS256 header on an EC key", testKeys["P-256"].sign(t, "RS256", validClaims())},
+		{"ES384 header on a P-256 key", testKeys["P-256"].sign(t, "ES384", validClaims())},
+		{"ES256 header on an Ed25519 key", testKeys["ed25519"].sign(t, "ES256", validClaims())},
+		{"EdDSA header on an RSA key", testKeys["rsa"].sign(t, "EdDSA", validClaims())},
+		{"PS256 on a key pinned to RS256", testKeys["rs-pinned"].sign(t, "PS256", validClaims())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := authenticate(a, tt.token); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("err = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+
+	// The pinned key still verifies its own algorithm
+	if _, err := authenticate(a, testKeys["rs-pinned"].sign(t, "RS256", validClaims())); err != nil {
+		t.Errorf("RS256 on the pinned key rejected: %v", err)
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	a := newAuthenticator(t, filepath.Join(t.TempDir(), "jwks.json"), trusted...)
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		valid  bool
+	}{
+		{"valid", func(map[string]interface{}) {}, true},
+		{"expired", func(c map[string]interface{}) { c["exp"] = testNow.Add(-2 * time.Minute).Unix() }, false},
+		{"expired within clock skew", func(c map[string]interface{}) { c["exp"] = testNow.Add(-30 * time.Second).Unix() }, true},
+		{"no exp", func(c map[string]interface{}) { delete(c, "exp") }, false},
+		{"not valid yet", func(c map[string]interface{}) { c["nbf"] = testNow.Add(2 * time.Minute).Unix() }, false},
+		{"nbf within clock skew", func(c map[string]interface{}) { c["nbf"] = testNow.Add(30 * time.Second).Unix() }, true},
+		{"no nbf", func(c map[string]interface{}) { delete(c, "nbf") }, true},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, false},
+		{"no issuer", func(c map[string]interface{}) { delete(c, "iss") }, false},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "billing" }, false},
+		{"no audience", func(c map[string]interface{}) { delete(c, "aud") }, false},
+		{"audience list", func(c map[string]interface{}) { c["aud"] = []string{"billing", testAudience} }, true},
+		{"audience list without ours", func(c map[string]interface{}) { c["aud"] = []string{"billing"} }, false},
+		{"malformed audience", func(c map[string]interface{}) { c["aud"] = 42 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.change(claims)
+			_, err := authenticate(a, testKeys["P-256"].sign(t, "ES256", claims))
+			if tt.valid && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("err = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestJWTUnknownKid(t *testing.T) {
+	a := newAuthenticator(t, filepath.Join(t.TempDir(), "jwks.json"), "P-256", "ed25519")
+
+	unknown := *testKeys["P-384"]
+	unknown.kid = "retired"
+	_, err := authenticate(a, unknown.sign(t, "ES384", validClaims()))
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown kid: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// Without a kid, a token only matches a JWKS with a single key
+	noKid := *testKeys["P-256"]
+	noKid.kid = ""
+	if _, err := authenticate(a, noKid.sign(t, "ES256", validClaims())); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("no kid with two keys: err = %v, want ErrInvalidCredentials", err)
+	}
+	single := newAuthenticator(t, filepath.Join(t.TempDir(), "jwks.json"), "P-256")
+	if _, err := authenticate(single, noKid.sign(t, "ES256", validClaims())); err != nil {
+		t.Errorf("no kid with one key rejected: %v", err)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	before := newAuthenticator(t, path, "P-256")
+
+	token := testKeys["ed25519"].sign(t, "EdDSA", validClaims())
+	if _, err := authenticate(before, token); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("token of a key not yet published: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// Rotate: publish the new key and retire the old one, as a reload does
+	after := newAuthenticator(t, path, "ed25519")
+	if _, err := authenticate(after, token); err != nil {
+		t.Errorf("token of the new key rejected after reload: %v", err)
+	}
+	old := testKeys["P-256"].sign(t, "ES256", validClaims())
+	if _, err := authenticate(after, old); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("token of the retired key: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// A JWKS that no longer parses fails the reload instead of emptying it
+	if err := os.WriteFile(path, []byte(`{"keys": [{"kty": "RSA", "kid": "broken"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(config.ServerConfig{WSJWKSFile: path}); err == nil {
+		t.Error("Load accepted a JWKS with a broken key")
+	}
+}
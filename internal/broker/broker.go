@@ -0,0 +1,333 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// Publish results reported to the Observer
+const (
+	ResultPublished = "published"
+	ResultFailed    = "failed"
+	ResultDropped   = "dropped" // The publisher's queue was full
+)
+
+// Publisher sends messages to a broker. Implementations reconnect on
+// their own and may buffer messages while they do.
+type Publisher interface {
+	Publish(ctx context.Context, subject string, payload []byte) error
+	Close()
+}
+
+// NewPublisher connects to the publisher's broker. Connecting does not
+// wait for the broker to be reachable.
+func NewPublisher(cfg config.Publisher, logger *zap.Logger) (Publisher, error) {
+	logger = logger.With(zap.String("publisher", cfg.Name))
+	switch cfg.Kind {
+	case config.BrokerNATS:
+		return newNATSPublisher(cfg, logger)
+	case config.BrokerMQTT:
+		return newMQTTPublisher(cfg, logger)
+	default:
+		return nil, fmt.Errorf("unknown broker kind %q", cfg.Kind)
+	}
+}
+
+// Check reports whether Configure would accept the publishers, short of
+// connecting to their brokers
+func Check(publishers []config.Publisher) error {
+	for _, cfg := range publishers {
+		if err := checkPublisher(cfg); err != nil {
+			return fmt.Errorf("publisher %s: %w", cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkPublisher compiles a publisher's filter and parses its broker URLs
+// as its client would
+func checkPublisher(cfg config.Publisher) error {
+	if _, err := hub.NewFilter(cfg.Filter); err != nil {
+		return err
+	}
+
+	servers := []string{os.ExpandEnv(cfg.URL)}
+	switch cfg.Kind {
+	case config.BrokerNATS:
+		// NATS takes a comma-separated list and defaults the scheme
+		servers = strings.Split(servers[0], ",")
+		for i, server := range servers {
+			if server = strings.TrimSpace(server); !strings.Contains(server, "://") {
+				server = "nats://" + server
+			}
+			servers[i] = server
+		}
+	case config.BrokerMQTT:
+	default:
+		return fmt.Errorf("unknown broker kind %q", cfg.Kind)
+	}
+	for _, server := range servers {
+		if _, err := url.Parse(server); err != nil {
+			return fmt.Errorf("invalid broker URL: %w", err)
+		}
+	}
+	return nil
+}
+
+// Observer receives publish outcomes, typically to export them as metrics
+type Observer interface {
+	RecordBrokerPublish(publisher, result string)
+}
+
+// Dispatcher publishes broadcast messages to the configured brokers. It
+// is a hub.Sink: each publisher has a queue and a goroutine, so a slow
+// broker delays neither the hub nor the other publishers.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	targets  map[string]*target
+	closed   bool
+	observer Observer
+
+	logger *zap.Logger
+
+	// ctx is cancelled when Run is stopping, cutting publishes short
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// target is one configured publisher and its queue
+type target struct {
+	config.Publisher
+	publisher Publisher
+	filter    *hub.Filter
+	events    map[string]bool
+	queue     chan message
+}
+
+// message is an envelope queued for publishing, already encoded
+type message struct {
+	subject string
+	payload []byte
+}
+
+// New creates a dispatcher with no publishers
+func New(logger *zap.Logger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		targets: make(map[string]*target),
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// SetObserver installs the receiver of publish outcomes
+func (d *Dispatcher) SetObserver(observer Observer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observer = observer
+}
+
+// Configure replaces the publishers. Publishers whose settings are
+// unchanged keep their connection; removed or changed ones publish what
+// they have queued and then disconnect.
+func (d *Dispatcher) Configure(publishers []config.Publisher) error {
+	d.mu.RLock()
+	current := d.targets
+	d.mu.RUnlock()
+
+	// Connect outside the lock so broadcasts are not held up
+	next := make(map[string]*target, len(publishers))
+	for _, cfg := range publishers {
+		if old, ok := current[cfg.Name]; ok && reflect.DeepEqual(cfg, old.Publisher) {
+			next[cfg.Name] = old
+			continue
+		}
+		t, err := newTarget(cfg, d.logger)
+		if err != nil {
+			closeTargets(next, current)
+			return fmt.Errorf("publisher %s: %w", cfg.Name, err)
+		}
+		next[cfg.Name] = t
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		closeTargets(next, current)
+		return fmt.Errorf("dispatcher is stopped")
+	}
+
+	for name, old := range d.targets {
+		if next[name] != old {
+			close(old.queue)
+		}
+	}
+	for _, t := range next {
+		if t.queue == nil {
+			t.queue = make(chan message, t.GetQueueSize())
+			d.workers.Add(1)
+			go d.publish(t)
+		}
+	}
+	d.targets = next
+
+	if len(next) > 0 {
+		d.logger.Info("Broker publishers configured", zap.Int("publishers", len(next)))
+	}
+	return nil
+}
+
+// newTarget compiles a publisher's filter and connects to its broker
+func newTarget(cfg config.Publisher, logger *zap.Logger) (*target, error) {
+	filter, err := hub.NewFilter(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	publisher, err := NewPublisher(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	events := make(map[string]bool)
+	for _, event := range cfg.GetEvents() {
+		events[event] = true
+	}
+	return &target{Publisher: cfg, publisher: publisher, filter: filter, events: events}, nil
+}
+
+// closeTargets disconnects the publishers Configure connected before it
+// failed, leaving the current ones alone
+func closeTargets(created, current map[string]*target) {
+	for name, t := range created {
+		if current[name] != t {
+			t.publisher.Close()
+		}
+	}
+}
+
+// Publish queues a probe result or incident for the publishers that want
+// it. Muted results, their incidents and pause notices are not published.
+// It implements hub.Sink and never blocks.
+func (d *Dispatcher) Publish(envelope *hub.Envelope, result *config.ProbeResult, changed bool) {
+	if !result.Alerting() {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed || len(d.targets) == 0 {
+		return
+	}
+
+	var payload []byte
+	for _, t := range d.targets {
+		if !t.events[envelope.Type] || !t.filter.Matches(result, changed) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(envelope); err != nil {
+				d.logger.Error("Failed to encode broker message", zap.Error(err))
+				return
+			}
+		}
+
+		select {
+		case t.queue <- message{subject: Subject(t.Kind, t.GetSubject(), envelope.Type, result), payload: payload}:
+		default:
+			d.logger.Warn("Publisher queue full, dropping message",
+				zap.String("publisher", t.Name),
+				zap.Uint64("seq", envelope.Seq))
+			if d.observer != nil {
+				d.observer.RecordBrokerPublish(t.Name, ResultDropped)
+			}
+		}
+	}
+}
+
+// Run waits for ctx to end, then disconnects the publishers. Messages
+// still queued are only published if the broker takes them at once.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	d.mu.Lock()
+	d.closed = true
+	d.cancel()
+	for _, t := range d.targets {
+		close(t.queue)
+	}
+	d.mu.Unlock()
+
+	d.workers.Wait()
+	d.logger.Info("Broker dispatcher stopped")
+	return ctx.Err()
+}
+
+// publish sends a publisher's messages in order until its queue is closed
+func (d *Dispatcher) publish(t *target) {
+	defer d.workers.Done()
+	defer t.publisher.Close()
+
+	for msg := range t.queue {
+		ctx, cancel := context.WithTimeout(d.ctx, t.GetTimeout())
+		err := t.publisher.Publish(ctx, msg.subject, msg.payload)
+		cancel()
+
+		result := ResultPublished
+		if err != nil {
+			result = ResultFailed
+			d.logger.Error("Failed to publish to broker",
+				zap.String("publisher", t.Name),
+				zap.String("subject", msg.subject),
+				zap.Error(err))
+		}
+		if observer := d.currentObserver(); observer != nil {
+			observer.RecordBrokerPublish(t.Name, result)
+		}
+	}
+}
+
+// currentObserver returns the observer for the publishing goroutines
+func (d *Dispatcher) currentObserver() Observer {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.observer
+}
+
+// Subject fills in a subject template. Characters the broker treats as
+// separators or wildcards are replaced with underscores in the values.
+func Subject(kind, template, event string, result *config.ProbeResult) string {
+	reserved := "/+#"
+	if kind == config.BrokerNATS {
+		reserved = ".*> \t\r\n"
+	}
+	clean := func(value string) string {
+		if value == "" {
+			return "_"
+		}
+		return strings.Map(func(r rune) rune {
+			if strings.ContainsRune(reserved, r) {
+				return '_'
+			}
+			return r
+		}, value)
+	}
+
+	return strings.NewReplacer(
+		"{payer}", clean(result.Payer),
+		"{type}", clean(result.Type),
+		"{event}", clean(event),
+	).Replace(template)
+}
@@ -0,0 +1,200 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+func TestSubject(t *testing.T) {
+	tests := []struct {
+		kind     string
+		template string
+		event    string
+		result   config.ProbeResult
+		want     string
+	}{
+		{config.BrokerNATS, config.DefaultPublisherSubject, hub.MessageProbeResult, config.ProbeResult{Payer: "Acme", Type: "login"}, "payer.Acme.login"},
+		{config.BrokerNATS, config.DefaultPublisherSubject, hub.MessageProbeResult, config.ProbeResult{Payer: "Blue Cross.NY", Type: "patient search"}, "payer.Blue_Cross_NY.patient_search"},
+		{config.BrokerNATS, "alerts.{event}.{payer}", hub.MessageIncident, config.ProbeResult{Payer: "*>", Type: "api"}, "alerts.incident.__"},
+		{config.BrokerNATS, "payer.{payer}.{type}", hub.MessageProbeResult, config.ProbeResult{Payer: "Acme"}, "payer.Acme._"},
+		{config.BrokerMQTT, "payer-status/{event}/{payer}/{type}", hub.MessageIncident, config.ProbeResult{Payer: "U.S. Health", Type: "login"}, "payer-status/incident/U.S. Health/login"},
+		{config.BrokerMQTT, "payer-status/{payer}/{type}", hub.MessageProbeResult, config.ProbeResult{Payer: "A/B+C#", Type: "api"}, "payer-status/A_B_C_/api"},
+		{config.BrokerMQTT, "payer-status/{region}/{payer}", hub.MessageProbeResult, config.ProbeResult{Payer: "Acme"}, "payer-status/{region}/Acme"},
+	}
+	for _, tt := range tests {
+		if got := Subject(tt.kind, tt.template, tt.event, &tt.result); got != tt.want {
+			t.Errorf("Subject(%s, %q, %s, %s/%s) = %q, want %q", tt.kind, tt.template, tt.event, tt.result.Payer, tt.result.Type, got, tt.want)
+		}
+	}
+}
+
+// fakePublisher records what it is asked to publish
+type fakePublisher struct {
+	mu       sync.Mutex
+	messages []message
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, message{subject: subject, payload: payload})
+	return nil
+}
+
+func (p *fakePublisher) Close() {}
+
+// published returns the subject and envelope seq of each message
+func (p *fakePublisher) published(t *testing.T) []string {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	got := make([]string, 0, len(p.messages))
+	for _, msg := range p.messages {
+		var envelope hub.Envelope
+		if err := json.Unmarshal(msg.payload, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.subject+"#"+envelope.Type)
+	}
+	return got
+}
+
+// startFakes runs a dispatcher whose publishers are fakes
+func startFakes(t *testing.T, publishers ...config.Publisher) (*Dispatcher, map[string]*fakePublisher, func()) {
+	t.Helper()
+	d := New(zap.NewNop())
+	fakes := make(map[string]*fakePublisher, len(publishers))
+	for _, cfg := range publishers {
+		filter, err := hub.NewFilter(cfg.Filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := make(map[string]bool)
+		for _, event := range cfg.GetEvents() {
+			events[event] = true
+		}
+		fake := &fakePublisher{}
+		fakes[cfg.Name] = fake
+		tgt := &target{Publisher: cfg, publisher: fake, filter: filter, events: events, queue: make(chan message, cfg.GetQueueSize())}
+		d.targets[cfg.Name] = tgt
+		d.workers.Add(1)
+		go d.publish(tgt)
+	}
+	return d, fakes, run(t, d)
+}
+
+// run runs a dispatcher and returns the function that stops it and waits
+// for the queues to drain
+func run(t *testing.T, d *Dispatcher) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func result(payer string, statusCode int) *config.ProbeResult {
+	return &config.ProbeResult{Payer: payer, Type: "login", URL: "https://" + payer + ".example.com", StatusCode: statusCode, Timestamp: time.Now().UTC()}
+}
+
+// broadcast publishes a result and, when it changed health, its incident,
+// as the hub does
+func broadcast(d *Dispatcher, seq uint64, r *config.ProbeResult, changed bool) {
+	d.Publish(&hub.Envelope{Type: hub.MessageProbeResult, Seq: seq, Data: r}, r, changed)
+	if changed {
+		d.Publish(&hub.Envelope{Type: hub.MessageIncident, Seq: seq + 1, Data: r}, r, changed)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPublishSelectsEventsAndFilters(t *testing.T) {
+	d, fakes, stop := startFakes(t,
+		config.Publisher{Name: "all", Kind: config.BrokerNATS},
+		config.Publisher{Name: "incidents", Kind: config.BrokerMQTT, Subject: "status/{event}/{payer}", Events: []string{hub.MessageIncident}},
+		config.Publisher{Name: "acme", Kind: config.BrokerNATS, Filter: config.EventFilter{Payers: []string{"Acme"}}},
+		config.Publisher{Name: "down", Kind: config.BrokerNATS, Filter: config.EventFilter{Status: "unhealthy"}},
+	)
+
+	broadcast(d, 1, result("Acme", 200), false)
+	broadcast(d, 2, result("Globex", 503), true)
+	broadcast(d, 4, result("Acme", 500), true)
+	stop()
+
+	want := map[string][]string{
+		"all": {
+			"payer.Acme.login#probe_result",
+			"payer.Globex.login#probe_result",
+			"payer.Globex.login#incident",
+			"payer.Acme.login#probe_result",
+			"payer.Acme.login#incident",
+		},
+		"incidents": {
+			"status/incident/Globex#incident",
+			"status/incident/Acme#incident",
+		},
+		"acme": {
+			"payer.Acme.login#probe_result",
+			"payer.Acme.login#probe_result",
+			"payer.Acme.login#incident",
+		},
+		"down": {
+			"payer.Globex.login#probe_result",
+			"payer.Globex.login#incident",
+			"payer.Acme.login#probe_result",
+			"payer.Acme.login#incident",
+		},
+	}
+	for name, fake := range fakes {
+		if got := fake.published(t); !equal(got, want[name]) {
+			t.Errorf("%s published %q, want %q", name, got, want[name])
+		}
+	}
+}
+
+func TestPublishSkipsMutedAndPaused(t *testing.T) {
+	d, fakes, stop := startFakes(t, config.Publisher{Name: "all", Kind: config.BrokerNATS})
+
+	muted := result("Muted", 503)
+	muted.Muted = true
+	paused := &config.ProbeResult{Payer: "Paused", Type: "login", URL: "https://paused.example.com", Paused: true}
+	broadcast(d, 1, muted, true)
+	broadcast(d, 3, paused, false)
+	broadcast(d, 4, result("Acme", 200), false)
+	stop()
+
+	want := []string{"payer.Acme.login#probe_result"}
+	if got := fakes["all"].published(t); !equal(got, want) {
+		t.Errorf("published %q, want %q", got, want)
+	}
+}
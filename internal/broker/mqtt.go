@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// disconnectQuiesce is how long Close lets in-flight MQTT messages finish
+const disconnectQuiesce = 250 * time.Millisecond
+
+// mqttPublisher publishes to an MQTT broker. While reconnecting, QoS 0
+// messages are lost; QoS 1 and 2 messages are sent once it is back, even
+// if their Publish already timed out. Before the first connection every
+// message is lost, as the clean session discards them.
+type mqttPublisher struct {
+	client mqtt.Client
+	qos    byte
+	retain bool
+}
+
+func newMQTTPublisher(cfg config.Publisher, logger *zap.Logger) (*mqttPublisher, error) {
+	options := mqtt.NewClientOptions().
+		AddBroker(os.ExpandEnv(cfg.URL)).
+		SetClientID(cfg.GetClientID()).
+		SetUsername(os.ExpandEnv(cfg.Username)).
+		SetPassword(os.ExpandEnv(cfg.Password)).
+		SetConnectTimeout(cfg.GetTimeout()).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("Disconnected from MQTT broker", zap.Error(err))
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logger.Info("Connected to MQTT broker")
+		})
+
+	client := mqtt.NewClient(options)
+	client.Connect() // Retries in the background until the broker is reachable
+	return &mqttPublisher{client: client, qos: byte(cfg.QoS), retain: cfg.Retain}, nil
+}
+
+func (p *mqttPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	token := p.client.Publish(subject, p.qos, p.retain, payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *mqttPublisher) Close() {
+	p.client.Disconnect(uint(disconnectQuiesce.Milliseconds()))
+}
@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+func TestMQTTPublisher(t *testing.T) {
+	server := brokerURL(t, "PAYER_STATUS_TEST_MQTT_URL", "tcp://127.0.0.1:1883")
+
+	received := make(chan mqtt.Message, 10)
+	subscriber := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker(server).
+		SetClientID("payer-status-io-test-subscriber"))
+	if token := subscriber.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connecting the subscriber: %v", token.Error())
+	}
+	defer subscriber.Disconnect(0)
+	token := subscriber.Subscribe("payer-status-test/#", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		received <- msg
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribing: %v", token.Error())
+	}
+
+	d := configured(t, config.Publisher{
+		Name:    "mqtt",
+		Kind:    config.BrokerMQTT,
+		URL:     server,
+		Subject: "payer-status-test/{event}/{payer}/{type}",
+		Events:  []string{hub.MessageProbeResult},
+		QoS:     1,
+	})
+
+	// Messages published before the first connection are lost
+	publisher := d.targets["mqtt"].publisher.(*mqttPublisher)
+	deadline := time.Now().Add(5 * time.Second)
+	for !publisher.client.IsConnectionOpen() {
+		if time.Now().After(deadline) {
+			t.Fatal("publisher did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	broadcast(d, 1, result("A/B", 200), false)
+
+	select {
+	case msg := <-received:
+		if want := "payer-status-test/probe_result/A_B/login"; msg.Topic() != want {
+			t.Errorf("topic = %q, want %q", msg.Topic(), want)
+		}
+		var envelope hub.Envelope
+		if err := json.Unmarshal(msg.Payload(), &envelope); err != nil || envelope.Type != hub.MessageProbeResult || envelope.Seq != 1 {
+			t.Errorf("payload = %s, want the probe_result envelope", msg.Payload())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message received")
+	}
+}
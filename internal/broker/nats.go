@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// natsPublisher publishes to a NATS server. While reconnecting, the client
+// buffers published messages and sends them once it is back.
+type natsPublisher struct {
+	conn    *nats.Conn
+	timeout time.Duration
+}
+
+func newNATSPublisher(cfg config.Publisher, logger *zap.Logger) (*natsPublisher, error) {
+	connected := func(conn *nats.Conn) {
+		logger.Info("Connected to NATS", zap.String("server", conn.ConnectedUrlRedacted()))
+	}
+	options := []nats.Option{
+		nats.Name(cfg.GetClientID()),
+		nats.Timeout(cfg.GetTimeout()),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS", zap.Error(err))
+			}
+		}),
+		nats.ConnectHandler(connected),
+		nats.ReconnectHandler(connected),
+	}
+	if username := os.ExpandEnv(cfg.Username); username != "" {
+		options = append(options, nats.UserInfo(username, os.ExpandEnv(cfg.Password)))
+	}
+
+	conn, err := nats.Connect(os.ExpandEnv(cfg.URL), options...)
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn, timeout: cfg.GetTimeout()}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.conn.Publish(subject, payload)
+}
+
+// Close sends what is buffered, if the server is reachable, and disconnects
+func (p *natsPublisher) Close() {
+	if p.conn.IsConnected() {
+		p.conn.FlushTimeout(p.timeout)
+	}
+	p.conn.Close()
+}
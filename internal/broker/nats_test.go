@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// brokerURL returns the broker named by the environment variable, or the
+// fallback, skipping the test when nothing listens there
+func brokerURL(t *testing.T, env, fallback string) string {
+	t.Helper()
+	server := os.Getenv(env)
+	if server == "" {
+		server = fallback
+	}
+	parsed, err := url.Parse(server)
+	if err != nil {
+		t.Fatalf("%s: %v", env, err)
+	}
+	conn, err := net.DialTimeout("tcp", parsed.Host, time.Second)
+	if err != nil {
+		t.Skipf("no broker at %s (set %s): %v", server, env, err)
+	}
+	conn.Close()
+	return server
+}
+
+// configured runs a dispatcher with one real publisher
+func configured(t *testing.T, cfg config.Publisher) *Dispatcher {
+	t.Helper()
+	d := New(zap.NewNop())
+	if err := d.Configure([]config.Publisher{cfg}); err != nil {
+		t.Fatal(err)
+	}
+	run(t, d)
+	return d
+}
+
+func TestNATSPublisher(t *testing.T) {
+	server := brokerURL(t, "PAYER_STATUS_TEST_NATS_URL", "nats://127.0.0.1:4222")
+
+	subscriber, err := nats.Connect(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+	sub, err := subscriber.SubscribeSync("payer-status-test.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriber.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := configured(t, config.Publisher{
+		Name:    "nats",
+		Kind:    config.BrokerNATS,
+		URL:     server,
+		Subject: "payer-status-test.{event}.{payer}.{type}",
+		Events:  []string{hub.MessageIncident},
+	})
+	broadcast(d, 1, result("Acme.NY", 503), true)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "payer-status-test.incident.Acme_NY.login"; msg.Subject != want {
+		t.Errorf("subject = %q, want %q", msg.Subject, want)
+	}
+	var envelope hub.Envelope
+	if err := json.Unmarshal(msg.Data, &envelope); err != nil || envelope.Type != hub.MessageIncident || envelope.Seq != 2 {
+		t.Errorf("payload = %s, want the incident envelope", msg.Data)
+	}
+}
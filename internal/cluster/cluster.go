@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// Coordinator elects the one instance of a cluster that runs the scheduled
+// probes. Leadership must end when the leading process exits, so another
+// instance can take over.
+type Coordinator interface {
+	// Campaign tries once to become the leader, advertising address to the
+	// other instances. It reports whether this instance leads.
+	Campaign(ctx context.Context, address string) (bool, error)
+
+	// Leader returns the address the leader advertised, or "" if none did
+	Leader(ctx context.Context) (string, error)
+
+	// Resign gives up leadership, if this instance holds it
+	Resign() error
+}
+
+// Node is this instance's membership in a cluster. The leader probes and
+// relays its results to the followers, whose hubs broadcast them to their
+// own clients; followers campaign to take over when the leader goes away.
+type Node struct {
+	coordinator Coordinator
+	hub         *hub.Hub
+	address     string // Base URL the other instances reach this one at
+	secret      string
+	interval    time.Duration
+	client      *http.Client
+	logger      *zap.Logger
+
+	leader   atomic.Bool
+	onChange func(leader bool)
+
+	// trigger runs on-demand probes while this instance leads
+	trigger hub.TriggerFunc
+
+	// followers are the relay streams this instance feeds while it leads;
+	// leaderAddress is the leader's address as last looked up to follow it
+	mu            sync.Mutex
+	followers     map[chan *config.ProbeResult]struct{}
+	leaderAddress string
+
+	// done is closed when Run returns, ending the relay streams
+	done chan struct{}
+}
+
+// New creates a node that relays results into h, using the cluster
+// settings of the server config
+func New(logger *zap.Logger, coordinator Coordinator, h *hub.Hub, settings config.ServerConfig) *Node {
+	return &Node{
+		coordinator: coordinator,
+		hub:         h,
+		address:     settings.ClusterAdvertiseURL,
+		secret:      os.ExpandEnv(settings.ClusterSecret),
+		interval:    settings.ClusterPollInterval,
+		client:      &http.Client{}, // No timeout: relay streams last as long as the leader
+		logger:      logger,
+		followers:   make(map[chan *config.ProbeResult]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// OnLeadershipChange registers the callback run when this instance becomes
+// the leader or stops being it. Call it before Run.
+func (n *Node) OnLeadershipChange(callback func(leader bool)) {
+	n.onChange = callback
+}
+
+// IsLeader reports whether this instance runs the scheduled probes
+func (n *Node) IsLeader() bool {
+	return n.leader.Load()
+}
+
+// Run campaigns for leadership until ctx ends. While another instance
+// leads, it relays that instance's results.
+func (n *Node) Run(ctx context.Context) error {
+	defer close(n.done)
+	defer func() {
+		if err := n.coordinator.Resign(); err != nil {
+			n.logger.Error("Failed to resign cluster leadership", zap.Error(err))
+		}
+	}()
+
+	for {
+		leader, err := n.coordinator.Campaign(ctx, n.address)
+		if err != nil {
+			n.logger.Error("Cluster leader election failed", zap.Error(err))
+		}
+		n.setLeader(leader)
+		if !leader {
+			n.follow(ctx)
+		}
+
+		timer := time.NewTimer(n.interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Leader returns the address of the instance this one follows, or "" if
+// it leads or knows of no leader
+func (n *Node) Leader() string {
+	if n.IsLeader() {
+		return ""
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderAddress
+}
+
+func (n *Node) setLeader(leader bool) {
+	if n.leader.Swap(leader) == leader {
+		return
+	}
+	if leader {
+		n.logger.Info("Became the cluster leader", zap.String("address", n.address))
+	} else {
+		n.logger.Info("Following the cluster leader")
+	}
+	if n.onChange != nil {
+		n.onChange(leader)
+	}
+}
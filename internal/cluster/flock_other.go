@@ -0,0 +1,18 @@
+//go:build !unix
+
+package cluster
+
+import (
+	"errors"
+	"os"
+)
+
+var errUnsupported = errors.New("lock files are not supported on this platform")
+
+func lockFile(file *os.File) error {
+	return errUnsupported
+}
+
+func unlockFile(file *os.File) error {
+	return errUnsupported
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/hub"
+)
+
+// TriggerPath is where followers send the leader their clients' probe
+// requests
+const TriggerPath = "/cluster/probe"
+
+// ForwardedHeader marks a request a follower sent on to the leader, with
+// the follower's address. An instance that is not the leader refuses such
+// requests rather than forwarding them again.
+const ForwardedHeader = "X-Payer-Status-Forwarded-By"
+
+// triggerTimeout bounds a probe request forwarded to the leader
+const triggerTimeout = 10 * time.Second
+
+// SetTrigger installs the handler that runs on-demand probes on this
+// instance, for its own clients while it leads and for the followers'.
+// Call it before Run.
+func (n *Node) SetTrigger(trigger hub.TriggerFunc) {
+	n.trigger = trigger
+}
+
+// Trigger runs a probe request on the leader: here while this instance
+// leads, or by sending it to the leader, whose results are relayed back
+// with the request's correlation ID. It is a hub.TriggerFunc.
+func (n *Node) Trigger(req hub.ProbeRequest) (string, error) {
+	if n.IsLeader() {
+		return n.trigger(req)
+	}
+	leader := n.Leader()
+	if leader == "" {
+		return "", fmt.Errorf("no cluster leader to probe")
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), triggerTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(leader, "/")+TriggerPath, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster leader address: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+n.secret)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(ForwardedHeader, n.address)
+
+	resp, err := n.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("cannot reach the cluster leader: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reply triggerReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("invalid reply from the cluster leader: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		// Keep the reason, so the client gets the same error code
+		return "", fmt.Errorf("cluster leader: %w", throttledError(reply.Message))
+	default:
+		return "", fmt.Errorf("cluster leader: %s", reply.Message)
+	}
+	return reply.CorrelationID, nil
+}
+
+// throttledError is hub.ErrThrottled as the leader described it
+type throttledError string
+
+func (e throttledError) Error() string { return string(e) }
+
+func (e throttledError) Unwrap() error { return hub.ErrThrottled }
+
+// triggerReply is the leader's answer to a forwarded probe request
+type triggerReply struct {
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+// HandleTrigger runs a probe request a follower received from one of its
+// clients. The follower has checked the client may probe the payer.
+func (n *Node) HandleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if !n.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if !n.IsLeader() {
+		writeError(w, http.StatusConflict, "not the cluster leader")
+		return
+	}
+
+	var req hub.ProbeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	correlationID, err := n.trigger(req)
+	switch {
+	case errors.Is(err, hub.ErrThrottled):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(triggerReply{CorrelationID: correlationID})
+}
+
+// Forward sends requests that change what is probed, such as pauses and
+// on-demand probes, on to the leader while another instance leads, so
+// they apply where the probing happens. The leader runs next itself.
+func (n *Node) Forward(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n.IsLeader() {
+			next(w, r)
+			return
+		}
+		if r.Header.Get(ForwardedHeader) != "" {
+			writeError(w, http.StatusServiceUnavailable, "not the cluster leader")
+			return
+		}
+		leader := n.Leader()
+		if leader == "" {
+			w.Header().Set("Retry-After", fmt.Sprint(int(n.interval.Seconds())+1))
+			writeError(w, http.StatusServiceUnavailable, "no cluster leader")
+			return
+		}
+		target, err := url.Parse(leader)
+		if err != nil {
+			n.logger.Error("Invalid cluster leader address", zap.String("leader", leader), zap.Error(err))
+			writeError(w, http.StatusBadGateway, "invalid cluster leader address")
+			return
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+				pr.Out.Header.Set(ForwardedHeader, n.address)
+			},
+			Transport: n.client.Transport,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				n.logger.Warn("Cannot forward request to the cluster leader",
+					zap.String("leader", leader),
+					zap.String("path", r.URL.Path),
+					zap.Error(err))
+				writeError(w, http.StatusBadGateway, "cannot reach the cluster leader")
+			},
+		}
+
+		// Waiting for triggered probes may outlast the server's WriteTimeout,
+		// as it may on the leader
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// writeError writes an error response in the REST API's JSON format
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   http.StatusText(status),
+		"message": message,
+	})
+}
@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// fixedCoordinator elects whichever address it was given
+type fixedCoordinator struct {
+	leader string
+}
+
+func (c *fixedCoordinator) Campaign(ctx context.Context, address string) (bool, error) {
+	return address == c.leader, nil
+}
+
+func (c *fixedCoordinator) Leader(ctx context.Context) (string, error) {
+	return c.leader, nil
+}
+
+func (c *fixedCoordinator) Resign() error { return nil }
+
+// instance is a node behind its own test server
+type instance struct {
+	node *Node
+	mux  *http.ServeMux
+	url  string
+}
+
+// startCluster runs a leader and a follower, returning once the follower
+// has found the leader
+func startCluster(t *testing.T) (leader, follower *instance) {
+	t.Helper()
+	coordinator := &fixedCoordinator{}
+	start := func() *instance {
+		mux := http.NewServeMux()
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		node := New(zap.NewNop(), coordinator, hub.New(zap.NewNop()), config.ServerConfig{
+			ClusterAdvertiseURL: server.URL,
+			ClusterSecret:       "cluster-secret",
+			ClusterPollInterval: 10 * time.Millisecond,
+		})
+		mux.HandleFunc(RelayPath, node.HandleRelay)
+		mux.HandleFunc(TriggerPath, node.HandleTrigger)
+		return &instance{node: node, mux: mux, url: server.URL}
+	}
+	leader, follower = start(), start()
+	coordinator.leader = leader.url
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, i := range []*instance{leader, follower} {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			node.Run(ctx)
+		}(i.node)
+	}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !leader.node.IsLeader() || follower.node.Leader() != leader.url {
+		if time.Now().After(deadline) {
+			t.Fatal("the follower did not find the leader")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return leader, follower
+}
+
+func TestForwardSendsRequestsToTheLeader(t *testing.T) {
+	leader, follower := startCluster(t)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			io.WriteString(w, name+" "+r.Method+" "+r.URL.RequestURI()+" "+string(body)+" "+r.Header.Get(ForwardedHeader))
+		}
+	}
+	leader.mux.HandleFunc("/api/controls", leader.node.Forward(handler("leader")))
+	follower.mux.HandleFunc("/api/controls", follower.node.Forward(handler("follower")))
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{follower.url, "leader POST /api/controls?id=1 {\"action\":\"pause\"} " + follower.url},
+		{leader.url, "leader POST /api/controls?id=1 {\"action\":\"pause\"} "},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(tt.url+"/api/controls?id=1", "application/json", strings.NewReader(`{"action":"pause"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("POST to %s answered %q, want %q", tt.url, body, tt.want)
+		}
+	}
+}
+
+func TestForwardRefusesForwardedRequests(t *testing.T) {
+	_, follower := startCluster(t)
+	follower.mux.HandleFunc("/api/controls", follower.node.Forward(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a follower handled a forwarded request")
+	}))
+
+	req, _ := http.NewRequest(http.MethodDelete, follower.url+"/api/controls?id=1", nil)
+	req.Header.Set(ForwardedHeader, "http://elsewhere")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestTriggerRunsOnTheLeader(t *testing.T) {
+	leader, follower := startCluster(t)
+	leader.node.SetTrigger(func(req hub.ProbeRequest) (string, error) {
+		switch req.Payer {
+		case "Busy":
+			return "", fmt.Errorf("%w; retry in 20s", hub.ErrThrottled)
+		case "Acme":
+		default:
+			return "", errors.New("no endpoint matches")
+		}
+		return "leader-" + req.CorrelationID, nil
+	})
+	follower.node.SetTrigger(func(req hub.ProbeRequest) (string, error) {
+		t.Error("the follower probed")
+		return "", nil
+	})
+
+	for _, node := range []*Node{follower.node, leader.node} {
+		if got, err := node.Trigger(hub.ProbeRequest{Action: "probe", Payer: "Acme", CorrelationID: "c1"}); err != nil || got != "leader-c1" {
+			t.Errorf("Trigger = %q, %v, want leader-c1", got, err)
+		}
+	}
+	_, err := follower.node.Trigger(hub.ProbeRequest{Action: "probe", Payer: "Nobody"})
+	if err == nil || !strings.Contains(err.Error(), "no endpoint matches") {
+		t.Errorf("Trigger error = %v, want the leader's", err)
+	}
+	_, err = follower.node.Trigger(hub.ProbeRequest{Action: "probe", Payer: "Busy"})
+	if !errors.Is(err, hub.ErrThrottled) || !strings.Contains(err.Error(), "retry in 20s") {
+		t.Errorf("Trigger error = %v, want the leader's throttled error", err)
+	}
+}
+
+func TestHandleTriggerNeedsTheSecret(t *testing.T) {
+	leader, _ := startCluster(t)
+	leader.node.SetTrigger(func(req hub.ProbeRequest) (string, error) {
+		t.Error("probed without the cluster secret")
+		return "", nil
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, leader.url+TriggerPath, strings.NewReader(`{"action":"probe","payer":"Acme"}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// FileCoordinator elects the leader with an exclusive lock on a file every
+// instance can reach, e.g. on a shared volume. The operating system drops
+// the lock when the leader exits, however it exits. The file holds the
+// leader's address; the leader replaces it whole, so readers never see it
+// half written, and the lock moves with it to the new file.
+type FileCoordinator struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File // Open and locked while this instance leads
+}
+
+// leaderRecord is the content of the lock file
+type leaderRecord struct {
+	Address string    `json:"address"`
+	Since   time.Time `json:"since"`
+}
+
+// NewFileCoordinator creates a coordinator locking the file at path
+func NewFileCoordinator(path string) *FileCoordinator {
+	return &FileCoordinator{path: path}
+}
+
+// Campaign takes the lock if it is free and writes this instance's address.
+// While leading it checks the locked file is still the one at path: if the
+// file was deleted or replaced, another instance could lock the new one, so
+// this instance steps down and campaigns again.
+func (c *FileCoordinator) Campaign(ctx context.Context, address string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		if c.holdsPath() {
+			return true, nil
+		}
+		c.release()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return false, fmt.Errorf("creating lock file directory: %w", err)
+	}
+	file, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("opening lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errLocked) {
+			return false, nil
+		}
+		return false, fmt.Errorf("locking %s: %w", c.path, err)
+	}
+
+	// The leader may have replaced the file between the open and the lock,
+	// in which case this holds the lock on a file nobody else will open
+	c.file = file
+	if !c.holdsPath() {
+		c.release()
+		return false, nil
+	}
+
+	// Hand the lock over to the file that replaces this one
+	next, err := c.writeRecord(address)
+	c.release()
+	if err != nil {
+		return false, fmt.Errorf("writing lock file: %w", err)
+	}
+	c.file = next
+	return true, nil
+}
+
+// writeRecord writes the leader record to a locked temporary file and
+// renames it over the lock file, returning it still locked
+func (c *FileCoordinator) writeRecord(address string) (*os.File, error) {
+	data, err := json.Marshal(leaderRecord{Address: address, Since: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Chmod(0o644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path)
+	}
+	if err != nil {
+		unlockFile(file)
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// holdsPath reports whether the locked file is still the one at path
+func (c *FileCoordinator) holdsPath() bool {
+	held, err := c.file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(c.path)
+	return err == nil && os.SameFile(held, current)
+}
+
+// release unlocks and closes the locked file
+func (c *FileCoordinator) release() error {
+	err := unlockFile(c.file)
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	c.file = nil
+	return err
+}
+
+// Leader reads the address from the lock file. After a leader exits, the
+// file names it until another instance takes the lock. It is empty until
+// the first leader writes it.
+func (c *FileCoordinator) Leader(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading lock file: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", nil // The leader has not written it yet
+	}
+
+	var record leaderRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return "", fmt.Errorf("parsing lock file: %w", err)
+	}
+	return record.Address, nil
+}
+
+// Resign releases the lock
+func (c *FileCoordinator) Resign() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	return c.release()
+}
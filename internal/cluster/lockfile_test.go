@@ -0,0 +1,177 @@
+//go:build unix
+
+package cluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// campaign runs one campaign, failing the test on errors
+func campaign(t *testing.T, c *FileCoordinator, address string) bool {
+	t.Helper()
+	leader, err := c.Campaign(context.Background(), address)
+	if err != nil {
+		t.Fatalf("Campaign(%s): %v", address, err)
+	}
+	return leader
+}
+
+// leaderOf reads the leader address, failing the test on errors
+func leaderOf(t *testing.T, c *FileCoordinator) string {
+	t.Helper()
+	leader, err := c.Leader(context.Background())
+	if err != nil {
+		t.Fatalf("Leader: %v", err)
+	}
+	return leader
+}
+
+func TestFileCoordinatorTakeoverAfterRelease(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cluster", "leader.lock")
+	a, b := NewFileCoordinator(path), NewFileCoordinator(path)
+	defer a.Resign()
+	defer b.Resign()
+
+	if got := leaderOf(t, b); got != "" {
+		t.Errorf("Leader before any campaign = %q, want none", got)
+	}
+
+	steps := []struct {
+		coordinator *FileCoordinator
+		address     string
+		resign      bool // Resign instead of campaigning
+		want        bool
+		leader      string
+	}{
+		{coordinator: a, address: "http://a", want: true, leader: "http://a"},
+		{coordinator: b, address: "http://b", want: false, leader: "http://a"},
+		{coordinator: a, address: "http://a", want: true, leader: "http://a"},
+		{coordinator: a, resign: true, leader: "http://a"}, // Named until someone takes over
+		{coordinator: b, address: "http://b", want: true, leader: "http://b"},
+		{coordinator: a, address: "http://a", want: false, leader: "http://b"},
+		{coordinator: b, address: "http://b", want: true, leader: "http://b"},
+	}
+	for i, step := range steps {
+		if step.resign {
+			if err := step.coordinator.Resign(); err != nil {
+				t.Fatalf("step %d: Resign: %v", i, err)
+			}
+		} else if got := campaign(t, step.coordinator, step.address); got != step.want {
+			t.Errorf("step %d: Campaign(%s) = %v, want %v", i, step.address, got, step.want)
+		}
+		if got := leaderOf(t, a); got != step.leader {
+			t.Errorf("step %d: Leader = %q, want %q", i, got, step.leader)
+		}
+	}
+
+	// Temporary files are renamed into place, not left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "leader.lock" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("lock directory holds %v, want only leader.lock", names)
+	}
+}
+
+func TestFileCoordinatorReplacedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace func(path string) error
+	}{
+		{"deleted", os.Remove},
+		{"replaced", func(path string) error {
+			tmp := path + ".new"
+			if err := os.WriteFile(tmp, []byte(`{"address":"http://stale"}`+"\n"), 0o644); err != nil {
+				return err
+			}
+			return os.Rename(tmp, path)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "leader.lock")
+			a, b := NewFileCoordinator(path), NewFileCoordinator(path)
+			defer a.Resign()
+			defer b.Resign()
+
+			if !campaign(t, a, "http://a") {
+				t.Fatal("a did not take the free lock")
+			}
+			if err := tt.replace(path); err != nil {
+				t.Fatal(err)
+			}
+
+			// b locks the new file; a must notice and step down rather
+			// than lead alongside it
+			if !campaign(t, b, "http://b") {
+				t.Fatal("b did not take the lock on the new file")
+			}
+			if campaign(t, a, "http://a") {
+				t.Error("a still leads after its lock file was " + tt.name)
+			}
+			if got := leaderOf(t, a); got != "http://b" {
+				t.Errorf("Leader = %q, want http://b", got)
+			}
+
+			// Once b resigns, a takes over the current file
+			if err := b.Resign(); err != nil {
+				t.Fatal(err)
+			}
+			if !campaign(t, a, "http://a") {
+				t.Error("a did not take over after b resigned")
+			}
+		})
+	}
+}
+
+func TestFileCoordinatorLeaderNeverReadsPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+	a, b := NewFileCoordinator(path), NewFileCoordinator(path)
+	defer a.Resign()
+	defer b.Resign()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reader := NewFileCoordinator(path)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := reader.Leader(context.Background()); err != nil {
+				t.Errorf("Leader: %v", err)
+				return
+			}
+		}
+	}()
+
+	// Leadership changes hands, rewriting the file each time
+	for i := 0; i < 200; i++ {
+		c, address := a, "http://a"
+		if i%2 == 1 {
+			c, address = b, "http://b"
+		}
+		if !campaign(t, c, address) {
+			t.Fatalf("round %d: %s did not take the free lock", i, address)
+		}
+		if err := c.Resign(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// RelayPath is where the leader streams its results to the followers
+const RelayPath = "/cluster/relay"
+
+const (
+	// relayQueueSize is how many results may wait for a slow follower
+	// before new ones are dropped
+	relayQueueSize = 1000
+
+	// relayHeartbeat is how often the leader writes an empty line to an
+	// idle stream; followers give up after three missed ones
+	relayHeartbeat = 10 * time.Second
+
+	// relayWriteWait bounds each write to a follower
+	relayWriteWait = 10 * time.Second
+)
+
+// Publish relays probe results to the followers while this instance leads.
+// It implements hub.Sink and never blocks.
+func (n *Node) Publish(message *hub.Envelope, result *config.ProbeResult, changed bool) {
+	if message.Type != hub.MessageProbeResult || !n.IsLeader() {
+		return // Followers derive incidents from the results themselves
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for follower := range n.followers {
+		select {
+		case follower <- result:
+		default:
+			n.logger.Warn("Cluster follower too slow, dropping result",
+				zap.String("payer", result.Payer),
+				zap.String("type", result.Type))
+		}
+	}
+}
+
+// HandleRelay streams this instance's results to a follower as JSON
+// lines, starting with the latest result of every endpoint. Followers
+// authenticate with the cluster secret as a bearer token.
+func (n *Node) HandleRelay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if !n.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !n.IsLeader() {
+		http.Error(w, "not the cluster leader", http.StatusConflict)
+		return
+	}
+
+	results := make(chan *config.ProbeResult, relayQueueSize)
+	n.mu.Lock()
+	n.followers[results] = struct{}{}
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.followers, results)
+		n.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Relay streams outlive the server's WriteTimeout, so every write sets
+	// its own deadline
+	rc := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	write := func(result *config.ProbeResult) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(relayWriteWait)); err != nil {
+			return err
+		}
+		var err error
+		if result == nil {
+			_, err = w.Write([]byte("\n"))
+		} else {
+			err = encoder.Encode(result)
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	n.logger.Info("Cluster follower connected", zap.String("remote_addr", r.RemoteAddr))
+	defer n.logger.Info("Cluster follower disconnected", zap.String("remote_addr", r.RemoteAddr))
+
+	for _, result := range n.hub.Latest() {
+		if err := write(result); err != nil {
+			return
+		}
+	}
+	if err := write(nil); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(relayHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-n.done:
+			return
+		case result := <-results:
+			err = write(result)
+		case <-heartbeat.C:
+			err = write(nil)
+		}
+		if err != nil {
+			n.logger.Warn("Error relaying to cluster follower", zap.Error(err))
+			return
+		}
+	}
+}
+
+// authorized reports whether a request from another instance carries the
+// cluster secret as a bearer token
+func (n *Node) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if n.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(n.secret)) != 1 {
+		n.logger.Warn("Cluster authentication failed",
+			zap.String("path", r.URL.Path),
+			zap.String("remote_addr", r.RemoteAddr))
+		return false
+	}
+	return true
+}
+
+// follow relays the leader's results into this instance's hub until the
+// stream ends. It returns at once if the leader is unknown or unreachable.
+func (n *Node) follow(ctx context.Context) {
+	address, err := n.coordinator.Leader(ctx)
+	if err != nil {
+		n.logger.Error("Failed to look up the cluster leader", zap.Error(err))
+		return
+	}
+	if address == n.address {
+		address = "" // This instance's own stale record
+	}
+	n.mu.Lock()
+	n.leaderAddress = address
+	n.mu.Unlock()
+	if address == "" {
+		return
+	}
+
+	stream, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(stream, http.MethodGet, strings.TrimSuffix(address, "/")+RelayPath, nil)
+	if err != nil {
+		n.logger.Error("Invalid cluster leader address", zap.String("leader", address), zap.Error(err))
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+n.secret)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.logger.Warn("Cannot reach the cluster leader", zap.String("leader", address), zap.Error(err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		n.logger.Warn("Cluster leader refused the relay",
+			zap.String("leader", address),
+			zap.Int("status", resp.StatusCode))
+		return
+	}
+	n.logger.Info("Relaying results from the cluster leader", zap.String("leader", address))
+
+	// A leader that stops sending heartbeats is gone
+	watchdog := time.AfterFunc(3*relayHeartbeat, cancel)
+	defer watchdog.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		watchdog.Reset(3 * relayHeartbeat)
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue // Heartbeat
+		}
+		var result config.ProbeResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			n.logger.Warn("Invalid result from the cluster leader", zap.Error(err))
+			continue
+		}
+		n.hub.Relay(&result)
+	}
+
+	if ctx.Err() == nil {
+		n.logger.Warn("Lost the cluster leader", zap.String("leader", address), zap.Error(scanner.Err()))
+	}
+}
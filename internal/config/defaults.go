@@ -0,0 +1,89 @@
+package config
+
+import "time"
+
+// Defaults are endpoint settings inherited from the top level of the config
+// and from a payer. An endpoint's own values win, then its payer's, then the
+// top-level ones. Headers and thresholds are merged key by key in the same
+// order.
+type Defaults struct {
+	Schedule     time.Duration     `yaml:"schedule,omitempty"`
+	Method       string            `yaml:"method,omitempty" enum:"GET,HEAD,POST,PUT,PATCH,DELETE"`
+	Timezone     string            `yaml:"timezone,omitempty"`
+	Timeout      time.Duration     `yaml:"timeout,omitempty"`
+	MaxRedirects *int              `yaml:"max_redirects,omitempty"`
+	Headers      map[string]string `yaml:"headers,omitempty"`
+	Thresholds   Thresholds        `yaml:"thresholds,omitempty"`
+}
+
+// merge returns d with unset fields taken from parent
+func (d Defaults) merge(parent Defaults) Defaults {
+	if d.Schedule == 0 {
+		d.Schedule = parent.Schedule
+	}
+	if d.Method == "" {
+		d.Method = parent.Method
+	}
+	if d.Timezone == "" {
+		d.Timezone = parent.Timezone
+	}
+	if d.Timeout == 0 {
+		d.Timeout = parent.Timeout
+	}
+	if d.MaxRedirects == nil {
+		d.MaxRedirects = parent.MaxRedirects
+	}
+	d.Headers = mergeMaps(parent.Headers, d.Headers)
+	d.Thresholds = d.Thresholds.merge(parent.Thresholds)
+	return d
+}
+
+// apply fills an endpoint's unset fields from the defaults
+func (d Defaults) apply(endpoint *Endpoint) {
+	if endpoint.Schedule == 0 && endpoint.Cron == "" {
+		endpoint.Schedule = d.Schedule
+	}
+	if endpoint.Method == "" {
+		endpoint.Method = d.Method
+	}
+	if endpoint.Timezone == "" {
+		endpoint.Timezone = d.Timezone
+	}
+	if endpoint.Timeout == 0 {
+		endpoint.Timeout = d.Timeout
+	}
+	if endpoint.MaxRedirects == nil {
+		endpoint.MaxRedirects = d.MaxRedirects
+	}
+	endpoint.Headers = mergeMaps(d.Headers, endpoint.Headers)
+	endpoint.Thresholds = endpoint.Thresholds.merge(d.Thresholds)
+}
+
+// mergeMaps returns base overlaid with override, or nil if both are empty
+func mergeMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// applyDefaults resolves inherited settings and payer labels into every
+// endpoint
+func (c *Config) applyDefaults() {
+	for i := range c.Payers {
+		payer := &c.Payers[i]
+		defaults := payer.Defaults.merge(c.Defaults)
+		for j := range payer.Endpoints {
+			endpoint := &payer.Endpoints[j]
+			defaults.apply(endpoint)
+			endpoint.Labels = mergeMaps(payer.Labels, endpoint.Labels)
+		}
+	}
+}
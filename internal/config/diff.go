@@ -0,0 +1,99 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// EndpointRef pairs an endpoint with its payer and a key that is unique
+// within one Config
+type EndpointRef struct {
+	Key      string
+	Payer    string
+	Endpoint Endpoint
+}
+
+// EndpointKey identifies an endpoint across config reloads. A payer may
+// have several endpoints of the same type, so the URL is part of the key.
+func EndpointKey(payer string, endpoint Endpoint) string {
+	return payer + ":" + endpoint.Type + ":" + endpoint.GetURL()
+}
+
+// EndpointRefs lists every endpoint in config order. Exact duplicates get a
+// numeric suffix so each ref still has a distinct key.
+func (c *Config) EndpointRefs() []EndpointRef {
+	refs := make([]EndpointRef, 0, len(c.Payers))
+	seen := make(map[string]int)
+	for _, payer := range c.Payers {
+		for _, endpoint := range payer.Endpoints {
+			key := EndpointKey(payer.Name, endpoint)
+			if n := seen[key]; n > 0 {
+				seen[key] = n + 1
+				key += "#" + strconv.Itoa(n)
+			} else {
+				seen[key] = 1
+			}
+			refs = append(refs, EndpointRef{Key: key, Payer: payer.Name, Endpoint: endpoint})
+		}
+	}
+	return refs
+}
+
+// Diff lists the endpoint keys that differ between two configurations
+type Diff struct {
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Changed   []string `json:"changed,omitempty"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// IsEmpty reports whether the configurations have identical endpoints
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffConfigs compares the endpoints of two configurations. A nil old
+// config reports every endpoint as added.
+func DiffConfigs(old, new *Config) *Diff {
+	diff := &Diff{}
+
+	oldEndpoints := make(map[string]Endpoint)
+	if old != nil {
+		for _, ref := range old.EndpointRefs() {
+			oldEndpoints[ref.Key] = ref.Endpoint
+		}
+	}
+
+	for _, ref := range new.EndpointRefs() {
+		previous, ok := oldEndpoints[ref.Key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, ref.Key)
+		case !reflect.DeepEqual(previous, ref.Endpoint):
+			diff.Changed = append(diff.Changed, ref.Key)
+		default:
+			diff.Unchanged++
+		}
+		delete(oldEndpoints, ref.Key)
+	}
+
+	if old != nil {
+		// Walk the old config again so removals are reported in config order
+		for _, ref := range old.EndpointRefs() {
+			if _, ok := oldEndpoints[ref.Key]; ok {
+				diff.Removed = append(diff.Removed, ref.Key)
+			}
+		}
+	}
+
+	return diff
+}
+
+// ReloadEvent records the outcome of a configuration load
+type ReloadEvent struct {
+	Timestamp time.Time `json:"ts"`
+	Path      string    `json:"path"`
+	Version   int       `json:"version"`
+	Diff      *Diff     `json:"diff"`
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Result statuses an EventFilter may select
+const (
+	StatusHealthy   = "healthy"
+	StatusUnhealthy = "unhealthy"
+)
+
+// EventFilter selects probe results and incidents for a stream subscriber
+// or webhook. Payers and PayerPatterns together list the payers to match;
+// every other field narrows the results further. Empty fields match
+// everything.
+type EventFilter struct {
+	Payers        []string          `yaml:"payers,omitempty" json:"payers,omitempty" help:"Payer names to match"`
+	PayerPatterns []string          `yaml:"payer_patterns,omitempty" json:"payer_patterns,omitempty" help:"Payer name globs, or regular expressions between slashes"`
+	Types         []string          `yaml:"types,omitempty" json:"types,omitempty" help:"Endpoint types to match"`
+	Labels        map[string]string `yaml:"labels,omitempty" json:"labels,omitempty" help:"Labels that must all match"`
+	Status        string            `yaml:"status,omitempty" json:"status,omitempty" enum:"healthy,unhealthy" help:"Only healthy or only unhealthy results"`
+	MinLatencyMS  int64             `yaml:"min_latency_ms,omitempty" json:"min_latency_ms,omitempty" help:"Only results at least this slow"`
+	MaxLatencyMS  int64             `yaml:"max_latency_ms,omitempty" json:"max_latency_ms,omitempty" help:"Only results no slower than this"`
+	Transitions   bool              `yaml:"transitions,omitempty" json:"transitions,omitempty" help:"Only results that turn an endpoint unhealthy or healthy again"`
+}
+
+// Validate checks the status, latency thresholds and payer patterns
+func (f *EventFilter) Validate() error {
+	switch f.Status {
+	case "", StatusHealthy, StatusUnhealthy:
+	default:
+		return fmt.Errorf("status must be %q or %q, not %q", StatusHealthy, StatusUnhealthy, f.Status)
+	}
+	if f.MinLatencyMS < 0 || f.MaxLatencyMS < 0 {
+		return fmt.Errorf("latency thresholds must not be negative")
+	}
+	if f.MaxLatencyMS > 0 && f.MaxLatencyMS < f.MinLatencyMS {
+		return fmt.Errorf("max_latency_ms %d is below min_latency_ms %d", f.MaxLatencyMS, f.MinLatencyMS)
+	}
+	for _, pattern := range f.PayerPatterns {
+		if _, err := CompilePayerPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnknownPayers returns the filter's payers, and patterns matching none of
+// them, that are not among the given payers
+func (f *EventFilter) UnknownPayers(payers map[string]bool) []string {
+	var unknown []string
+	for _, payer := range f.Payers {
+		if !payers[payer] {
+			unknown = append(unknown, payer)
+		}
+	}
+	for _, pattern := range f.PayerPatterns {
+		match, err := CompilePayerPattern(pattern)
+		if err != nil {
+			continue
+		}
+		found := false
+		for payer := range payers {
+			found = found || match(payer)
+		}
+		if !found {
+			unknown = append(unknown, pattern)
+		}
+	}
+	return unknown
+}
+
+// CompilePayerPattern turns "/expr/" into a regular expression match and
+// anything else into a glob match
+func CompilePayerPattern(pattern string) (func(string) bool, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid payer pattern %s: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid payer pattern %q: %w", pattern, err)
+	}
+	return func(payer string) bool {
+		matched, _ := path.Match(pattern, payer)
+		return matched
+	}, nil
+}
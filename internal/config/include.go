@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// payerFile is the content of an included file. Include is only decoded
+// to report nested includes, which are not followed.
+type payerFile struct {
+	Include []string `yaml:"include"`
+	Payers  []Payer  `yaml:"payers"`
+}
+
+// payerSource records where a payer was defined, for error positions
+type payerSource struct {
+	file string
+	node *yaml.Node
+}
+
+// resolveIncludes expands include patterns into file paths. Patterns are
+// relative to base, the directory of the main config file; a directory
+// includes every .yaml and .yml file in it. The main file itself, at path
+// main, is skipped so a glob next to it does not include it again.
+func resolveIncludes(base, main string, patterns []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	mainInfo, _ := os.Stat(main)
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(base, pattern)
+		}
+
+		var matches []string
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			for _, ext := range []string{"*.yaml", "*.yml"} {
+				m, _ := filepath.Glob(filepath.Join(pattern, ext))
+				matches = append(matches, m...)
+			}
+		} else {
+			m, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+			}
+			matches = m
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("include %q matches no files", pattern)
+		}
+
+		sort.Strings(matches)
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && mainInfo != nil && os.SameFile(info, mainInfo) {
+				continue
+			}
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// payerSources lists the position of each payer defined in a document
+func payerSources(file string, doc *yaml.Node) []payerSource {
+	root := doc
+	if root != nil && root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	_, payers := mappingValue(root, "payers")
+	if payers == nil || payers.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	sources := make([]payerSource, len(payers.Content))
+	for i, node := range payers.Content {
+		sources[i] = payerSource{file: file, node: node}
+	}
+	return sources
+}
+
+// loadIncludes appends the payers of every included file to config and
+// returns the files read. Problems are recorded in v against each file.
+func loadIncludes(doc *Document, config *Config, v *validator, root *yaml.Node) ([]string, []payerSource, error) {
+	if len(config.Include) == 0 {
+		return nil, nil, nil
+	}
+	if doc.BaseDir == "" {
+		if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = root.Content[0]
+		}
+		v.add(fieldNode(root, "include"), "include is not supported for %s", doc.Name)
+		return nil, nil, nil
+	}
+
+	files, err := resolveIncludes(doc.BaseDir, doc.Name, config.Include)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sources []payerSource
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read included file %s: %w", file, err)
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse included file %s: %w", file, err)
+		}
+
+		var included payerFile
+		v.file = file
+		v.decode(&doc, &included)
+		if len(included.Include) > 0 {
+			v.add(fieldNode(doc.Content[0], "include"), "include is only supported in the main config file")
+		}
+
+		fileSources := payerSources(file, &doc)
+		for i := range included.Payers {
+			source := payerSource{file: file}
+			if i < len(fileSources) {
+				source = fileSources[i]
+			}
+			sources = append(sources, source)
+		}
+		config.Payers = append(config.Payers, included.Payers...)
+	}
+
+	v.file = doc.Name
+	return files, sources, nil
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writeFiles writes files, keyed by path relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// payerEntry is a payers list item with one endpoint
+func payerEntry(name string) string {
+	return "  - name: " + name + "\n    endpoints:\n      - {type: login, url: \"https://" +
+		strings.ToLower(name) + ".example.com\"}\n"
+}
+
+// payerFileFor is an included file with one payer
+func payerFileFor(name string) string {
+	return "payers:\n" + payerEntry(name)
+}
+
+// payerNames lists the payers of config in order
+func payerNames(config *Config) []string {
+	var names []string
+	for _, payer := range config.Payers {
+		names = append(names, payer.Name)
+	}
+	return names
+}
+
+// validationErrors loads path and returns its validation problems
+func validationErrors(t *testing.T, path string) []string {
+	t.Helper()
+	err := NewLoader(path, zap.NewNop()).Load()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Load() error = %v, want validation errors", err)
+	}
+	var problems []string
+	for _, e := range errs {
+		problems = append(problems, e.Error())
+	}
+	return problems
+}
+
+func TestIncludePathsAreRelativeToTheMainFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config/main.yaml":        "include: [payers/, ../shared/*.yaml, payers/b.yaml]\npayers:\n" + payerEntry("Main"),
+		"config/payers/b.yaml":    payerFileFor("Beta"),
+		"config/payers/a.yml":     payerFileFor("Alpha"),
+		"config/payers/notes.txt": "not yaml",
+		"shared/cigna.yaml":       payerFileFor("Cigna"),
+	})
+
+	// The working directory is the package's, not the config's
+	path := filepath.Join(dir, "config", "main.yaml")
+	loader := NewLoader(path, zap.NewNop())
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directory files come in name order, and a file matched twice is read once
+	if got := payerNames(loader.GetConfig()); strings.Join(got, " ") != "Main Alpha Beta Cigna" {
+		t.Errorf("payers = %v, want [Main Alpha Beta Cigna]", got)
+	}
+	files := loader.Files()
+	wantFiles := []string{path,
+		filepath.Join(dir, "config/payers/a.yml"),
+		filepath.Join(dir, "config/payers/b.yaml"),
+		filepath.Join(dir, "shared/cigna.yaml")}
+	if strings.Join(files, ",") != strings.Join(wantFiles, ",") {
+		t.Errorf("files = %v, want %v", files, wantFiles)
+	}
+}
+
+func TestIncludeRejectsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.yaml": "include: [payers/*.yaml]\n"})
+
+	err := NewLoader(filepath.Join(dir, "main.yaml"), zap.NewNop()).Load()
+	if err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Errorf("Load() error = %v, want an unmatched include", err)
+	}
+}
+
+func TestIncludeCycles(t *testing.T) {
+	t.Run("main file matched by its own glob", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"main.yaml": "include: [\"*.yaml\"]\npayers:\n" + payerEntry("Acme"),
+			"beta.yaml": payerFileFor("Beta"),
+		})
+		loader := NewLoader(filepath.Join(dir, "main.yaml"), zap.NewNop())
+		if err := loader.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if got := payerNames(loader.GetConfig()); strings.Join(got, " ") != "Acme Beta" {
+			t.Errorf("payers = %v, want [Acme Beta]", got)
+		}
+	})
+
+	t.Run("included file includes the main file", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"main.yaml":     "include: [payers/]\n",
+			"payers/a.yaml": "include: [../main.yaml]\n" + payerFileFor("Alpha"),
+			"payers/b.yaml": payerFileFor("Beta"),
+		})
+		problems := validationErrors(t, filepath.Join(dir, "main.yaml"))
+		want := filepath.Join(dir, "payers/a.yaml") + ":1:1: include is only supported in the main config file"
+		if len(problems) != 1 || problems[0] != want {
+			t.Errorf("problems = %q, want %q", problems, want)
+		}
+	})
+}
+
+func TestIncludeRejectsDuplicatePayersAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.yaml":     "include: [payers/]\npayers:\n" + payerEntry("Acme"),
+		"payers/a.yaml": payerFileFor("Beta"),
+		"payers/b.yaml": "# Both payers are defined elsewhere\n" + payerFileFor("Acme") + "  - name: Beta\n    endpoints: [{type: api, url: \"https://beta.example.com/api\"}]\n",
+	})
+
+	problems := validationErrors(t, filepath.Join(dir, "main.yaml"))
+	b := filepath.Join(dir, "payers/b.yaml")
+	want := []string{
+		b + ":3:5: payer Acme is already defined at " + filepath.Join(dir, "main.yaml") + ":3",
+		b + ":6:5: payer Beta is already defined at " + filepath.Join(dir, "payers/a.yaml") + ":2",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDefaultsAreInherited(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.yaml": `include: [payers/]
+defaults:
+  schedule: 10m
+  timeout: 20s
+  headers: {User-Agent: monitor, X-Team: ops}
+  thresholds: {max_latency: 5s}
+`,
+		"payers/acme.yaml": `payers:
+  - name: Acme
+    defaults:
+      timeout: 30s
+      headers: {X-Team: acme}
+      thresholds: {max_latency: 8s}
+    endpoints:
+      - {type: login, url: "https://acme.example.com/login"}
+      - type: claims
+        url: https://acme.example.com/claims
+        schedule: 5m
+        headers: {X-Team: claims}
+        thresholds: {max_latency: 2s}
+  - name: Beta
+    endpoints:
+      - {type: login, url: "https://beta.example.com/login"}
+      - {type: api, url: "https://beta.example.com/api", cron: "*/5 * * * *"}
+`,
+	})
+
+	loader := NewLoader(filepath.Join(dir, "main.yaml"), zap.NewNop())
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		payer, kind string
+		schedule    time.Duration
+		timeout     time.Duration
+		team        string
+		maxLatency  time.Duration
+	}{
+		{"Acme", "login", 10 * time.Minute, 30 * time.Second, "acme", 8 * time.Second},
+		{"Acme", "claims", 5 * time.Minute, 30 * time.Second, "claims", 2 * time.Second},
+		{"Beta", "login", 10 * time.Minute, 20 * time.Second, "ops", 5 * time.Second},
+		{"Beta", "api", 0, 20 * time.Second, "ops", 5 * time.Second}, // Cron endpoints keep no schedule
+	}
+	config := loader.GetConfig()
+	for _, tt := range tests {
+		var endpoint *Endpoint
+		for i := range config.Payers {
+			for j := range config.Payers[i].Endpoints {
+				if e := &config.Payers[i].Endpoints[j]; config.Payers[i].Name == tt.payer && e.Type == tt.kind {
+					endpoint = e
+				}
+			}
+		}
+		if endpoint == nil {
+			t.Fatalf("%s %s not loaded", tt.payer, tt.kind)
+		}
+		if endpoint.Schedule != tt.schedule || endpoint.Timeout != tt.timeout ||
+			endpoint.Headers["X-Team"] != tt.team || endpoint.Headers["User-Agent"] != "monitor" ||
+			endpoint.Thresholds.MaxLatency != tt.maxLatency {
+			t.Errorf("%s %s: schedule %s, timeout %s, headers %v, max_latency %s; want %s, %s, X-Team %s, %s",
+				tt.payer, tt.kind, endpoint.Schedule, endpoint.Timeout, endpoint.Headers, endpoint.Thresholds.MaxLatency,
+				tt.schedule, tt.timeout, tt.team, tt.maxLatency)
+		}
+	}
+}
+
+func TestNegativeThresholdIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.yaml": `payers:
+  - name: Acme
+    endpoints:
+      - type: login
+        url: https://acme.example.com/login
+        thresholds: {max_latency: -1s}
+`})
+	problems := validationErrors(t, filepath.Join(dir, "main.yaml"))
+	want := filepath.Join(dir, "main.yaml") + ":6:9: payer Acme endpoint login has a negative max_latency threshold"
+	if len(problems) != 1 || problems[0] != want {
+		t.Errorf("problems = %q, want %q", problems, want)
+	}
+}
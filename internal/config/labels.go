@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// labelKeyPattern restricts label keys to names that work as query
+// parameters and Prometheus label names
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedMetricLabels are the labels probe metrics already carry
+var reservedMetricLabels = map[string]bool{"payer": true, "type": true, "status_code": true}
+
+// checkLabels reports invalid label keys. Keys in inherited came from the
+// payer and were checked there.
+func (v *validator) checkLabels(node *yaml.Node, owner string, labels, inherited map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if _, ok := inherited[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !labelKeyPattern.MatchString(key) {
+			v.add(fieldNode(node, "labels"), "%s: invalid label key %q, use letters, digits and underscores", owner, key)
+		}
+	}
+}
+
+// MetricLabelKeys returns the endpoint labels added to probe metrics
+func (s *ServerConfig) MetricLabelKeys() []string {
+	return splitList(s.MetricLabels)
+}
+
+// checkMetricLabels validates the metric_labels allow-list
+func (s *ServerConfig) checkMetricLabels() error {
+	seen := make(map[string]bool)
+	for _, key := range s.MetricLabelKeys() {
+		switch {
+		case !labelKeyPattern.MatchString(key) || strings.HasPrefix(key, "__"):
+			return fmt.Errorf("metric_labels: invalid label key %q", key)
+		case reservedMetricLabels[key]:
+			return fmt.Errorf("metric_labels: %q is already a probe metric label", key)
+		case seen[key]:
+			return fmt.Errorf("metric_labels: %q is listed twice", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
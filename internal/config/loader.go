@@ -2,9 +2,11 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -15,50 +17,256 @@ import (
 
 // Loader handles configuration loading and hot-reloading
 type Loader struct {
-	configPath string
-	config     *Config
-	mu         sync.RWMutex
-	logger     *zap.Logger
-	callbacks  []func(*Config)
+	source    ConfigSource
+	cachePath string // Last good remote document, for startup when the source is down
+	config    *Config
+	mu        sync.RWMutex
+	applyMu   sync.Mutex // Serializes loads and rollbacks
+	logger    *zap.Logger
+	callbacks []func(*Config)
+	reloads   []ReloadEvent     // Most recent last
+	overrides map[string]string // Server settings from command-line flags
+	files     []string          // Main and included files of the current config
+
+	reloadHooks []func(error)         // Outcome of each SIGHUP or file-change reload
+	checks      []func(*Config) error // May reject a config before it is applied
+	versions    []ConfigVersion       // Recently applied configs, most recent last
 }
 
-// NewLoader creates a new configuration loader
+// maxReloadEvents bounds the reload history kept by the loader
+const maxReloadEvents = 20
+
+// fetchTimeout bounds one fetch from the config source
+const fetchTimeout = 2 * time.Minute
+
+// NewLoader creates a new configuration loader for a local file
 func NewLoader(configPath string, logger *zap.Logger) *Loader {
 	return &Loader{
-		configPath: configPath,
-		logger:     logger,
-		callbacks:  make([]func(*Config), 0),
+		source:    NewFileSource(configPath),
+		logger:    logger,
+		callbacks: make([]func(*Config), 0),
 	}
 }
 
-// Load loads the configuration from the YAML file
+// SetSource replaces the local file with another configuration source. It
+// must be called before the first Load.
+func (l *Loader) SetSource(source ConfigSource) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.source = source
+}
+
+// SetCachePath sets where the last good document from a remote source is
+// kept. It is used at startup when the source cannot be reached.
+func (l *Loader) SetCachePath(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cachePath = path
+}
+
+// SetOverrides sets server settings, keyed by YAML key, that take
+// precedence over the file and environment on every load
+func (l *Loader) SetOverrides(overrides map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overrides = overrides
+}
+
+// Load loads the configuration from the source
 func (l *Loader) Load() error {
-	data, err := os.ReadFile(l.configPath)
+	_, err := l.load()
+	return err
+}
+
+// load fetches, validates and applies the configuration. It reports false
+// when the source had not changed.
+func (l *Loader) load() (bool, error) {
+	l.applyMu.Lock()
+	defer l.applyMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	doc, cached, err := l.fetch(ctx)
+	if errors.Is(err, ErrNotModified) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	config, files, err := l.parse(doc)
 	if err != nil {
-		return fmt.Errorf("failed to read config file %s: %w", l.configPath, err)
+		return false, err
+	}
+
+	if err := l.apply(config, doc.Name, files, 0); err != nil {
+		return false, err
+	}
+
+	if !cached {
+		l.accept(doc)
+		l.writeCache(doc)
 	}
+	return true, nil
+}
+
+// fetch gets the current document from the source. Before the first
+// successful load, a source that cannot be reached falls back to the local
+// cache; cached reports whether that happened.
+func (l *Loader) fetch(ctx context.Context) (*Document, bool, error) {
+	l.mu.RLock()
+	source, cachePath, loaded := l.source, l.cachePath, l.config != nil
+	l.mu.RUnlock()
+
+	doc, err := source.Fetch(ctx)
+	if err == nil || errors.Is(err, ErrNotModified) {
+		return doc, false, err
+	}
+
+	// Sources with a local copy return it along with the refresh error
+	if doc != nil {
+		l.logger.Warn("Config source could not be refreshed, using local copy",
+			zap.String("source", source.Name()), zap.Error(err))
+		return doc, false, nil
+	}
+
+	if loaded || cachePath == "" {
+		return nil, false, err
+	}
+	data, cacheErr := os.ReadFile(cachePath)
+	if cacheErr != nil {
+		return nil, false, err
+	}
+
+	l.logger.Warn("Config source unavailable, starting from cached copy",
+		zap.String("source", source.Name()),
+		zap.String("cache_path", cachePath),
+		zap.Error(err))
+	return &Document{Name: cachePath, Data: data}, true, nil
+}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to parse YAML config: %w", err)
+// accept tells the source its document was applied. Until then the source
+// keeps fetching it, so a rejected document is checked again.
+func (l *Loader) accept(doc *Document) {
+	l.mu.RLock()
+	source := l.source
+	l.mu.RUnlock()
+	if accepting, ok := source.(AcceptingSource); ok {
+		accepting.Accept(doc)
+	}
+}
+
+// writeCache saves a remote document as the last good copy
+func (l *Loader) writeCache(doc *Document) {
+	l.mu.RLock()
+	cachePath := l.cachePath
+	_, local := l.source.(*FileSource)
+	l.mu.RUnlock()
+	if cachePath == "" || local {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		l.logger.Warn("Failed to write config cache", zap.Error(err))
+		return
+	}
+	tmp := cachePath + ".tmp"
+	if err := os.WriteFile(tmp, doc.Data, 0o644); err != nil {
+		l.logger.Warn("Failed to write config cache", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, cachePath); err != nil {
+		l.logger.Warn("Failed to write config cache", zap.Error(err))
 	}
+}
 
-	if err := l.validate(&config); err != nil {
-		return fmt.Errorf("config validation failed: %w", err)
+// parse decodes and validates a document and its includes, returning the
+// config and every file it was read from
+func (l *Loader) parse(doc *Document) (*Config, []string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(doc.Data, &root); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+
+	// Settings missing from the file keep their defaults
+	config := Config{Server: DefaultServerConfig()}
+	v := &validator{file: doc.Name}
+	v.decode(&root, &config)
+
+	// Payers from included files follow those of the main file
+	sources := payerSources(doc.Name, &root)
+	included, includedSources, err := loadIncludes(doc, &config, v, &root)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources = append(sources, includedSources...)
+	config.applyDefaults()
+
+	l.mu.RLock()
+	overrides := l.overrides
+	l.mu.RUnlock()
+	if err := config.Server.applyOverrides(os.LookupEnv, overrides); err != nil {
+		return nil, nil, fmt.Errorf("invalid server setting: %w", err)
+	}
+
+	v.validateConfig(&config, &root, sources)
+	if err := v.err(); err != nil {
+		return nil, nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return &config, append([]string{doc.Name}, included...), nil
+}
+
+// apply runs the reload checks on config and, if none rejects it, makes it
+// the current configuration and notifies the change callbacks. rollbackOf
+// is the version being restored, or 0 for a load from the source. The
+// caller must hold l.applyMu.
+func (l *Loader) apply(config *Config, name string, files []string, rollbackOf int) error {
+	l.mu.RLock()
+	checks := l.checks
+	l.mu.RUnlock()
+	for _, check := range checks {
+		if err := check(config); err != nil {
+			return fmt.Errorf("%w: %v", ErrConfigRejected, err)
+		}
+	}
+
+	hash, err := hashConfig(config)
+	if err != nil {
+		return err
 	}
 
 	l.mu.Lock()
-	l.config = &config
+	event := ReloadEvent{
+		Timestamp: time.Now(),
+		Path:      name,
+		Diff:      DiffConfigs(l.config, config),
+	}
+	l.config = config
+	l.files = files
+	event.Version = l.recordVersion(config, files, hash, event.Timestamp, rollbackOf)
+	l.reloads = append(l.reloads, event)
+	if len(l.reloads) > maxReloadEvents {
+		l.reloads = l.reloads[len(l.reloads)-maxReloadEvents:]
+	}
 	l.mu.Unlock()
 
 	l.logger.Info("Configuration loaded successfully",
-		zap.String("config_path", l.configPath),
+		zap.String("config_path", name),
+		zap.Int("version", event.Version),
+		zap.String("hash", hash),
+		zap.Int("included_files", len(files)-1),
 		zap.Int("payers_count", len(config.Payers)),
-		zap.Int("total_endpoints", l.countEndpoints(&config)))
+		zap.Int("total_endpoints", l.countEndpoints(config)),
+		zap.Strings("added", event.Diff.Added),
+		zap.Strings("removed", event.Diff.Removed),
+		zap.Strings("changed", event.Diff.Changed),
+		zap.Int("unchanged", event.Diff.Unchanged))
 
 	// Notify callbacks of config change
 	for _, callback := range l.callbacks {
-		callback(&config)
+		callback(config)
 	}
 
 	return nil
@@ -79,6 +287,31 @@ func (l *Loader) GetConfig() *Config {
 	return l.config
 }
 
+// Files returns the main config file followed by the files it included
+func (l *Loader) Files() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]string(nil), l.files...)
+}
+
+// Reloads returns the most recent reload events, oldest first
+func (l *Loader) Reloads() []ReloadEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	events := make([]ReloadEvent, len(l.reloads))
+	copy(events, l.reloads)
+	return events
+}
+
+// OnConfigCheck registers a check run on every new configuration before it
+// is applied. An error rejects the configuration and keeps the current one.
+func (l *Loader) OnConfigCheck(check func(*Config) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.checks = append(l.checks, check)
+}
+
 // OnConfigChange registers a callback for configuration changes
 func (l *Loader) OnConfigChange(callback func(*Config)) {
 	l.callbacks = append(l.callbacks, callback)
@@ -96,50 +329,12 @@ func (l *Loader) WatchForChanges(ctx context.Context) {
 				return
 			case <-sigChan:
 				l.logger.Info("Received SIGHUP, reloading configuration")
-				if err := l.Load(); err != nil {
-					l.logger.Error("Failed to reload configuration", zap.Error(err))
-				} else {
-					l.logger.Info("Configuration reloaded successfully")
-				}
+				l.reload("SIGHUP")
 			}
 		}
 	}()
 }
 
-// validate performs basic validation on the configuration
-func (l *Loader) validate(config *Config) error {
-	if len(config.Payers) == 0 {
-		return fmt.Errorf("no payers configured")
-	}
-
-	for i, payer := range config.Payers {
-		if payer.Name == "" {
-			return fmt.Errorf("payer at index %d has empty name", i)
-		}
-
-		if len(payer.Endpoints) == 0 {
-			return fmt.Errorf("payer %s has no endpoints", payer.Name)
-		}
-
-		for j, endpoint := range payer.Endpoints {
-			if endpoint.Type == "" {
-				return fmt.Errorf("payer %s endpoint at index %d has empty type", payer.Name, j)
-			}
-
-			if endpoint.URL == "" && endpoint.Path == "" && endpoint.URLContains == "" {
-				return fmt.Errorf("payer %s endpoint %s has no URL, path, or url_contains", payer.Name, endpoint.Type)
-			}
-
-			// Validate schedule if provided
-			if endpoint.Schedule != 0 && endpoint.Schedule < time.Minute {
-				return fmt.Errorf("payer %s endpoint %s has schedule less than 1 minute", payer.Name, endpoint.Type)
-			}
-		}
-	}
-
-	return nil
-}
-
 // countEndpoints returns the total number of endpoints across all payers
 func (l *Loader) countEndpoints(config *Config) int {
 	count := 0
@@ -6,24 +6,51 @@ import (
 
 // Config represents the complete configuration structure
 type Config struct {
-	Payers []Payer `yaml:"payers"`
+	Server   ServerConfig `yaml:"server,omitempty"`
+	Include  []string     `yaml:"include,omitempty" help:"Payer files, directories or globs, relative to this file"`
+	Defaults Defaults     `yaml:"defaults,omitempty" help:"Endpoint settings inherited by every endpoint"`
+	Payers   []Payer      `yaml:"payers" required:"unless:include" help:"Payers and the endpoints probed for each"`
+	Webhooks []Webhook    `yaml:"webhooks,omitempty" help:"HTTP receivers of probe results and incidents"`
+
+	Publishers []Publisher `yaml:"publishers,omitempty" help:"NATS and MQTT brokers probe results and incidents are published to"`
 }
 
 // Payer represents a healthcare payer with multiple endpoints
 type Payer struct {
-	Name      string     `yaml:"name"`
-	Endpoints []Endpoint `yaml:"endpoints"`
+	Name      string            `yaml:"name" required:"true" help:"Payer name, unique across all files"`
+	Defaults  Defaults          `yaml:"defaults,omitempty" help:"Override the top-level defaults for this payer"`
+	Labels    map[string]string `yaml:"labels,omitempty" help:"Labels inherited by every endpoint of the payer"`
+	Endpoints []Endpoint        `yaml:"endpoints" required:"true"`
 }
 
 // Endpoint represents a single endpoint to monitor
 type Endpoint struct {
-	Type        string        `yaml:"type"`                  // login, api, patient_search, etc.
-	URL         string        `yaml:"url,omitempty"`         // Full URL
-	Path        string        `yaml:"path,omitempty"`        // Relative path (for API endpoints)
-	URLContains string        `yaml:"url_contains,omitempty"` // URL pattern matching
-	Method      string        `yaml:"method,omitempty"`      // HTTP method (default: GET)
-	Schedule    time.Duration `yaml:"schedule,omitempty"`    // Probe interval (default: 15m)
-	Description string        `yaml:"description,omitempty"` // Optional context
+	Type        string        `yaml:"type" required:"true" suggest:"api,login,patient_search,eligibility,claims,claims_address,pdf_extraction,contact_us,base" help:"Endpoint kind, e.g. login, api or patient_search; other kinds are allowed"`
+	URL         string        `yaml:"url,omitempty" help:"Full URL to probe"`
+	Path        string        `yaml:"path,omitempty" help:"Relative path, for API endpoints"`
+	URLContains string        `yaml:"url_contains,omitempty" help:"URL pattern matching"`
+	Method      string        `yaml:"method,omitempty" enum:"GET,HEAD,POST,PUT,PATCH,DELETE" help:"HTTP method (default: GET)"`
+	Schedule    time.Duration `yaml:"schedule,omitempty" help:"Probe interval, 1m to 24h (default: 15m)"`
+	Cron        string        `yaml:"cron,omitempty" help:"Cron expression, replaces schedule when set"`
+	Timezone    string        `yaml:"timezone,omitempty" help:"IANA timezone for cron and profiles (default: UTC)"`
+	Description string        `yaml:"description,omitempty" help:"Optional context"`
+
+	// Profiles override the schedule during time-of-day/day-of-week windows
+	Profiles []ScheduleProfile `yaml:"schedule_profiles,omitempty" help:"Schedule overrides for time-of-day and day-of-week windows"`
+
+	// Overrides of the server-wide probe settings
+	Timeout      time.Duration `yaml:"timeout,omitempty" help:"Probe timeout, overrides server probe_timeout"`
+	MaxRedirects *int          `yaml:"max_redirects,omitempty" help:"Redirects to follow, overrides server max_redirects"`
+
+	// Thresholds fail responses that arrive but fall outside the limits
+	Thresholds Thresholds `yaml:"thresholds,omitempty" help:"Limits a response must meet to count as healthy"`
+
+	// Headers are added to every probe request; ${VAR} values are expanded
+	Headers map[string]string `yaml:"headers,omitempty" help:"Request headers; ${VAR} values are expanded"`
+
+	// Labels are free-form dimensions such as region or line of business,
+	// merged over the payer's labels
+	Labels map[string]string `yaml:"labels,omitempty" help:"Key/value labels such as region, state or line_of_business"`
 }
 
 // GetURL returns the complete URL for the endpoint
@@ -56,6 +83,36 @@ func (e *Endpoint) GetSchedule() time.Duration {
 	return e.Schedule
 }
 
+// GetTimeout returns the endpoint's probe timeout, or the server default
+func (e *Endpoint) GetTimeout(defaultTimeout time.Duration) time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return defaultTimeout
+}
+
+// GetMaxRedirects returns the endpoint's redirect limit, or the server default
+func (e *Endpoint) GetMaxRedirects(defaultMax int) int {
+	if e.MaxRedirects != nil {
+		return *e.MaxRedirects
+	}
+	return defaultMax
+}
+
+// Thresholds are limits a successful response must also meet to count as
+// healthy. Zero values are unset.
+type Thresholds struct {
+	MaxLatency time.Duration `yaml:"max_latency,omitempty" help:"Responses slower than this count as unhealthy"`
+}
+
+// merge returns t with unset limits taken from parent
+func (t Thresholds) merge(parent Thresholds) Thresholds {
+	if t.MaxLatency == 0 {
+		t.MaxLatency = parent.MaxLatency
+	}
+	return t
+}
+
 // ProbeResult represents the result of a health probe
 type ProbeResult struct {
 	Timestamp  time.Time `json:"ts"`
@@ -65,4 +122,26 @@ type ProbeResult struct {
 	LatencyMS  int64     `json:"latency_ms"`
 	StatusCode int       `json:"status_code"`
 	Err        string    `json:"err,omitempty"`
+
+	// Labels are the endpoint's labels, including those of its payer
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// CorrelationID ties the result to an on-demand probe request
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Muted results should not raise alerts. Paused marks a notice, without
+	// a probe behind it, that scheduled probing of the endpoint has stopped.
+	Muted  bool `json:"muted,omitempty"`
+	Paused bool `json:"paused,omitempty"`
+}
+
+// Alerting reports whether the result may raise alerts. Muted results and
+// pause notices may not.
+func (r *ProbeResult) Alerting() bool {
+	return !r.Muted && !r.Paused
+}
+
+// Healthy reports whether the probe got a successful or redirect response
+func (r *ProbeResult) Healthy() bool {
+	return r.Err == "" && r.StatusCode >= 200 && r.StatusCode < 400
 }
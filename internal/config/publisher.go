@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Broker kinds a Publisher may connect to
+const (
+	BrokerNATS = "nats"
+	BrokerMQTT = "mqtt"
+)
+
+// Publisher defaults
+const (
+	DefaultPublisherSubject   = "payer.{payer}.{type}"
+	defaultPublisherTimeout   = 5 * time.Second
+	defaultPublisherQueueSize = 1024
+)
+
+// SubjectPlaceholders are the names a publisher subject may contain in
+// braces
+var SubjectPlaceholders = []string{"payer", "type", "event"}
+
+// subjectPlaceholder finds the placeholders in a subject template
+var subjectPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Publisher is a message broker that probe results and incidents are
+// published to as they are broadcast
+type Publisher struct {
+	Name      string        `yaml:"name" required:"true" help:"Unique name, used in logs and metrics"`
+	Kind      string        `yaml:"kind" required:"true" enum:"nats,mqtt" help:"Broker protocol: nats or mqtt"`
+	URL       string        `yaml:"url" required:"true" help:"Broker URL, e.g. nats://localhost:4222 or tcp://localhost:1883; ${VAR} is expanded"`
+	Subject   string        `yaml:"subject,omitempty" help:"NATS subject or MQTT topic; {payer}, {type} and {event} are replaced (default: payer.{payer}.{type})"`
+	Events    []string      `yaml:"events,omitempty" enum:"probe_result,incident" help:"Message types to publish (default: both)"`
+	Filter    EventFilter   `yaml:"filter,omitempty" help:"Which results and incidents to publish"`
+	Username  string        `yaml:"username,omitempty" help:"Broker user; ${VAR} is expanded"`
+	Password  string        `yaml:"password,omitempty" help:"Broker password; ${VAR} is expanded"`
+	ClientID  string        `yaml:"client_id,omitempty" help:"MQTT client ID or NATS connection name (default: payer-status-io-<name>)"`
+	QoS       int           `yaml:"qos,omitempty" help:"MQTT quality of service: 0, 1 or 2"`
+	Retain    bool          `yaml:"retain,omitempty" help:"Set the MQTT retain flag, so new subscribers get the latest message per topic"`
+	Timeout   time.Duration `yaml:"timeout,omitempty" help:"How long a publish may wait for the broker (default: 5s)"`
+	QueueSize int           `yaml:"queue_size,omitempty" help:"Messages buffered while the broker is slow or unreachable (default: 1024)"`
+}
+
+// GetSubject returns the subject template, defaulting to payer.{payer}.{type}
+func (p *Publisher) GetSubject() string {
+	if p.Subject == "" {
+		return DefaultPublisherSubject
+	}
+	return p.Subject
+}
+
+// GetEvents returns the message types published, defaulting to both
+func (p *Publisher) GetEvents() []string {
+	if len(p.Events) == 0 {
+		return []string{"probe_result", "incident"}
+	}
+	return p.Events
+}
+
+// GetClientID returns the client ID, defaulting to one derived from the name
+func (p *Publisher) GetClientID() string {
+	if p.ClientID == "" {
+		return "payer-status-io-" + p.Name
+	}
+	return p.ClientID
+}
+
+// GetTimeout returns the publish timeout, defaulting to 5 seconds
+func (p *Publisher) GetTimeout() time.Duration {
+	if p.Timeout == 0 {
+		return defaultPublisherTimeout
+	}
+	return p.Timeout
+}
+
+// GetQueueSize returns the buffered messages, defaulting to 1024
+func (p *Publisher) GetQueueSize() int {
+	if p.QueueSize == 0 {
+		return defaultPublisherQueueSize
+	}
+	return p.QueueSize
+}
+
+// checkSubject reports unknown placeholders and separators the broker
+// does not allow in a subject template
+func checkSubject(kind, subject string) error {
+	for _, match := range subjectPlaceholder.FindAllStringSubmatch(subject, -1) {
+		known := false
+		for _, name := range SubjectPlaceholders {
+			known = known || match[1] == name
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder {%s}, use {%s}", match[1], strings.Join(SubjectPlaceholders, "}, {"))
+		}
+	}
+
+	literal := subjectPlaceholder.ReplaceAllString(subject, "x")
+	switch kind {
+	case BrokerNATS:
+		if strings.ContainsAny(literal, " \t\r\n*>") {
+			return fmt.Errorf("NATS subject %q must not contain whitespace or wildcards", subject)
+		}
+		for _, token := range strings.Split(literal, ".") {
+			if token == "" {
+				return fmt.Errorf("NATS subject %q has an empty token", subject)
+			}
+		}
+	case BrokerMQTT:
+		if strings.ContainsAny(literal, "+#") {
+			return fmt.Errorf("MQTT topic %q must not contain wildcards", subject)
+		}
+	}
+	return nil
+}
+
+// checkBrokerURL requires a URL scheme the broker's client supports
+func checkBrokerURL(kind, raw string) error {
+	if strings.Contains(raw, "${") {
+		return nil // Checked after expansion, when connecting
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("URL %q is not an absolute URL", raw)
+	}
+	schemes := map[string][]string{
+		BrokerNATS: {"nats", "tls", "ws", "wss"},
+		BrokerMQTT: {"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"},
+	}[kind]
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("URL %q must use %s", raw, strings.Join(schemes, ", "))
+}
+
+// validatePublishers checks the publishers against each other and the
+// payers
+func (v *validator) validatePublishers(publishers []Publisher, payers []Payer, node *yaml.Node) {
+	known := make(map[string]bool, len(payers))
+	for _, payer := range payers {
+		known[payer.Name] = true
+	}
+
+	seen := make(map[string]int, len(publishers))
+	for i, publisher := range publishers {
+		item := sequenceItem(node, i)
+		name := fmt.Sprintf("publisher %s", publisher.Name)
+		if publisher.Name == "" {
+			name = fmt.Sprintf("publisher at index %d", i)
+			v.add(item, "%s has empty name", name)
+		} else if first, ok := seen[publisher.Name]; ok {
+			v.add(item, "%s duplicates publisher at index %d", name, first)
+		} else {
+			seen[publisher.Name] = i
+		}
+
+		switch publisher.Kind {
+		case BrokerNATS, BrokerMQTT:
+			if publisher.URL == "" {
+				v.add(item, "%s has no url", name)
+			} else if err := checkBrokerURL(publisher.Kind, publisher.URL); err != nil {
+				v.add(fieldNode(item, "url"), "%s: %v", name, err)
+			}
+			if err := checkSubject(publisher.Kind, publisher.GetSubject()); err != nil {
+				v.add(fieldNode(item, "subject"), "%s: %v", name, err)
+			}
+		default:
+			v.add(fieldNode(item, "kind"), "%s: kind must be %s or %s, not %q", name, BrokerNATS, BrokerMQTT, publisher.Kind)
+		}
+
+		for _, event := range publisher.Events {
+			if event != "probe_result" && event != "incident" {
+				v.add(fieldNode(item, "events"), "%s: unknown event %q, use probe_result or incident", name, event)
+			}
+		}
+
+		filterNode := fieldNode(item, "filter")
+		if err := publisher.Filter.Validate(); err != nil {
+			v.add(filterNode, "%s: %v", name, err)
+		} else if unknown := publisher.Filter.UnknownPayers(known); len(unknown) > 0 {
+			v.add(filterNode, "%s: filter names unknown payers %v", name, unknown)
+		}
+		_, filterValue := mappingValue(item, "filter")
+		v.checkLabels(filterValue, name+" filter", publisher.Filter.Labels, nil)
+
+		switch {
+		case publisher.QoS < 0 || publisher.QoS > 2:
+			v.add(fieldNode(item, "qos"), "%s: qos must be 0, 1 or 2", name)
+		case publisher.QoS != 0 && publisher.Kind != BrokerMQTT:
+			v.add(fieldNode(item, "qos"), "%s: qos only applies to mqtt", name)
+		case publisher.Retain && publisher.Kind != BrokerMQTT:
+			v.add(fieldNode(item, "retain"), "%s: retain only applies to mqtt", name)
+		case publisher.Timeout < 0:
+			v.add(fieldNode(item, "timeout"), "%s has a negative timeout", name)
+		case publisher.QueueSize < 0:
+			v.add(fieldNode(item, "queue_size"), "%s has a negative queue_size", name)
+		}
+	}
+}
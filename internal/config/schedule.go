@@ -0,0 +1,408 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleProfile overrides the probe interval during a time-of-day and
+// day-of-week window, evaluated in the endpoint's timezone
+type ScheduleProfile struct {
+	Name     string        `yaml:"name,omitempty"`
+	Days     []string      `yaml:"days,omitempty" enum:"mon,tue,wed,thu,fri,sat,sun" help:"Days the window applies, empty means every day"`
+	Start    string        `yaml:"start,omitempty" pattern:"^(([01]?[0-9]|2[0-3]):[0-5][0-9]|24:00)$" help:"Window start, HH:MM inclusive"`
+	End      string        `yaml:"end,omitempty" pattern:"^(([01]?[0-9]|2[0-3]):[0-5][0-9]|24:00)$" help:"Window end, HH:MM exclusive"`
+	Interval time.Duration `yaml:"interval" required:"true" help:"Probe interval inside the window"`
+}
+
+// window is a parsed ScheduleProfile
+type window struct {
+	days     [7]bool
+	start    int // minutes since midnight
+	end      int // minutes since midnight
+	interval time.Duration
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parse validates the profile and converts it into a window
+func (p *ScheduleProfile) parse() (*window, error) {
+	w := &window{interval: p.Interval, end: 24 * 60}
+
+	if p.Interval < time.Minute {
+		return nil, fmt.Errorf("interval must be at least 1 minute")
+	}
+
+	if len(p.Days) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, day := range p.Days {
+		d, ok := weekdayNames[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", day)
+		}
+		w.days[d] = true
+	}
+
+	var err error
+	if p.Start != "" {
+		if w.start, err = parseClock(p.Start); err != nil {
+			return nil, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+	if p.End != "" {
+		if w.end, err = parseClock(p.End); err != nil {
+			return nil, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	if w.start >= w.end {
+		return nil, fmt.Errorf("start %s must be before end %s", p.Start, p.End)
+	}
+
+	return w, nil
+}
+
+// contains reports whether t falls inside the window
+func (w *window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Location returns the timezone schedules are evaluated in, defaulting to UTC
+func (e *Endpoint) Location() (*time.Location, error) {
+	if e.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(e.Timezone)
+}
+
+// IsCron reports whether the endpoint runs on a cron expression
+func (e *Endpoint) IsCron() bool {
+	return e.Cron != ""
+}
+
+// ProfileSchedule is an endpoint's base interval and schedule profiles,
+// parsed once so the scheduler can evaluate them on every run
+type ProfileSchedule struct {
+	loc     *time.Location
+	base    time.Duration
+	windows []*window
+}
+
+// ProfileSchedule parses the endpoint's schedule profiles. Profiles that do
+// not parse are left out; validation reports them.
+func (e *Endpoint) ProfileSchedule() *ProfileSchedule {
+	loc, err := e.Location()
+	if err != nil {
+		loc = time.UTC
+	}
+	p := &ProfileSchedule{loc: loc, base: e.GetSchedule()}
+	for i := range e.Profiles {
+		if w, err := e.Profiles[i].parse(); err == nil {
+			p.windows = append(p.windows, w)
+		}
+	}
+	return p
+}
+
+// IntervalAt returns the probe interval in effect at t. The first matching
+// schedule profile wins; outside every profile the base schedule applies.
+func (p *ProfileSchedule) IntervalAt(t time.Time) time.Duration {
+	local := t.In(p.loc)
+	for _, w := range p.windows {
+		if w.contains(local) {
+			return w.interval
+		}
+	}
+	return p.base
+}
+
+// NextBoundary returns the first time after t at which a schedule profile
+// starts or ends, or the zero time if there are no profiles
+func (p *ProfileSchedule) NextBoundary(t time.Time) time.Time {
+	if len(p.windows) == 0 {
+		return time.Time{}
+	}
+
+	local := t.In(p.loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, p.loc)
+
+	var next time.Time
+	for _, w := range p.windows {
+		// Windows repeat weekly, so eight days always covers the next edge
+		for d := 0; d <= 7; d++ {
+			day := midnight.AddDate(0, 0, d)
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			for _, minute := range []int{w.start, w.end} {
+				edge := time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, p.loc)
+				if edge.After(t) && (next.IsZero() || edge.Before(next)) {
+					next = edge
+				}
+			}
+		}
+	}
+	return next
+}
+
+// MinInterval returns the shortest interval the endpoint can be probed at,
+// used to size its rate limiter
+func (e *Endpoint) MinInterval() time.Duration {
+	if e.IsCron() {
+		return time.Minute
+	}
+	min := e.GetSchedule()
+	for _, p := range e.Profiles {
+		if p.Interval >= time.Minute && p.Interval < min {
+			min = p.Interval
+		}
+	}
+	return min
+}
+
+// validateSchedule checks the cron expression, timezone and profiles
+func (e *Endpoint) validateSchedule() error {
+	if _, err := e.Location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", e.Timezone, err)
+	}
+	if e.IsCron() {
+		if len(e.Profiles) > 0 {
+			return fmt.Errorf("cron and schedule_profiles are mutually exclusive")
+		}
+		expr, err := ParseCron(e.Cron)
+		if err != nil {
+			return fmt.Errorf("invalid cron %q: %w", e.Cron, err)
+		}
+		if expr.Next(time.Now()).IsZero() {
+			return fmt.Errorf("cron %q never matches", e.Cron)
+		}
+	}
+	for i := range e.Profiles {
+		if _, err := e.Profiles[i].parse(); err != nil {
+			return fmt.Errorf("schedule profile %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// CronExpr is a parsed five-field cron expression
+// (minute hour day-of-month month day-of-week)
+type CronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronEveryHour is the hour bitset of an expression running every hour
+const cronEveryHour = 1<<24 - 1
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard five-field cron expression or one of the
+// @hourly/@daily/@weekly/@monthly/@yearly descriptors
+func ParseCron(expr string) (*CronExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	// As in classic cron, a day field starting with a star, such as */2,
+	// counts as a star when combining the two
+	c := &CronExpr{
+		domStar: strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[2], "?"),
+		dowStar: strings.HasPrefix(fields[4], "*") || strings.HasPrefix(fields[4], "?"),
+	}
+
+	specs := []struct {
+		dst   *uint64
+		field cronField
+	}{
+		{&c.minute, cronMinute},
+		{&c.hour, cronHour},
+		{&c.dom, cronDom},
+		{&c.month, cronMonth},
+		{&c.dow, cronDow},
+	}
+	for i, spec := range specs {
+		bits, err := spec.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("field %d (%s): %w", i+1, fields[i], err)
+		}
+		*spec.dst = bits
+	}
+
+	// Both 0 and 7 mean Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parse converts one cron field into a bitset
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, step := f.min, f.max, 1
+
+		rangePart := part
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step = n
+			rangePart = part[:i]
+		}
+
+		if rangePart != "*" && rangePart != "?" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = f.max
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("range %d-%d is reversed", lo, hi)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single number or name within the field's bounds
+func (f cronField) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToLower(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the first matching minute strictly after t, evaluated in
+// t's location. It returns the zero time if nothing matches within five years.
+// Times skipped when clocks go forward never match. When clocks go back,
+// expressions restricted to certain hours match only the first pass
+// through the repeated hour, as with classic cron, while those running
+// every hour match both.
+func (c *CronExpr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 || (c.hour != cronEveryHour && repeatedHour(t)) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// repeatedHour reports whether t is in the second pass through a
+// wall-clock hour repeated when clocks go back
+func repeatedHour(t time.Time) bool {
+	earlier := t.Add(-time.Hour)
+	return earlier.Hour() == t.Hour() && earlier.YearDay() == t.YearDay()
+}
+
+// forward returns next, the wall-clock time Next jumps to from t. When
+// next falls in a daylight saving gap, time.Date may resolve it to an
+// earlier instant, so the start of the following hour is used instead.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+}
+
+// dayMatches applies the cron rule that a restricted day-of-month and
+// day-of-week are OR'ed together, while a star in either AND's them
+func (c *CronExpr) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
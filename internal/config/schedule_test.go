@@ -0,0 +1,267 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "expected 5 fields, got 4"},
+		{"* * * * * *", "expected 5 fields, got 6"},
+		{"60 * * * *", "value 60 out of range 0-59"},
+		{"* 24 * * *", "value 24 out of range 0-23"},
+		{"* * 0 * *", "value 0 out of range 1-31"},
+		{"* * * 13 *", "value 13 out of range 1-12"},
+		{"* * * * 8", "value 8 out of range 0-7"},
+		{"*/0 * * * *", `invalid step "0"`},
+		{"*/x * * * *", `invalid step "x"`},
+		{"30-10 * * * *", "range 30-10 is reversed"},
+		{"* * * foo *", `invalid value "foo"`},
+		{"1,,2 * * * *", `invalid value ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseCron(%q) succeeded, want error containing %q", tt.expr, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseCron(%q) error = %q, want it to contain %q", tt.expr, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	base := time.Date(2024, time.January, 10, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", base, time.Date(2024, 1, 10, 10, 8, 0, 0, time.UTC)},
+		{"strictly after a match", "8 * * * *", time.Date(2024, 1, 10, 10, 8, 0, 0, time.UTC), time.Date(2024, 1, 10, 11, 8, 0, 0, time.UTC)},
+		{"fixed minute", "30 * * * *", base, time.Date(2024, 1, 10, 10, 30, 0, 0, time.UTC)},
+		{"fixed time tomorrow", "0 9 * * *", base, time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC)},
+		{"minute list", "5,20,40 * * * *", base, time.Date(2024, 1, 10, 10, 20, 0, 0, time.UTC)},
+		{"minute range", "10-12 * * * *", base, time.Date(2024, 1, 10, 10, 10, 0, 0, time.UTC)},
+		{"minute step", "*/15 * * * *", base, time.Date(2024, 1, 10, 10, 15, 0, 0, time.UTC)},
+		{"range with step", "0 8-18/4 * * *", base, time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)},
+		{"value with step runs to the maximum", "50/5 * * * *", base, time.Date(2024, 1, 10, 10, 50, 0, 0, time.UTC)},
+		{"day of month", "0 0 15 * *", base, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"month name", "0 0 1 mar *", base, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"month number", "0 0 1 3 *", base, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"weekday range", "0 9 * * mon-fri", time.Date(2024, 1, 12, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)},
+		{"sunday as 0", "0 0 * * 0", base, time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", base, time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"question mark", "0 0 ? * sun", base, time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"day of month or weekday", "0 0 20 * fri", base, time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"every day of month defers to weekday", "0 0 */1 * fri", base, time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"stepped day of month and weekday", "0 0 */2 * fri", base, time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"every weekday defers to day of month", "0 0 13 * */1", base, time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"leap day four years on", "0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"31st skips short months", "0 0 31 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"year rollover", "0 0 1 1 *", base, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", "@hourly", base, time.Date(2024, 1, 10, 11, 0, 0, 0, time.UTC)},
+		{"@daily", "@daily", base, time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", "@weekly", base, time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", "@monthly", base, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", "@YEARLY", base, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"never matches", "0 0 31 2 *", base, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			if got := expr.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	ny := mustLocation(t, "America/New_York")
+	est := time.FixedZone("EST", -5*3600)
+	edt := time.FixedZone("EDT", -4*3600)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Clocks go from 02:00 EST to 03:00 EDT on 2024-03-10
+		{"skipped time never matches", "30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, est), time.Date(2024, 3, 11, 2, 30, 0, 0, edt)},
+		{"hour after the gap", "0 3 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"hourly across the gap", "0 * * * *", time.Date(2024, 3, 10, 1, 30, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"every minute across the gap", "* * * * *", time.Date(2024, 3, 10, 1, 59, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"last minute before the gap", "59 1 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, est), time.Date(2024, 3, 10, 1, 59, 0, 0, est)},
+		{"stepped hours skip the gap", "0 */2 * * *", time.Date(2024, 3, 10, 1, 0, 0, 0, est), time.Date(2024, 3, 10, 4, 0, 0, 0, edt)},
+		{"restricted hours across the gap", "*/30 2-3 * * *", time.Date(2024, 3, 10, 1, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+
+		// Clocks go from 02:00 EDT back to 01:00 EST on 2024-11-03
+		{"fixed time before the change", "30 1 * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, edt), time.Date(2024, 11, 3, 1, 30, 0, 0, edt)},
+		{"fixed time runs once", "30 1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		{"restricted hours skip the repeat", "*/20 1-2 * * *", time.Date(2024, 11, 3, 1, 40, 0, 0, edt), time.Date(2024, 11, 3, 2, 0, 0, 0, est)},
+		{"minute 59 runs once", "59 1 * * *", time.Date(2024, 11, 3, 1, 59, 0, 0, edt), time.Date(2024, 11, 4, 1, 59, 0, 0, est)},
+		{"stepped hours skip the repeat", "59 1-23/2 * * *", time.Date(2024, 11, 3, 1, 59, 0, 0, edt), time.Date(2024, 11, 3, 3, 59, 0, 0, est)},
+		{"from inside the repeat", "30 1 * * *", time.Date(2024, 11, 3, 1, 10, 0, 0, est), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		{"every hour as a step runs in the repeat", "30 */1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 3, 1, 30, 0, 0, est)},
+		{"hourly runs in the repeat", "0 * * * *", time.Date(2024, 11, 3, 1, 0, 0, 0, edt), time.Date(2024, 11, 3, 1, 0, 0, 0, est)},
+		{"every 15 minutes runs in the repeat", "*/15 * * * *", time.Date(2024, 11, 3, 1, 45, 0, 0, edt), time.Date(2024, 11, 3, 1, 0, 0, 0, est)},
+		{"daily after the change", "0 9 * * *", time.Date(2024, 11, 2, 9, 0, 0, 0, edt), time.Date(2024, 11, 3, 9, 0, 0, 0, est)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+			got := expr.Next(tt.from.In(ny))
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+			if got.Location() != ny {
+				t.Errorf("Next returned location %s, want %s", got.Location(), ny)
+			}
+		})
+	}
+}
+
+func TestCronNextMidnightGap(t *testing.T) {
+	// Clocks go from 00:00 CST to 01:00 CDT on 2024-03-10, so that day has
+	// no midnight
+	havana := mustLocation(t, "America/Havana")
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"0 0 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, havana), time.Date(2024, 3, 11, 0, 0, 0, 0, havana)},
+		{"0 1 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, havana), time.Date(2024, 3, 10, 1, 0, 0, 0, havana)},
+		{"0 0 10 3 *", time.Date(2024, 3, 9, 12, 0, 0, 0, havana), time.Date(2025, 3, 10, 0, 0, 0, 0, havana)},
+	}
+	for _, tt := range tests {
+		expr, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := expr.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronNextMidnightRepeat(t *testing.T) {
+	// Clocks go from 01:00 CDT back to 00:00 CST on 2024-11-03, so that day
+	// has two midnights
+	havana := mustLocation(t, "America/Havana")
+	cdt := time.FixedZone("CDT", -4*3600)
+	cst := time.FixedZone("CST", -5*3600)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"30 0 * * *", time.Date(2024, 11, 3, 0, 30, 0, 0, cdt), time.Date(2024, 11, 4, 0, 30, 0, 0, cst)},
+		{"59 0 * * *", time.Date(2024, 11, 3, 0, 59, 0, 0, cdt), time.Date(2024, 11, 4, 0, 59, 0, 0, cst)},
+		{"0 * * * *", time.Date(2024, 11, 3, 0, 0, 0, 0, cdt), time.Date(2024, 11, 3, 0, 0, 0, 0, cst)},
+	}
+	for _, tt := range tests {
+		expr, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := expr.Next(tt.from.In(havana)); !got.Equal(tt.want) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestValidateScheduleRejectsCronThatNeverMatches(t *testing.T) {
+	endpoint := Endpoint{Cron: "0 0 30 2 *"}
+	err := endpoint.validateSchedule()
+	if err == nil || !strings.Contains(err.Error(), "never matches") {
+		t.Fatalf("validateSchedule() = %v, want a never matches error", err)
+	}
+
+	endpoint = Endpoint{Cron: "0 0 29 2 *"}
+	if err := endpoint.validateSchedule(); err != nil {
+		t.Fatalf("validateSchedule() for a leap day = %v, want nil", err)
+	}
+}
+
+func TestProfileSchedule(t *testing.T) {
+	endpoint := Endpoint{
+		Schedule: time.Hour,
+		Timezone: "America/Chicago",
+		Profiles: []ScheduleProfile{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00", Interval: 5 * time.Minute},
+			{Days: []string{"sat"}, Interval: 30 * time.Minute},
+			{Start: "12:00", End: "11:00", Interval: time.Minute}, // Invalid, left out
+		},
+	}
+	chicago := mustLocation(t, "America/Chicago")
+	profiles := endpoint.ProfileSchedule()
+
+	intervals := []struct {
+		at   time.Time
+		want time.Duration
+	}{
+		{time.Date(2024, 1, 10, 7, 59, 0, 0, chicago), time.Hour},
+		{time.Date(2024, 1, 10, 8, 0, 0, 0, chicago), 5 * time.Minute},
+		{time.Date(2024, 1, 10, 17, 59, 0, 0, chicago), 5 * time.Minute},
+		{time.Date(2024, 1, 10, 18, 0, 0, 0, chicago), time.Hour},
+		{time.Date(2024, 1, 13, 3, 0, 0, 0, chicago), 30 * time.Minute},
+		{time.Date(2024, 1, 14, 12, 0, 0, 0, chicago), time.Hour},
+		{time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC), 5 * time.Minute}, // 08:00 in Chicago
+	}
+	for _, tt := range intervals {
+		if got := profiles.IntervalAt(tt.at); got != tt.want {
+			t.Errorf("IntervalAt(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+
+	boundaries := []struct {
+		from time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 1, 10, 7, 0, 0, 0, chicago), time.Date(2024, 1, 10, 8, 0, 0, 0, chicago)},
+		{time.Date(2024, 1, 10, 8, 0, 0, 0, chicago), time.Date(2024, 1, 10, 18, 0, 0, 0, chicago)},
+		{time.Date(2024, 1, 12, 19, 0, 0, 0, chicago), time.Date(2024, 1, 13, 0, 0, 0, 0, chicago)},
+		{time.Date(2024, 1, 13, 1, 0, 0, 0, chicago), time.Date(2024, 1, 14, 0, 0, 0, 0, chicago)},
+		{time.Date(2024, 1, 14, 1, 0, 0, 0, chicago), time.Date(2024, 1, 15, 8, 0, 0, 0, chicago)},
+	}
+	for _, tt := range boundaries {
+		if got := profiles.NextBoundary(tt.from); !got.Equal(tt.want) {
+			t.Errorf("NextBoundary(%s) = %s, want %s", tt.from, got, tt.want)
+		}
+	}
+
+	if got := (&Endpoint{Schedule: time.Hour}).ProfileSchedule().NextBoundary(time.Now()); !got.IsZero() {
+		t.Errorf("NextBoundary without profiles = %s, want zero", got)
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// durationPattern matches the non-negative strings time.ParseDuration
+// accepts; no duration in the config may be negative
+const durationPattern = `^(0|\+?(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema returns a JSON Schema (draft 2020-12) for the config file, derived
+// from the Config types. Descriptions come from the help tags of the fields,
+// and enum, pattern and required tags constrain them further. A required tag
+// of "unless:key" lets the other key stand in for the field. suggest tags
+// list values editors offer without rejecting others. Unknown keys are
+// rejected, as they are by validation.
+func Schema() map[string]any {
+	b := &schemaBuilder{defs: make(map[string]any)}
+	schema := b.structSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "payer-status-io configuration"
+	schema["$defs"] = b.defs
+	return schema
+}
+
+// schemaBuilder collects the schemas of nested structs as $defs
+type schemaBuilder struct {
+	defs map[string]any
+}
+
+// typeSchema returns the schema of a Go type, referring to structs by name
+func (b *schemaBuilder) typeSchema(t reflect.Type) map[string]any {
+	if t == durationType {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := b.defs[t.Name()]; !ok {
+			b.defs[t.Name()] = nil // Reserve the name in case the type is recursive
+			b.defs[t.Name()] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the object schema of a struct's YAML fields
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	var defaults reflect.Value
+	if t == reflect.TypeOf(ServerConfig{}) {
+		defaults = reflect.ValueOf(DefaultServerConfig())
+	}
+
+	properties := make(map[string]any)
+	required := []string{}
+	var alternatives []any
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		schema := b.typeSchema(field.Type)
+		if help := field.Tag.Get("help"); help != "" {
+			schema["description"] = help
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			target := schema
+			if items, ok := schema["items"].(map[string]any); ok {
+				target = items
+			}
+			target["enum"] = strings.Split(enum, ",")
+		}
+		if suggest := field.Tag.Get("suggest"); suggest != "" {
+			schema["anyOf"] = []any{
+				map[string]any{"enum": strings.Split(suggest, ",")},
+				map[string]any{"type": "string"},
+			}
+		}
+		if pattern := field.Tag.Get("pattern"); pattern != "" {
+			schema["pattern"] = pattern
+		}
+		if defaults.IsValid() {
+			schema["default"] = schemaDefault(defaults.Field(i))
+		}
+		switch tag := field.Tag.Get("required"); {
+		case tag == "true":
+			required = append(required, name)
+		case strings.HasPrefix(tag, "unless:"):
+			alternatives = append(alternatives, map[string]any{"anyOf": []any{
+				map[string]any{"required": []string{name}},
+				map[string]any{"required": []string{strings.TrimPrefix(tag, "unless:")}},
+			}})
+		}
+		properties[name] = schema
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(alternatives) > 0 {
+		schema["allOf"] = alternatives
+	}
+	return schema
+}
+
+// schemaDefault converts a default value to the form it takes in YAML
+func schemaDefault(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return v.Interface()
+}
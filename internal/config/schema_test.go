@@ -0,0 +1,211 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaValidator checks documents against the subset of JSON Schema that
+// Schema emits
+type schemaValidator struct {
+	root map[string]any
+}
+
+func newSchemaValidator(t *testing.T) *schemaValidator {
+	t.Helper()
+	data, err := json.Marshal(Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root map[string]any
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatal(err)
+	}
+	return &schemaValidator{root: root}
+}
+
+// validateYAML decodes a YAML document as JSON would see it and returns
+// every violation
+func (v *schemaValidator) validateYAML(t *testing.T, data []byte) []string {
+	t.Helper()
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(normalized, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return v.validate(v.root, doc, "")
+}
+
+func (v *schemaValidator) validate(schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		def := v.root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")]
+		return v.validate(def.(map[string]any), value, path)
+	}
+
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("want an object, got %v", value)
+			return problems
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, item := range object {
+			if property, ok := properties[key].(map[string]any); ok {
+				problems = append(problems, v.validate(property, item, path+"/"+key)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, v.validate(additional, item, path+"/"+key)...)
+			} else if schema["additionalProperties"] == false {
+				fail("unknown key %q", key)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			fail("want an array, got %v", value)
+			return problems
+		}
+		for i, item := range array {
+			problems = append(problems, v.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s/%d", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("want a string, got %v", value)
+			return problems
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			fail("%q does not match %s", s, pattern)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			fail("want an integer, got %v", value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			fail("want a number, got %v", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("want a boolean, got %v", value)
+		}
+	}
+
+	if object, ok := value.(map[string]any); ok {
+		required, _ := schema["required"].([]any)
+		for _, key := range required {
+			if _, ok := object[key.(string)]; !ok {
+				fail("missing %q", key)
+			}
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !containsValue(enum, value) {
+		fail("%v is not one of %v", value, enum)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && !v.matchesAny(anyOf, value, path) {
+		fail("%v matches none of the alternatives", value)
+	}
+	allOf, _ := schema["allOf"].([]any)
+	for _, sub := range allOf {
+		problems = append(problems, v.validate(sub.(map[string]any), value, path)...)
+	}
+	return problems
+}
+
+func (v *schemaValidator) matchesAny(schemas []any, value any, path string) bool {
+	for _, schema := range schemas {
+		if len(v.validate(schema.(map[string]any), value, path)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExampleConfigMatchesSchema(t *testing.T) {
+	v := newSchemaValidator(t)
+	for _, path := range []string{"../../docs/payer_status.yaml", "../../test_config.yaml", "../../test-config-fast.yaml"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range v.validateYAML(t, data) {
+			t.Errorf("%s%s", path, problem)
+		}
+	}
+}
+
+func TestSchemaRejectsInvalidConfigs(t *testing.T) {
+	v := newSchemaValidator(t)
+	tests := []struct {
+		name string
+		yaml string
+		want string // Substring of the only problem, empty for none
+	}{
+		{"minimal", minimalConfig, ""},
+		{"payers from includes", "include: [payers/]\n", ""},
+		{"no payers", "server: {ws_port: \"8080\"}\n", "matches none of the alternatives"},
+		{"unknown kind allowed", "payers: [{name: A, endpoints: [{type: fax_portal, url: \"https://a.example.com\"}]}]\n", ""},
+		{"unknown key", "payers: [{name: A, endpoints: [{type: login, urll: \"https://a.example.com\"}]}]\n", `unknown key "urll"`},
+		{"method enum", "payers: [{name: A, endpoints: [{type: login, method: FETCH}]}]\n", "FETCH is not one of"},
+		{"broker kind enum", minimalConfig + "publishers: [{name: p, kind: kafka, url: \"kafka://x\"}]\n", "kafka is not one of"},
+		{"filter status enum", minimalConfig + "webhooks: [{name: w, url: \"https://h.example.com\", filter: {status: degraded}}]\n", "degraded is not one of"},
+		{"compound duration", "payers: [{name: A, endpoints: [{type: login, schedule: 1h30m}]}]\n", ""},
+		{"fractional duration", "payers: [{name: A, endpoints: [{type: login, timeout: 1.5s}]}]\n", ""},
+		{"negative duration", "payers: [{name: A, endpoints: [{type: login, schedule: -5m}]}]\n", "does not match"},
+		{"negative server duration", minimalConfig + "server: {probe_timeout: -1s}\n", "does not match"},
+		{"unit without number", "payers: [{name: A, endpoints: [{type: login, schedule: m}]}]\n", "does not match"},
+		{"duration without unit", "payers: [{name: A, endpoints: [{type: login, schedule: 15}]}]\n", "want a string"},
+	}
+	for _, tt := range tests {
+		problems := v.validateYAML(t, []byte(tt.yaml))
+		switch {
+		case tt.want == "" && len(problems) > 0:
+			t.Errorf("%s: rejected: %v", tt.name, problems)
+		case tt.want != "" && (len(problems) != 1 || !strings.Contains(problems[0], tt.want)):
+			t.Errorf("%s: problems %v, want one containing %q", tt.name, problems, tt.want)
+		}
+	}
+}
+
+func TestSchemaSuggestsEndpointKinds(t *testing.T) {
+	endpoint := Schema()["$defs"].(map[string]any)["Endpoint"].(map[string]any)
+	kind := endpoint["properties"].(map[string]any)["type"].(map[string]any)
+	anyOf, ok := kind["anyOf"].([]any)
+	if !ok || len(anyOf) != 2 {
+		t.Fatalf("type schema = %v, want suggested kinds", kind)
+	}
+	kinds := append([]string(nil), anyOf[0].(map[string]any)["enum"].([]string)...)
+	sort.Strings(kinds)
+	for _, want := range []string{"api", "login", "patient_search"} {
+		if i := sort.SearchStrings(kinds, want); i == len(kinds) || kinds[i] != want {
+			t.Errorf("suggested kinds %v lack %s", kinds, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerConfig holds runtime settings. Values come from the YAML `server`
+// section and can be overridden by environment variables (the upper-cased
+// key, e.g. WS_PORT) and command-line flags (e.g. --ws-port).
+type ServerConfig struct {
+	WSPort      string `yaml:"ws_port" help:"HTTP and WebSocket listen port (restart required)"`
+	MetricsPort string `yaml:"metrics_port" help:"Prometheus metrics listen port (restart required)"`
+	LogLevel    string `yaml:"log_level" enum:"debug,info,warn,error" help:"Log level: debug, info, warn or error"`
+	StatePath   string `yaml:"state_path" help:"File where pauses and mutes are persisted (restart required)"`
+
+	Workers         int `yaml:"workers" help:"Number of probe workers (restart required)"`
+	TaskChannelSize int `yaml:"task_channel_size" help:"Capacity of the scheduler task channel (restart required)"`
+
+	ProbeTimeout        time.Duration `yaml:"probe_timeout" help:"Default probe request timeout"`
+	MaxRedirects        int           `yaml:"max_redirects" help:"Default number of redirects a probe follows"`
+	MaxIdleConns        int           `yaml:"max_idle_conns" help:"Idle connections kept per host client"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host" help:"Idle connections kept per destination host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout" help:"How long idle probe connections are kept"`
+
+	JitterPct     float64       `yaml:"jitter_pct" help:"Random schedule jitter as a fraction of the interval"`
+	TriggerMinGap time.Duration `yaml:"trigger_min_gap" help:"Minimum time between on-demand probes of one endpoint"`
+
+	OverflowPolicy string        `yaml:"overflow_policy" enum:"drop,retry,block" help:"Full task channel handling: drop, retry or block"`
+	OverflowWait   time.Duration `yaml:"overflow_wait" help:"Retry delay or block deadline for the overflow policy"`
+	OverdueFactor  float64       `yaml:"overdue_factor" help:"Intervals without a probe before an endpoint is overdue"`
+
+	MaxInFlight     int     `yaml:"max_in_flight" help:"Probes in flight across all hosts (0 = unlimited)"`
+	MaxRPS          float64 `yaml:"max_rps" help:"Probes started per second across all hosts (0 = unlimited)"`
+	HostMaxInFlight int     `yaml:"host_max_in_flight" help:"Probes in flight to one host (0 = unlimited)"`
+	HostMaxRPS      float64 `yaml:"host_max_rps" help:"Probes started per second to one host (0 = unlimited)"`
+
+	WatchConfig   bool          `yaml:"watch_config" help:"Reload the config when its files change, besides on SIGHUP (restart required)"`
+	WatchDebounce time.Duration `yaml:"watch_debounce" help:"How long file changes must settle before a reload (restart required)"`
+
+	MetricLabels string `yaml:"metric_labels" help:"Comma-separated endpoint labels added to probe metrics (restart required)"`
+
+	WSAllowedOrigins string `yaml:"ws_allowed_origins" help:"Comma-separated host patterns of browser origins allowed to open the WebSocket"`
+	WSAPIKeysFile    string `yaml:"ws_api_keys_file" help:"YAML file of API keys accepted on the WebSocket"`
+	WSJWKSFile       string `yaml:"ws_jwks_file" help:"JWKS file whose keys verify WebSocket JWTs"`
+	WSJWTIssuer      string `yaml:"ws_jwt_issuer" help:"Required iss claim of WebSocket JWTs"`
+	WSJWTAudience    string `yaml:"ws_jwt_audience" help:"Required aud claim of WebSocket JWTs"`
+
+	WSReplayBuffer int `yaml:"ws_replay_buffer" help:"Recent messages kept for clients that reconnect with resume_from (restart required)"`
+
+	WebhookDeadLetterPath string `yaml:"webhook_dead_letter_path" help:"JSON Lines file of webhook events that failed every attempt"`
+
+	ClusterLockFile     string        `yaml:"cluster_lock_file" help:"Lock file shared by the instances of a cluster; setting it enables clustering (restart required)"`
+	ClusterAdvertiseURL string        `yaml:"cluster_advertise_url" help:"Base URL other instances reach this one at, e.g. http://10.0.0.5:8080 (restart required)"`
+	ClusterSecret       string        `yaml:"cluster_secret" help:"Shared secret instances present to relay results; ${VAR} is expanded (restart required)"`
+	ClusterPollInterval time.Duration `yaml:"cluster_poll_interval" help:"How often a follower tries to become the leader (restart required)"`
+}
+
+// DefaultServerConfig returns the settings used when nothing overrides them
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		WSPort:      "8080",
+		MetricsPort: "9090",
+		LogLevel:    "info",
+		StatePath:   "./data/scheduler_state.json",
+
+		Workers:         50,
+		TaskChannelSize: 1000,
+
+		ProbeTimeout:        10 * time.Second,
+		MaxRedirects:        5,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+
+		JitterPct:     0.1, // 10% jitter as per .windsurfrules
+		TriggerMinGap: 30 * time.Second,
+
+		OverflowPolicy: "drop",
+		OverflowWait:   5 * time.Second,
+		OverdueFactor:  3,
+
+		WatchDebounce: time.Second,
+
+		WSAllowedOrigins: "*",
+		WSReplayBuffer:   1000,
+
+		WebhookDeadLetterPath: "./data/webhook_dead_letter.jsonl",
+
+		ClusterPollInterval: 5 * time.Second,
+	}
+}
+
+// ServerSetting describes one overridable runtime setting
+type ServerSetting struct {
+	Key  string // YAML key, e.g. ws_port
+	Env  string // Environment variable, e.g. WS_PORT
+	Flag string // Command-line flag, e.g. ws-port
+	Help string
+	Bool bool // Whether the flag may be given without a value
+}
+
+// ServerSettings lists every setting in declaration order
+func ServerSettings() []ServerSetting {
+	t := reflect.TypeOf(ServerConfig{})
+	settings := make([]ServerSetting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("yaml")
+		settings = append(settings, ServerSetting{
+			Key:  key,
+			Env:  strings.ToUpper(key),
+			Flag: strings.ReplaceAll(key, "_", "-"),
+			Help: field.Tag.Get("help"),
+			Bool: field.Type.Kind() == reflect.Bool,
+		})
+	}
+	return settings
+}
+
+// Set parses value into the setting with the given YAML key
+func (s *ServerConfig) Set(key, value string) error {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("yaml") != key {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not an integer", key, value)
+			}
+			field.SetInt(int64(n))
+		case float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a number", key, value)
+			}
+			field.SetFloat(f)
+		case bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a boolean", key, value)
+			}
+			field.SetBool(b)
+		case time.Duration:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a duration", key, value)
+			}
+			field.SetInt(int64(d))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown server setting %q", key)
+}
+
+// applyOverrides applies environment variables, then explicit overrides
+// such as command-line flags, on top of the YAML values
+func (s *ServerConfig) applyOverrides(lookupEnv func(string) (string, bool), overrides map[string]string) error {
+	for _, setting := range ServerSettings() {
+		if value, ok := lookupEnv(setting.Env); ok && value != "" {
+			if err := s.Set(setting.Key, value); err != nil {
+				return fmt.Errorf("environment %s: %w", setting.Env, err)
+			}
+		}
+	}
+	for key, value := range overrides {
+		if err := s.Set(key, value); err != nil {
+			return fmt.Errorf("flag --%s: %w", strings.ReplaceAll(key, "_", "-"), err)
+		}
+	}
+	return nil
+}
+
+// validate checks the settings for values the server cannot run with
+func (s *ServerConfig) validate() error {
+	switch {
+	case s.WSPort == "" || s.MetricsPort == "":
+		return fmt.Errorf("ws_port and metrics_port are required")
+	case s.Workers < 1:
+		return fmt.Errorf("workers must be at least 1")
+	case s.TaskChannelSize < 1:
+		return fmt.Errorf("task_channel_size must be at least 1")
+	case s.ProbeTimeout <= 0:
+		return fmt.Errorf("probe_timeout must be positive")
+	case s.MaxRedirects < 0:
+		return fmt.Errorf("max_redirects cannot be negative")
+	case s.JitterPct < 0 || s.JitterPct > 0.5:
+		return fmt.Errorf("jitter_pct must be between 0 and 0.5")
+	case s.MaxInFlight < 0 || s.HostMaxInFlight < 0 || s.MaxRPS < 0 || s.HostMaxRPS < 0:
+		return fmt.Errorf("concurrency limits cannot be negative")
+	case s.WSReplayBuffer < 0:
+		return fmt.Errorf("ws_replay_buffer cannot be negative")
+	}
+
+	if err := s.checkCluster(); err != nil {
+		return err
+	}
+
+	switch s.OverflowPolicy {
+	case "drop", "retry", "block":
+	default:
+		return fmt.Errorf("overflow_policy must be drop, retry or block")
+	}
+
+	switch s.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log_level must be debug, info, warn or error")
+	}
+
+	return nil
+}
+
+// RestartRequired lists the settings that differ between s and next but
+// only take effect on restart
+func (s *ServerConfig) RestartRequired(next *ServerConfig) []string {
+	var keys []string
+	if s.WSPort != next.WSPort {
+		keys = append(keys, "ws_port")
+	}
+	if s.MetricsPort != next.MetricsPort {
+		keys = append(keys, "metrics_port")
+	}
+	if s.StatePath != next.StatePath {
+		keys = append(keys, "state_path")
+	}
+	if s.Workers != next.Workers {
+		keys = append(keys, "workers")
+	}
+	if s.TaskChannelSize != next.TaskChannelSize {
+		keys = append(keys, "task_channel_size")
+	}
+	if s.WatchConfig != next.WatchConfig {
+		keys = append(keys, "watch_config")
+	}
+	if s.WatchDebounce != next.WatchDebounce {
+		keys = append(keys, "watch_debounce")
+	}
+	if s.MetricLabels != next.MetricLabels {
+		keys = append(keys, "metric_labels")
+	}
+	if s.WSReplayBuffer != next.WSReplayBuffer {
+		keys = append(keys, "ws_replay_buffer")
+	}
+	if s.ClusterLockFile != next.ClusterLockFile {
+		keys = append(keys, "cluster_lock_file")
+	}
+	if s.ClusterAdvertiseURL != next.ClusterAdvertiseURL {
+		keys = append(keys, "cluster_advertise_url")
+	}
+	if s.ClusterSecret != next.ClusterSecret {
+		keys = append(keys, "cluster_secret")
+	}
+	if s.ClusterPollInterval != next.ClusterPollInterval {
+		keys = append(keys, "cluster_poll_interval")
+	}
+	return keys
+}
+
+// checkCluster requires the settings instances need to find each other
+// once clustering is enabled
+func (s *ServerConfig) checkCluster() error {
+	if s.ClusterLockFile == "" {
+		return nil
+	}
+	switch {
+	case s.ClusterAdvertiseURL == "":
+		return fmt.Errorf("cluster_advertise_url is required with cluster_lock_file")
+	case s.ClusterSecret == "":
+		return fmt.Errorf("cluster_secret is required with cluster_lock_file")
+	case s.ClusterPollInterval <= 0:
+		return fmt.Errorf("cluster_poll_interval must be positive")
+	}
+	if err := checkURL(s.ClusterAdvertiseURL); err != nil {
+		return fmt.Errorf("cluster_advertise_url: %w", err)
+	}
+	return nil
+}
+
+// WSOriginPatterns returns the host patterns of origins allowed to open the
+// WebSocket
+func (s *ServerConfig) WSOriginPatterns() []string {
+	return splitList(s.WSAllowedOrigins)
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
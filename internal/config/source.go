@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotModified is returned by a ConfigSource when the configuration has
+// not changed since the previous successful fetch
+var ErrNotModified = errors.New("config not modified")
+
+// Document is a fetched configuration file
+type Document struct {
+	Name    string // File path or URL, used in errors and reload events
+	BaseDir string // Directory include patterns are relative to, empty if includes are unsupported
+	Data    []byte
+
+	// Revision identifies the fetched version, such as an ETag or a commit,
+	// for a source that skips unchanged versions
+	Revision string
+}
+
+// ConfigSource provides the main configuration document to a Loader
+type ConfigSource interface {
+	// Name describes the source for logs
+	Name() string
+	// Fetch returns the current document, or ErrNotModified if it has not
+	// changed since the last fetch. A source that cannot refresh but has a
+	// local copy may return that copy along with the error.
+	Fetch(ctx context.Context) (*Document, error)
+}
+
+// AcceptingSource is a ConfigSource told which documents the loader
+// applied. It reports ErrNotModified only for an accepted revision, so a
+// rejected document is fetched and checked again on the next poll.
+type AcceptingSource interface {
+	ConfigSource
+	// Accept records that doc passed validation and the reload checks
+	Accept(doc *Document)
+}
+
+// FileSource reads the configuration from a local file on every fetch
+type FileSource struct {
+	Path string
+}
+
+// NewFileSource creates a source for a local file
+func NewFileSource(path string) *FileSource {
+	return &FileSource{Path: path}
+}
+
+func (s *FileSource) Name() string { return s.Path }
+
+// Fetch reads the file
+func (s *FileSource) Fetch(ctx context.Context) (*Document, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", s.Path, err)
+	}
+	return &Document{Name: s.Path, BaseDir: filepath.Dir(s.Path), Data: data}, nil
+}
+
+// HTTPSource polls a configuration service, using the ETag of the last
+// response so unchanged configs are not downloaded again. Includes are not
+// supported.
+type HTTPSource struct {
+	URL    string
+	Header http.Header // Extra request headers, e.g. Authorization
+	Client *http.Client
+
+	mu   sync.Mutex
+	etag string
+}
+
+// NewHTTPSource creates a source for a configuration URL
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{
+		URL:    url,
+		Header: make(http.Header),
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *HTTPSource) Name() string { return s.URL }
+
+// Fetch downloads the configuration unless the server reports it unchanged
+func (s *HTTPSource) Fetch(ctx context.Context) (*Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	s.mu.Lock()
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.Unlock()
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch config from %s: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, ErrNotModified
+	default:
+		return nil, fmt.Errorf("failed to fetch config from %s: %s", s.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config from %s: %w", s.URL, err)
+	}
+
+	return &Document{Name: s.URL, Data: data, Revision: resp.Header.Get("ETag")}, nil
+}
+
+// Accept sends the document's ETag with later fetches
+func (s *HTTPSource) Accept(doc *Document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag = doc.Revision
+}
+
+// GitSource reads the configuration from a local Git checkout, pulling the
+// tracked branch before each fetch. Includes are resolved inside the
+// checkout.
+type GitSource struct {
+	Dir  string // Checkout directory
+	File string // Config file, relative to Dir
+
+	mu   sync.Mutex
+	head string // Commit of the last accepted fetch
+}
+
+// NewGitSource creates a source for a file in a Git checkout
+func NewGitSource(dir, file string) *GitSource {
+	return &GitSource{Dir: dir, File: file}
+}
+
+func (s *GitSource) Name() string { return "git:" + filepath.Join(s.Dir, s.File) }
+
+// Fetch fast-forwards the checkout and reads the file if the commit changed.
+// If the first pull fails, the checkout as it is on disk is returned along
+// with the error; later failures keep the current config.
+func (s *GitSource) Fetch(ctx context.Context) (*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, pullErr := s.git(ctx, "pull", "--ff-only", "--quiet")
+	if pullErr != nil && s.head != "" {
+		return nil, pullErr
+	}
+
+	head, err := s.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if pullErr == nil && head == s.head {
+		return nil, ErrNotModified
+	}
+
+	doc, err := s.read(head)
+	if err != nil {
+		return nil, err
+	}
+	return doc, pullErr
+}
+
+// read reads the config file from the checkout at the given commit
+func (s *GitSource) read(head string) (*Document, error) {
+	path := filepath.Join(s.Dir, s.File)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return &Document{Name: path, BaseDir: filepath.Dir(path), Data: data, Revision: head}, nil
+}
+
+// Accept skips the document's commit on later fetches
+func (s *GitSource) Accept(doc *Document) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.head = doc.Revision
+}
+
+// git runs a git command in the checkout and returns its trimmed output
+func (s *GitSource) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.Dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s in %s: %w: %s", args[0], s.Dir, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
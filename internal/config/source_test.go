@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const minimalConfig = `
+payers:
+  - name: Acme
+    endpoints:
+      - {type: login, url: "https://acme.example.com/login", schedule: 5m}
+`
+
+func TestHTTPSourceRefetchesRejectedConfig(t *testing.T) {
+	var mu sync.Mutex
+	var conditional []string // If-None-Match of each request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(minimalConfig))
+	}))
+	defer server.Close()
+
+	loader := NewLoader("", zap.NewNop())
+	loader.SetSource(NewHTTPSource(server.URL))
+	reject := true
+	loader.OnConfigCheck(func(*Config) error {
+		if reject {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	if _, err := loader.load(); !errors.Is(err, ErrConfigRejected) {
+		t.Fatalf("first load: err = %v, want ErrConfigRejected", err)
+	}
+
+	reject = false
+	if changed, err := loader.load(); err != nil || !changed {
+		t.Fatalf("second load = %v, %v; want the rejected config fetched and applied", changed, err)
+	}
+	if changed, err := loader.load(); err != nil || changed {
+		t.Fatalf("third load = %v, %v; want not modified", changed, err)
+	}
+
+	want := []string{"", "", `"v1"`}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conditional) != len(want) {
+		t.Fatalf("server saw %d requests, want %d", len(conditional), len(want))
+	}
+	for i := range want {
+		if conditional[i] != want[i] {
+			t.Errorf("request %d: If-None-Match = %q, want %q", i, conditional[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// allowedMethods are the HTTP methods an endpoint may probe with
+var allowedMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true,
+}
+
+// maxSchedule bounds probe intervals; a longer one is almost certainly a typo
+const maxSchedule = 24 * time.Hour
+
+// ValidationError is one problem found in a config file. Line and Column
+// are 1-based and zero when the problem has no position in the file.
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// ValidationErrors collects every problem found in a config file
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+// validator accumulates problems for one config file
+type validator struct {
+	file string
+	errs ValidationErrors
+}
+
+// add records a problem at a node's position; node may be nil
+func (v *validator) add(node *yaml.Node, format string, args ...interface{}) {
+	err := &ValidationError{File: v.file, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		err.Line, err.Column = node.Line, node.Column
+	}
+	v.errs = append(v.errs, err)
+}
+
+// err returns the collected problems sorted by file, then position, or nil
+// if there were none
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].File != v.errs[j].File {
+			return v.errs[i].File < v.errs[j].File
+		}
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+// typeErrorLine extracts the line from a yaml.v3 type error message
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// decode strictly decodes a document into out. Unknown keys and type
+// mismatches are all recorded rather than stopping at the first.
+func (v *validator) decode(doc *yaml.Node, out interface{}) {
+	v.checkKeys(doc, reflect.TypeOf(out))
+
+	err := doc.Decode(out)
+	if err == nil {
+		return
+	}
+
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		v.add(nil, "%v", err)
+		return
+	}
+	for _, msg := range typeErr.Errors {
+		m := typeErrorLine.FindStringSubmatch(msg)
+		if m == nil {
+			v.add(nil, "%s", msg)
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		v.errs = append(v.errs, &ValidationError{File: v.file, Line: line, Column: 1, Message: m[2]})
+	}
+}
+
+// checkKeys reports mapping keys that do not match a yaml field of t
+func (v *validator) checkKeys(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			v.checkKeys(child, t)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		if node.Kind == yaml.SequenceNode {
+			for _, child := range node.Content {
+				v.checkKeys(child, t.Elem())
+			}
+		}
+
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode || t == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				v.add(key, "unknown field %q in %s", key.Value, strings.ToLower(t.Name()))
+				continue
+			}
+			v.checkKeys(value, field.Type)
+		}
+	}
+}
+
+// yamlFields maps yaml keys to the struct fields they decode into
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// mappingValue returns the key and value nodes for key in a mapping node
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// sequenceItem returns the i-th item of a sequence node
+func sequenceItem(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
+
+// fieldNode returns the node to report a field's problem at: its key if
+// present, otherwise the enclosing mapping
+func fieldNode(mapping *yaml.Node, key string) *yaml.Node {
+	if k, _ := mappingValue(mapping, key); k != nil {
+		return k
+	}
+	return mapping
+}
+
+// validateConfig checks a decoded config. doc is the main file, used for
+// positions of top-level problems; sources has the position of each payer.
+func (v *validator) validateConfig(config *Config, doc *yaml.Node, sources []payerSource) {
+	root := doc
+	if root != nil && root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	mainFile := v.file
+
+	if err := config.Server.validate(); err != nil {
+		v.add(fieldNode(root, "server"), "server: %v", err)
+	}
+	if err := config.Server.checkMetricLabels(); err != nil {
+		// The list may come from the environment or a flag instead
+		node := fieldNode(root, "server")
+		if _, server := mappingValue(root, "server"); server != nil {
+			if _, labels := mappingValue(server, "metric_labels"); labels != nil {
+				node = labels
+			}
+		}
+		v.add(node, "server: %v", err)
+	}
+
+	if len(config.Payers) == 0 {
+		v.add(fieldNode(root, "payers"), "no payers configured")
+		return
+	}
+
+	defined := make(map[string]payerSource, len(config.Payers))
+	for i, payer := range config.Payers {
+		source := payerSource{file: mainFile}
+		if i < len(sources) {
+			source = sources[i]
+		}
+		v.file = source.file
+
+		if first, ok := defined[payer.Name]; ok && payer.Name != "" {
+			line := 0
+			if first.node != nil {
+				line = first.node.Line
+			}
+			v.add(source.node, "payer %s is already defined at %s:%d", payer.Name, first.file, line)
+		} else {
+			defined[payer.Name] = source
+		}
+
+		v.validatePayer(payer, i, source.node)
+	}
+	v.file = mainFile
+
+	_, webhooks := mappingValue(root, "webhooks")
+	v.validateWebhooks(config.Webhooks, config.Payers, webhooks)
+	_, publishers := mappingValue(root, "publishers")
+	v.validatePublishers(config.Publishers, config.Payers, publishers)
+}
+
+// validatePayer checks one payer and its endpoints
+func (v *validator) validatePayer(payer Payer, index int, node *yaml.Node) {
+	name := payer.Name
+	if name == "" {
+		name = fmt.Sprintf("at index %d", index)
+		v.add(node, "payer %s has empty name", name)
+	}
+
+	v.checkLabels(node, "payer "+name, payer.Labels, nil)
+
+	endpointsKey, endpointsNode := mappingValue(node, "endpoints")
+	if len(payer.Endpoints) == 0 {
+		if endpointsKey == nil {
+			endpointsKey = node
+		}
+		v.add(endpointsKey, "payer %s has no endpoints", name)
+		return
+	}
+
+	seen := make(map[string]int, len(payer.Endpoints))
+	for j, endpoint := range payer.Endpoints {
+		endpointNode := sequenceItem(endpointsNode, j)
+		v.validateEndpoint(name, endpoint, j, endpointNode)
+		v.checkLabels(endpointNode, fmt.Sprintf("payer %s endpoint %s", name, endpoint.Type), endpoint.Labels, payer.Labels)
+
+		identity := strings.Join([]string{endpoint.Type, endpoint.GetMethod(), endpoint.URL, endpoint.Path, endpoint.URLContains}, "\x00")
+		if first, ok := seen[identity]; ok {
+			v.add(endpointNode, "payer %s endpoint %s duplicates endpoint at index %d", name, endpoint.Type, first)
+			continue
+		}
+		seen[identity] = j
+	}
+}
+
+// validateEndpoint checks one endpoint's fields
+func (v *validator) validateEndpoint(payerName string, endpoint Endpoint, index int, node *yaml.Node) {
+	name := fmt.Sprintf("payer %s endpoint %s", payerName, endpoint.Type)
+
+	if endpoint.Type == "" {
+		name = fmt.Sprintf("payer %s endpoint at index %d", payerName, index)
+		v.add(node, "%s has empty type", name)
+	}
+
+	if endpoint.URL == "" && endpoint.Path == "" && endpoint.URLContains == "" {
+		v.add(node, "%s has no URL, path, or url_contains", name)
+	}
+
+	if endpoint.URL != "" {
+		if err := checkURL(endpoint.URL); err != nil {
+			v.add(fieldNode(node, "url"), "%s: %v", name, err)
+		}
+	}
+	if endpoint.Path != "" {
+		if u, err := url.Parse(endpoint.Path); err != nil {
+			v.add(fieldNode(node, "path"), "%s: invalid path %q", name, endpoint.Path)
+		} else if u.IsAbs() {
+			v.add(fieldNode(node, "path"), "%s: path %q is a full URL, use url instead", name, endpoint.Path)
+		}
+	}
+
+	if endpoint.Method != "" && !allowedMethods[endpoint.Method] {
+		v.add(fieldNode(node, "method"), "%s: unsupported method %q", name, endpoint.Method)
+	}
+
+	switch {
+	case endpoint.Schedule < 0:
+		v.add(fieldNode(node, "schedule"), "%s has a negative schedule", name)
+	case endpoint.Schedule != 0 && endpoint.Schedule < time.Minute:
+		v.add(fieldNode(node, "schedule"), "%s has schedule less than 1 minute", name)
+	case endpoint.Schedule > maxSchedule:
+		v.add(fieldNode(node, "schedule"), "%s has schedule longer than %s", name, maxSchedule)
+	}
+
+	switch {
+	case endpoint.Timeout < 0:
+		v.add(fieldNode(node, "timeout"), "%s has a negative timeout", name)
+	case endpoint.Timeout > 0 && !endpoint.IsCron() && endpoint.Timeout >= endpoint.MinInterval():
+		v.add(fieldNode(node, "timeout"), "%s has a timeout of %s, not shorter than its interval", name, endpoint.Timeout)
+	}
+
+	if endpoint.MaxRedirects != nil && *endpoint.MaxRedirects < 0 {
+		v.add(fieldNode(node, "max_redirects"), "%s has negative max_redirects", name)
+	}
+
+	if endpoint.Thresholds.MaxLatency < 0 {
+		v.add(fieldNode(node, "thresholds"), "%s has a negative max_latency threshold", name)
+	}
+
+	if err := endpoint.validateSchedule(); err != nil {
+		v.add(node, "%s: %v", name, err)
+	}
+}
+
+// checkURL verifies a URL is absolute http or https. URLs built from
+// environment variables are only checked once expanded at probe time.
+func checkURL(raw string) error {
+	if strings.Contains(raw, "${") {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+	return nil
+}
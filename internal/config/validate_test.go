@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidatorSortsByFileThenPosition(t *testing.T) {
+	v := &validator{}
+	for _, e := range []struct {
+		file         string
+		line, column int
+	}{
+		{"payers/b.yaml", 1, 1},
+		{"main.yaml", 9, 3},
+		{"payers/a.yaml", 4, 7},
+		{"main.yaml", 2, 5},
+		{"payers/a.yaml", 4, 2},
+	} {
+		v.file = e.file
+		v.add(&yaml.Node{Line: e.line, Column: e.column}, "problem")
+	}
+
+	var errs ValidationErrors
+	if !errors.As(v.err(), &errs) {
+		t.Fatalf("err() = %v, want ValidationErrors", v.err())
+	}
+	want := []string{
+		"main.yaml:2:5",
+		"main.yaml:9:3",
+		"payers/a.yaml:4:2",
+		"payers/a.yaml:4:7",
+		"payers/b.yaml:1:1",
+	}
+	for i, err := range errs {
+		if got := err.Error(); got != want[i]+": problem" {
+			t.Errorf("error %d = %q, want %q", i, got, want[i]+": problem")
+		}
+	}
+}
+
+func TestValidateRejectsDuplicateMetricLabels(t *testing.T) {
+	doc := &Document{Name: "main.yaml", Data: []byte(`server:
+  metric_labels: team, region, team
+` + minimalConfig)}
+
+	_, _, err := NewLoader("", zap.NewNop()).parse(doc)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("parse() error = %v, want one validation error", err)
+	}
+	want := `main.yaml:2:18: server: metric_labels: "team" is listed twice`
+	if got := errs[0].Error(); got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+}
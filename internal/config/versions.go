@@ -0,0 +1,118 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// maxConfigVersions bounds how many applied configs the loader keeps for
+// rollback
+const maxConfigVersions = 10
+
+var (
+	// ErrConfigRejected is returned when a config check vetoes a config
+	ErrConfigRejected = errors.New("config rejected")
+	// ErrVersionNotFound is returned when rolling back to an unknown version
+	ErrVersionNotFound = errors.New("config version not found")
+)
+
+// ConfigVersion is a configuration that was applied. Versions are numbered
+// from 1 and a new one is only recorded when the content changes.
+type ConfigVersion struct {
+	Version    int       `json:"version"`
+	Hash       string    `json:"hash"`
+	AppliedAt  time.Time `json:"applied_at"`
+	Files      []string  `json:"files"`
+	RollbackOf int       `json:"rollback_of,omitempty"` // Version restored by a rollback
+	Current    bool      `json:"current"`
+
+	config *Config
+}
+
+// hashConfig returns the SHA-256 of the resolved config, so formatting and
+// comment changes do not create a new version
+func hashConfig(config *Config) (string, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordVersion adds an applied config to the history and returns its
+// version number. The caller must hold l.mu.
+func (l *Loader) recordVersion(config *Config, files []string, hash string, at time.Time, rollbackOf int) int {
+	if n := len(l.versions); n > 0 && l.versions[n-1].Hash == hash {
+		l.versions[n-1].config = config
+		return l.versions[n-1].Version
+	}
+
+	version := 1
+	if n := len(l.versions); n > 0 {
+		version = l.versions[n-1].Version + 1
+	}
+
+	l.versions = append(l.versions, ConfigVersion{
+		Version:    version,
+		Hash:       hash,
+		AppliedAt:  at,
+		Files:      files,
+		RollbackOf: rollbackOf,
+		config:     config,
+	})
+	if len(l.versions) > maxConfigVersions {
+		l.versions = l.versions[len(l.versions)-maxConfigVersions:]
+	}
+	return version
+}
+
+// Versions returns the recently applied configs, oldest first. The last one
+// is the current configuration.
+func (l *Loader) Versions() []ConfigVersion {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	versions := make([]ConfigVersion, len(l.versions))
+	copy(versions, l.versions)
+	if n := len(versions); n > 0 {
+		versions[n-1].Current = true
+	}
+	return versions
+}
+
+// Rollback makes a previously applied config current again. The config
+// checks still apply. The files on disk are not changed, so the next reload
+// from disk replaces the rolled back config.
+func (l *Loader) Rollback(version int) (ConfigVersion, error) {
+	l.applyMu.Lock()
+	defer l.applyMu.Unlock()
+
+	l.mu.RLock()
+	var target *ConfigVersion
+	for i := range l.versions {
+		if l.versions[i].Version == version {
+			v := l.versions[i]
+			target = &v
+		}
+	}
+	l.mu.RUnlock()
+
+	if target == nil {
+		return ConfigVersion{}, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+
+	l.logger.Info("Rolling back configuration", zap.Int("version", version))
+	if err := l.apply(target.config, target.Files[0], target.Files, version); err != nil {
+		return ConfigVersion{}, err
+	}
+
+	versions := l.Versions()
+	return versions[len(versions)-1], nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// versionedLoader loads a config with one payer per name in turn, each
+// becoming a new version, and returns the loader
+func versionedLoader(t *testing.T, names ...string) *Loader {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.yaml")
+	loader := NewLoader(path, zap.NewNop())
+	for _, name := range names {
+		loadPayer(t, loader, path, name)
+	}
+	return loader
+}
+
+// loadPayer rewrites the config at path with a single payer and loads it
+func loadPayer(t *testing.T, loader *Loader, path, name string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(payerFileFor(name)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// versionNumbers lists the version numbers the loader keeps
+func versionNumbers(loader *Loader) []int {
+	var numbers []int
+	for _, v := range loader.Versions() {
+		numbers = append(numbers, v.Version)
+	}
+	return numbers
+}
+
+func TestRollbackToEarlierVersion(t *testing.T) {
+	loader := versionedLoader(t, "Alpha", "Beta", "Gamma")
+	var notified []string
+	loader.OnConfigChange(func(config *Config) { notified = append(notified, payerNames(config)...) })
+	first := loader.Versions()[0]
+
+	restored, err := loader.Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 4 || restored.RollbackOf != 1 || restored.Hash != first.Hash || !restored.Current {
+		t.Errorf("Rollback(1) = %+v, want current version 4 restoring version 1 with hash %s", restored, first.Hash)
+	}
+	if got := payerNames(loader.GetConfig()); len(got) != 1 || got[0] != "Alpha" {
+		t.Errorf("payers after rollback = %v, want [Alpha]", got)
+	}
+	if fmt.Sprint(notified) != "[Alpha]" {
+		t.Errorf("change callbacks saw %v, want [Alpha]", notified)
+	}
+	if got := loader.Reloads(); got[len(got)-1].Version != 4 {
+		t.Errorf("last reload event is version %d, want 4", got[len(got)-1].Version)
+	}
+
+	// Rolling back to what is already current records nothing new
+	if restored, err := loader.Rollback(4); err != nil || restored.Version != 4 {
+		t.Errorf("Rollback(4) = %d, %v; want version 4 unchanged", restored.Version, err)
+	}
+	if got := fmt.Sprint(versionNumbers(loader)); got != "[1 2 3 4]" {
+		t.Errorf("versions = %s, want [1 2 3 4]", got)
+	}
+}
+
+func TestRollbackToEvictedVersion(t *testing.T) {
+	var names []string
+	for i := 0; i <= maxConfigVersions; i++ {
+		names = append(names, fmt.Sprint("Payer", i))
+	}
+	loader := versionedLoader(t, names...)
+	current := loader.GetConfig()
+
+	if got := versionNumbers(loader); len(got) != maxConfigVersions || got[0] != 2 {
+		t.Fatalf("versions = %v, want the last %d starting at 2", got, maxConfigVersions)
+	}
+	for _, version := range []int{1, 0, maxConfigVersions + 2} {
+		if _, err := loader.Rollback(version); !errors.Is(err, ErrVersionNotFound) {
+			t.Errorf("Rollback(%d) error = %v, want ErrVersionNotFound", version, err)
+		}
+	}
+	if loader.GetConfig() != current {
+		t.Error("a failed rollback replaced the current config")
+	}
+	if n := len(loader.Versions()); n != maxConfigVersions {
+		t.Errorf("%d versions after failed rollbacks, want %d", n, maxConfigVersions)
+	}
+}
+
+func TestRollbackRejectedByCheck(t *testing.T) {
+	loader := versionedLoader(t, "Alpha", "Beta")
+	current := loader.GetConfig()
+	reloads := len(loader.Reloads())
+	notified := 0
+	loader.OnConfigChange(func(*Config) { notified++ })
+
+	// Alpha has since been retired, so a check now refuses it
+	loader.OnConfigCheck(func(config *Config) error {
+		for _, name := range payerNames(config) {
+			if name == "Alpha" {
+				return errors.New("payer Alpha is retired")
+			}
+		}
+		return nil
+	})
+
+	if _, err := loader.Rollback(1); !errors.Is(err, ErrConfigRejected) {
+		t.Fatalf("Rollback(1) error = %v, want ErrConfigRejected", err)
+	}
+	if loader.GetConfig() != current {
+		t.Error("a rejected rollback replaced the current config")
+	}
+	if got := fmt.Sprint(versionNumbers(loader)); got != "[1 2]" {
+		t.Errorf("versions = %s, want [1 2]", got)
+	}
+	if got := loader.Versions(); !got[1].Current {
+		t.Error("version 2 is no longer current")
+	}
+	if notified != 0 || len(loader.Reloads()) != reloads {
+		t.Errorf("a rejected rollback notified %d callbacks and recorded %d reloads", notified, len(loader.Reloads())-reloads)
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// envRef matches ${VAR} references in endpoint URLs
+var envRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Warnings lists problems that do not fail validation but will likely make
+// an endpoint's probes fail or be ambiguous
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	for _, ref := range c.EndpointRefs() {
+		name := fmt.Sprintf("payer %s endpoint %s", ref.Payer, ref.Endpoint.Type)
+		rawURL := ref.Endpoint.GetURL()
+
+		unset := false
+		for _, match := range envRef.FindAllStringSubmatch(rawURL, -1) {
+			if _, ok := os.LookupEnv(match[1]); !ok {
+				warnings = append(warnings, fmt.Sprintf("%s references unset variable %s", name, match[1]))
+				unset = true
+			}
+		}
+		if unset {
+			continue
+		}
+
+		if rawURL == "" {
+			warnings = append(warnings, fmt.Sprintf("%s only has url_contains and cannot be probed", name))
+			continue
+		}
+		if u, err := url.Parse(os.ExpandEnv(rawURL)); err != nil || !u.IsAbs() {
+			warnings = append(warnings, fmt.Sprintf("%s has relative URL %q and cannot be probed", name, rawURL))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// defaultWatchDebounce is how long the watcher waits for writes to settle
+const defaultWatchDebounce = time.Second
+
+// OnReload registers a hook called with the outcome of every reload
+// triggered by SIGHUP, a file change or polling; err is nil on success
+func (l *Loader) OnReload(hook func(err error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reloadHooks = append(l.reloadHooks, hook)
+}
+
+// reload loads the configuration again and reports the outcome. A file that
+// fails to load or validate leaves the running configuration in place. An
+// unchanged source is neither logged nor reported.
+func (l *Loader) reload(trigger string) {
+	applied, err := l.load()
+	if err == nil && !applied {
+		return
+	}
+	if err != nil {
+		l.logger.Error("Failed to reload configuration, keeping the current one",
+			zap.String("trigger", trigger), zap.Error(err))
+	} else {
+		l.logger.Info("Configuration reloaded successfully", zap.String("trigger", trigger))
+	}
+
+	l.mu.RLock()
+	hooks := l.reloadHooks
+	l.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(err)
+	}
+}
+
+// WatchFiles reloads the configuration when the main file or any included
+// file changes. It watches the parent directories rather than the files so
+// editors that replace files and Kubernetes ConfigMap symlink swaps are
+// seen. Events are debounced, and a reload only happens when the content of
+// the files actually changed.
+func (l *Loader) WatchFiles(ctx context.Context, debounce time.Duration) error {
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	l.mu.RLock()
+	source, ok := l.source.(*FileSource)
+	l.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("file watching needs a local config file, not %s", l.source.Name())
+	}
+	path := source.Path
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[string]bool)
+	l.watchDirs(path, watcher, watched)
+	last := l.fingerprint(path)
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				l.logger.Debug("Config file event", zap.String("event", event.String()))
+				timer.Reset(debounce)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.logger.Warn("Config file watcher error", zap.Error(err))
+
+			case <-timer.C:
+				current := l.fingerprint(path)
+				if current == last {
+					continue
+				}
+				last = current
+				l.reload("file change")
+				l.watchDirs(path, watcher, watched)
+			}
+		}
+	}()
+
+	l.logger.Info("Watching configuration files for changes",
+		zap.Strings("files", l.Files()),
+		zap.Duration("debounce", debounce))
+	return nil
+}
+
+// watchDirs adds the directories of the current config files, and of the
+// include patterns, to the watcher
+func (l *Loader) watchDirs(path string, watcher *fsnotify.Watcher, watched map[string]bool) {
+	dirs := []string{}
+	for _, file := range l.Files() {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	if config := l.GetConfig(); config != nil {
+		base := filepath.Dir(path)
+		for _, pattern := range config.Include {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(base, pattern)
+			}
+			if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+				dirs = append(dirs, pattern)
+			} else {
+				dirs = append(dirs, filepath.Dir(pattern))
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			l.logger.Warn("Failed to watch config directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		watched[dir] = true
+	}
+}
+
+// fingerprint hashes the main file and every file its include patterns
+// currently match, so new and removed payer files are noticed too
+func (l *Loader) fingerprint(path string) string {
+	files := []string{path}
+	if config := l.GetConfig(); config != nil {
+		if included, err := resolveIncludes(filepath.Dir(path), path, config.Include); err == nil {
+			files = append(files, included...)
+		}
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		h.Write([]byte(file))
+		data, err := os.ReadFile(file)
+		if err != nil {
+			h.Write([]byte(err.Error()))
+			continue
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Poll fetches the configuration from its source every interval and
+// applies it when it changed. It is meant for remote sources, which cannot
+// be watched.
+func (l *Loader) Poll(ctx context.Context, interval time.Duration) {
+	l.logger.Info("Polling configuration source",
+		zap.String("source", l.source.Name()),
+		zap.Duration("interval", interval))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				l.reload("poll")
+			}
+		}
+	}()
+}
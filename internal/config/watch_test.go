@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// replaceFile writes data next to path and renames it over path, as
+// editors and deployment tools do
+func replaceFile(t *testing.T, path, data string) {
+	t.Helper()
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchFilesReloadsOnceWhenAnIncludedFileIsRenamedOver(t *testing.T) {
+	const debounce = 50 * time.Millisecond
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.yaml":     "include: [payers/]\n",
+		"payers/a.yaml": payerFileFor("Alpha"),
+	})
+
+	loader := NewLoader(filepath.Join(dir, "main.yaml"), zap.NewNop())
+	if err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan error, 10)
+	loader.OnReload(func(err error) { reloads <- err })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := loader.WatchFiles(ctx, debounce); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to report until a file changes; the watch itself does not reload
+	expectNoReload := func() {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			t.Fatalf("unexpected reload, error %v", err)
+		case <-time.After(10 * debounce):
+		}
+	}
+	expectNoReload()
+
+	replaceFile(t, filepath.Join(dir, "payers/a.yaml"), payerFileFor("Beta"))
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the included file was replaced")
+	}
+	expectNoReload()
+	if got := payerNames(loader.GetConfig()); len(got) != 1 || got[0] != "Beta" {
+		t.Errorf("payers = %v, want [Beta]", got)
+	}
+
+	// Replacing it with the same content changes nothing
+	replaceFile(t, filepath.Join(dir, "payers/a.yaml"), payerFileFor("Beta"))
+	expectNoReload()
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Webhook defaults
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = time.Second
+)
+
+// Webhook is an HTTP receiver that probe results and incidents are POSTed
+// to as they are broadcast
+type Webhook struct {
+	Name        string            `yaml:"name" required:"true" help:"Unique name, used in logs and metrics"`
+	URL         string            `yaml:"url" required:"true" help:"URL events are POSTed to; ${VAR} is expanded"`
+	Secret      string            `yaml:"secret,omitempty" help:"HMAC-SHA256 key for the X-Payer-Status-Signature header; ${VAR} is expanded"`
+	Events      []string          `yaml:"events,omitempty" enum:"probe_result,incident" help:"Message types to send (default: incident)"`
+	Filter      EventFilter       `yaml:"filter,omitempty" help:"Which results and incidents to send"`
+	Headers     map[string]string `yaml:"headers,omitempty" help:"Extra request headers; ${VAR} is expanded"`
+	Timeout     time.Duration     `yaml:"timeout,omitempty" help:"Timeout of each attempt (default: 10s)"`
+	MaxAttempts int               `yaml:"max_attempts,omitempty" help:"Attempts before an event goes to the dead-letter file (default: 5)"`
+	Backoff     time.Duration     `yaml:"backoff,omitempty" help:"Delay before the first retry, doubled for each later one (default: 1s)"`
+}
+
+// GetEvents returns the message types sent, defaulting to incidents
+func (w *Webhook) GetEvents() []string {
+	if len(w.Events) == 0 {
+		return []string{"incident"}
+	}
+	return w.Events
+}
+
+// GetTimeout returns the per-attempt timeout, defaulting to 10 seconds
+func (w *Webhook) GetTimeout() time.Duration {
+	if w.Timeout == 0 {
+		return defaultWebhookTimeout
+	}
+	return w.Timeout
+}
+
+// GetMaxAttempts returns the attempts per event, defaulting to 5
+func (w *Webhook) GetMaxAttempts() int {
+	if w.MaxAttempts == 0 {
+		return defaultWebhookMaxAttempts
+	}
+	return w.MaxAttempts
+}
+
+// GetBackoff returns the first retry delay, defaulting to 1 second
+func (w *Webhook) GetBackoff() time.Duration {
+	if w.Backoff == 0 {
+		return defaultWebhookBackoff
+	}
+	return w.Backoff
+}
+
+// validateWebhooks checks the webhooks against each other and the payers
+func (v *validator) validateWebhooks(webhooks []Webhook, payers []Payer, node *yaml.Node) {
+	known := make(map[string]bool, len(payers))
+	for _, payer := range payers {
+		known[payer.Name] = true
+	}
+
+	seen := make(map[string]int, len(webhooks))
+	for i, webhook := range webhooks {
+		item := sequenceItem(node, i)
+		name := fmt.Sprintf("webhook %s", webhook.Name)
+		if webhook.Name == "" {
+			name = fmt.Sprintf("webhook at index %d", i)
+			v.add(item, "%s has empty name", name)
+		} else if first, ok := seen[webhook.Name]; ok {
+			v.add(item, "%s duplicates webhook at index %d", name, first)
+		} else {
+			seen[webhook.Name] = i
+		}
+
+		if webhook.URL == "" {
+			v.add(item, "%s has no url", name)
+		} else if err := checkURL(webhook.URL); err != nil {
+			v.add(fieldNode(item, "url"), "%s: %v", name, err)
+		}
+
+		for _, event := range webhook.Events {
+			if event != "probe_result" && event != "incident" {
+				v.add(fieldNode(item, "events"), "%s: unknown event %q, use probe_result or incident", name, event)
+			}
+		}
+
+		filterNode := fieldNode(item, "filter")
+		if err := webhook.Filter.Validate(); err != nil {
+			v.add(filterNode, "%s: %v", name, err)
+		} else if unknown := webhook.Filter.UnknownPayers(known); len(unknown) > 0 {
+			v.add(filterNode, "%s: filter names unknown payers %v", name, unknown)
+		}
+		_, filterValue := mappingValue(item, "filter")
+		v.checkLabels(filterValue, name+" filter", webhook.Filter.Labels, nil)
+
+		switch {
+		case webhook.Timeout < 0:
+			v.add(fieldNode(item, "timeout"), "%s has a negative timeout", name)
+		case webhook.MaxAttempts < 0:
+			v.add(fieldNode(item, "max_attempts"), "%s has negative max_attempts", name)
+		case webhook.Backoff < 0:
+			v.add(fieldNode(item, "backoff"), "%s has a negative backoff", name)
+		}
+	}
+}
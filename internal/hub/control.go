@@ -0,0 +1,181 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// Actions a client message may carry
+const (
+	ActionSubscribe   = "subscribe"   // Replace the filter
+	ActionUnsubscribe = "unsubscribe" // Stop receiving results
+	ActionAdd         = "add"         // Widen the filter's payers and types, add label constraints
+	ActionRemove      = "remove"      // Drop payers, types and label constraints from the filter
+	ActionProbe       = "probe"       // Run an on-demand probe
+)
+
+// Error codes sent to clients
+const (
+	ErrorInvalidMessage = "invalid_message"
+	ErrorUnknownAction  = "unknown_action"
+	ErrorInvalidFilter  = "invalid_filter"
+	ErrorUnknownPayer   = "unknown_payer"
+	ErrorForbidden      = "forbidden"
+	ErrorProbeFailed    = "probe_failed"
+	ErrorThrottled      = "throttled"
+	ErrorUnavailable    = "unavailable"
+)
+
+// controlHeader holds the fields every client message shares
+type controlHeader struct {
+	Action string `json:"action"`
+	ID     string `json:"id"` // Echoed in the reply so clients can match it up
+}
+
+// subscription describes the filter for a reply; nil means unsubscribed
+func (f *Filter) subscription(header controlHeader) Subscription {
+	sub := Subscription{Action: header.Action, ID: header.ID}
+	if f != nil {
+		sub.EventFilter = f.spec
+	}
+	return sub
+}
+
+// handleMessage decodes a client message and carries out its action.
+// Malformed and failed requests get an error reply.
+func (c *Client) handleMessage(data []byte) {
+	var header controlHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		c.replyError(header, ErrorInvalidMessage, "message must be a JSON object with a string action")
+		return
+	}
+
+	switch header.Action {
+	case ActionSubscribe, ActionAdd, ActionRemove, ActionUnsubscribe:
+		var req SubscriptionRequest
+		if err := decodeStrict(data, &req); err != nil {
+			c.replyError(header, ErrorInvalidMessage, err.Error())
+			return
+		}
+		c.handleSubscriptionUpdate(header, req)
+
+	case ActionProbe:
+		var req ProbeRequest
+		if err := decodeStrict(data, &req); err != nil {
+			c.replyError(header, ErrorInvalidMessage, err.Error())
+			return
+		}
+		c.handleProbeRequest(header, req)
+
+	default:
+		c.replyError(header, ErrorUnknownAction, fmt.Sprintf("unknown action %q", header.Action))
+	}
+}
+
+// handleSubscriptionUpdate changes the client's filter and acknowledges it
+// with the filter now in effect and a fresh snapshot
+func (c *Client) handleSubscriptionUpdate(header controlHeader, req SubscriptionRequest) {
+	if header.Action != ActionRemove && header.Action != ActionUnsubscribe {
+		if code, err := c.checkPayers(req.EventFilter); err != nil {
+			c.replyError(header, code, err.Error())
+			return
+		}
+	}
+
+	// Build the new filter from the current one under the lock, so that
+	// the broadcaster sees either the old filter or the new one
+	c.mu.Lock()
+	var filter *Filter
+	var err error
+	switch {
+	case header.Action == ActionUnsubscribe:
+	case header.Action == ActionSubscribe, c.filter == nil && header.Action == ActionAdd:
+		filter, err = NewFilter(req.EventFilter)
+	case c.filter == nil:
+		// Nothing to remove from
+	case header.Action == ActionAdd:
+		filter, err = c.filter.with(req.EventFilter)
+	case header.Action == ActionRemove:
+		filter, err = c.filter.without(req.EventFilter)
+	}
+	if err == nil {
+		c.filter = filter
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.replyError(header, ErrorInvalidFilter, err.Error())
+		return
+	}
+
+	sub := filter.subscription(header)
+	c.logger.Info("Client subscription updated",
+		zap.String("client_id", c.id),
+		zap.String("action", header.Action),
+		zap.Bool("subscribed", filter != nil),
+		zap.Any("filter", sub.EventFilter))
+
+	if filter == nil {
+		c.reply(c.hub.newEnvelope(MessageUnsubscribed, sub))
+		return
+	}
+	c.reply(c.hub.newEnvelope(MessageSubscribed, sub))
+	c.sendSnapshot()
+}
+
+// checkPayers rejects payers that are not in the current config or that
+// the client's credentials do not cover, and patterns that match no payer
+func (c *Client) checkPayers(spec config.EventFilter) (string, error) {
+	c.hub.mu.RLock()
+	known := c.hub.payers
+	c.hub.mu.RUnlock()
+
+	if err := spec.Validate(); err != nil {
+		return ErrorInvalidFilter, err
+	}
+	if known != nil {
+		if unknown := spec.UnknownPayers(known); len(unknown) > 0 {
+			return ErrorUnknownPayer, fmt.Errorf("unknown payers: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	var forbidden []string
+	for _, payer := range spec.Payers {
+		if !c.principal.AllowsPayer(payer) {
+			forbidden = append(forbidden, payer)
+		}
+	}
+	if len(forbidden) > 0 {
+		return ErrorForbidden, fmt.Errorf("not allowed to see payers: %s", strings.Join(forbidden, ", "))
+	}
+	return "", nil
+}
+
+// matches reports whether the client is subscribed to a result it may
+// see. changed tells whether the result changed its endpoint's health.
+func (c *Client) matches(result *config.ProbeResult, changed bool) bool {
+	if !c.principal.AllowsPayer(result.Payer) {
+		return false
+	}
+	c.mu.RLock()
+	filter := c.filter
+	c.mu.RUnlock()
+	return filter != nil && filter.Matches(result, changed)
+}
+
+// decodeStrict decodes a client message, rejecting fields the action does
+// not take
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	return nil
+}
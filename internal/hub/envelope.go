@@ -0,0 +1,190 @@
+package hub
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+// WebSocket subprotocols. Version 1, also used when the client asks for
+// none, sends bare probe results and replies; version 2 wraps every message
+// in an Envelope. SSE clients get the same envelopes as version 2.
+const (
+	ProtocolV1  = "payer-status-v1"
+	ProtocolV2  = "payer-status-v2"
+	ProtocolSSE = "sse"
+)
+
+// Message types of the v2 protocol
+const (
+	MessageProbeResult   = "probe_result"   // Data is a config.ProbeResult
+	MessageSnapshot      = "snapshot"       // Data is the latest result of every visible endpoint
+	MessageSubscribed    = "subscribed"     // Data is the subscription now in effect
+	MessageUnsubscribed  = "unsubscribed"   // Data echoes the request's action and ID
+	MessageProbeAccepted = "probe_accepted" // Data is a ProbeAccepted
+	MessageError         = "error"          // Data is an ErrorData
+	MessageIncident      = "incident"       // Data is an Incident
+	MessageHeartbeat     = "heartbeat"      // No data
+	MessageGap           = "gap"            // Data is a Gap
+)
+
+// heartbeatPeriod is how often v2 clients get a heartbeat message
+const heartbeatPeriod = 30 * time.Second
+
+// Envelope wraps every server message of the v2 protocol. Seq increases
+// across the whole hub, so a client sees gaps for messages meant for others.
+type Envelope struct {
+	Type string      `json:"type"`
+	Seq  uint64      `json:"seq"`
+	TS   time.Time   `json:"ts"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// ErrorData describes a client request the server could not carry out
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Action  string `json:"action,omitempty"` // The request's action, if known
+	ID      string `json:"id,omitempty"`     // The request's ID, if it had one
+}
+
+// Subscription is the filter a client's stream is using, sent back in
+// reply to a subscription change
+type Subscription struct {
+	Action string `json:"action"`
+	ID     string `json:"id,omitempty"`
+	config.EventFilter
+}
+
+// ProbeAccepted acknowledges a probe request; results carry CorrelationID
+type ProbeAccepted struct {
+	Action        string `json:"action"`
+	ID            string `json:"id,omitempty"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// Incident reports an endpoint turning unhealthy (state "open") or healthy
+// again ("resolved"). Since is when it turned unhealthy.
+type Incident struct {
+	Payer  string              `json:"payer"`
+	Type   string              `json:"type"`
+	URL    string              `json:"url"`
+	State  string              `json:"state"`
+	Since  time.Time           `json:"since"`
+	Result *config.ProbeResult `json:"result"`
+}
+
+// newEnvelope wraps data with the next sequence number
+func (h *Hub) newEnvelope(msgType string, data interface{}) *Envelope {
+	return &Envelope{
+		Type: msgType,
+		Seq:  h.seq.Add(1),
+		TS:   time.Now().UTC(),
+		Data: data,
+	}
+}
+
+// endpointState is the latest result of an endpoint and, while it is
+// unhealthy, when that started
+type endpointState struct {
+	result         *config.ProbeResult
+	unhealthySince time.Time
+}
+
+// latestResults tracks the last result of every endpoint for snapshots and
+// incident detection
+type latestResults struct {
+	mu        sync.RWMutex
+	endpoints map[string]*endpointState
+}
+
+// record stores a result and returns the incident it opens or resolves, if
+// any. Pause notices carry no probe and are ignored.
+func (l *latestResults) record(result *config.ProbeResult) *Incident {
+	if result.Paused {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.endpoints == nil {
+		l.endpoints = make(map[string]*endpointState)
+	}
+	key := endpointKey(result)
+	state, seen := l.endpoints[key]
+	if !seen {
+		state = &endpointState{}
+		l.endpoints[key] = state
+	}
+	wasHealthy := !seen || state.unhealthySince.IsZero()
+	state.result = result
+
+	var incident *Incident
+	switch healthy := result.Healthy(); {
+	case !healthy && wasHealthy:
+		state.unhealthySince = result.Timestamp
+		incident = newIncident(result, "open", state.unhealthySince)
+	case healthy && !wasHealthy:
+		incident = newIncident(result, "resolved", state.unhealthySince)
+		state.unhealthySince = time.Time{}
+	}
+	return incident
+}
+
+// stale reports whether the endpoint's latest result is this one or a
+// later one
+func (l *latestResults) stale(result *config.ProbeResult) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	state, ok := l.endpoints[endpointKey(result)]
+	return ok && !state.result.Timestamp.Before(result.Timestamp)
+}
+
+func endpointKey(result *config.ProbeResult) string {
+	return result.Payer + ":" + result.Type + ":" + result.URL
+}
+
+func newIncident(result *config.ProbeResult, state string, since time.Time) *Incident {
+	return &Incident{
+		Payer:  result.Payer,
+		Type:   result.Type,
+		URL:    result.URL,
+		State:  state,
+		Since:  since,
+		Result: result,
+	}
+}
+
+// Latest returns the last result of every endpoint, ordered by payer, type
+// and URL
+func (h *Hub) Latest() []*config.ProbeResult {
+	return h.latest.snapshot(func(*config.ProbeResult) bool { return true })
+}
+
+// snapshot returns the latest results that match, ordered by payer, type
+// and URL
+func (l *latestResults) snapshot(match func(*config.ProbeResult) bool) []*config.ProbeResult {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	results := make([]*config.ProbeResult, 0, len(l.endpoints))
+	for _, state := range l.endpoints {
+		if match(state.result) {
+			results = append(results, state.result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Payer != b.Payer {
+			return a.Payer < b.Payer
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.URL < b.URL
+	})
+	return results
+}
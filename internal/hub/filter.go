@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"sort"
+
+	"payer-status-io/internal/config"
+)
+
+// Filter is a validated EventFilter, ready to match results
+type Filter struct {
+	spec          config.EventFilter
+	payerMatchers []func(string) bool // One per PayerPatterns entry
+}
+
+// NewFilter validates a spec and compiles its payer patterns
+func NewFilter(spec config.EventFilter) (*Filter, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	filter := &Filter{spec: spec}
+	for _, pattern := range spec.PayerPatterns {
+		matcher, err := config.CompilePayerPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.payerMatchers = append(filter.payerMatchers, matcher)
+	}
+	return filter, nil
+}
+
+// Matches reports whether a result passes the filter. changed tells
+// whether the result turned its endpoint healthy or unhealthy.
+func (f *Filter) Matches(result *config.ProbeResult, changed bool) bool {
+	if !f.matchesPayer(result.Payer) {
+		return false
+	}
+	if len(f.spec.Types) > 0 && !contains(f.spec.Types, result.Type) {
+		return false
+	}
+	for key, value := range f.spec.Labels {
+		if v, ok := result.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	// The remaining checks are about probe outcomes, which pause notices
+	// do not have
+	if !f.filtersOutcomes() {
+		return true
+	}
+	if result.Paused {
+		return false
+	}
+	if f.spec.Status != "" && result.Healthy() != (f.spec.Status == config.StatusHealthy) {
+		return false
+	}
+	if result.LatencyMS < f.spec.MinLatencyMS {
+		return false
+	}
+	if f.spec.MaxLatencyMS > 0 && result.LatencyMS > f.spec.MaxLatencyMS {
+		return false
+	}
+	return changed || !f.spec.Transitions
+}
+
+func (f *Filter) listsPayers() bool {
+	return len(f.spec.Payers) > 0 || len(f.spec.PayerPatterns) > 0
+}
+
+func (f *Filter) matchesPayer(payer string) bool {
+	if !f.listsPayers() || contains(f.spec.Payers, payer) {
+		return true
+	}
+	for _, match := range f.payerMatchers {
+		if match(payer) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) filtersOutcomes() bool {
+	return f.spec.Status != "" || f.spec.MinLatencyMS > 0 || f.spec.MaxLatencyMS > 0 || f.spec.Transitions
+}
+
+// with returns the filter widened by the spec's payers and types and
+// narrowed by its other fields. A list that already matches everything
+// stays so.
+func (f *Filter) with(spec config.EventFilter) (*Filter, error) {
+	next := f.spec
+	if f.listsPayers() {
+		next.Payers = union(f.spec.Payers, spec.Payers)
+		next.PayerPatterns = union(f.spec.PayerPatterns, spec.PayerPatterns)
+	}
+	if len(f.spec.Types) > 0 {
+		next.Types = union(f.spec.Types, spec.Types)
+	}
+	next.Labels = make(map[string]string, len(f.spec.Labels)+len(spec.Labels))
+	for key, value := range f.spec.Labels {
+		next.Labels[key] = value
+	}
+	for key, value := range spec.Labels {
+		next.Labels[key] = value
+	}
+	if spec.Status != "" {
+		next.Status = spec.Status
+	}
+	if spec.MinLatencyMS > 0 {
+		next.MinLatencyMS = spec.MinLatencyMS
+	}
+	if spec.MaxLatencyMS > 0 {
+		next.MaxLatencyMS = spec.MaxLatencyMS
+	}
+	next.Transitions = next.Transitions || spec.Transitions
+	return NewFilter(next)
+}
+
+// without returns the filter minus the spec's payers, types and label
+// keys, dropping the other constraints the spec sets. Removing the last
+// payer or type leaves nothing to match, which is reported as nil rather
+// than widening to everything.
+func (f *Filter) without(spec config.EventFilter) (*Filter, error) {
+	next := f.spec
+	next.Payers = difference(f.spec.Payers, spec.Payers)
+	next.PayerPatterns = difference(f.spec.PayerPatterns, spec.PayerPatterns)
+	next.Types = difference(f.spec.Types, spec.Types)
+	if f.listsPayers() && len(next.Payers) == 0 && len(next.PayerPatterns) == 0 {
+		return nil, nil
+	}
+	if len(f.spec.Types) > 0 && len(next.Types) == 0 {
+		return nil, nil
+	}
+	next.Labels = make(map[string]string, len(f.spec.Labels))
+	for key, value := range f.spec.Labels {
+		if _, ok := spec.Labels[key]; !ok {
+			next.Labels[key] = value
+		}
+	}
+	if spec.Status != "" {
+		next.Status = ""
+	}
+	if spec.MinLatencyMS > 0 {
+		next.MinLatencyMS = 0
+	}
+	if spec.MaxLatencyMS > 0 {
+		next.MaxLatencyMS = 0
+	}
+	if spec.Transitions {
+		next.Transitions = false
+	}
+	return NewFilter(next)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// union returns the sorted values found in either list
+func union(a, b []string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, v := range append(append([]string{}, a...), b...) {
+		set[v] = true
+	}
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// difference returns the values of a not found in b
+func difference(a, b []string) []string {
+	var values []string
+	for _, v := range a {
+		if !contains(b, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
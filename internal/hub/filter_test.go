@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+func TestFilterMatches(t *testing.T) {
+	healthy := &config.ProbeResult{Payer: "Delta Dental of Iowa", Type: "login", StatusCode: 200, LatencyMS: 800,
+		Labels: map[string]string{"region": "Iowa", "tier": "gold"}}
+	slow := &config.ProbeResult{Payer: "Aetna", Type: "claims", StatusCode: 200, LatencyMS: 2500}
+	failed := &config.ProbeResult{Payer: "Cigna", Type: "login", Err: "timeout", LatencyMS: 10000}
+	paused := &config.ProbeResult{Payer: "Aetna", Type: "login", Paused: true}
+
+	tests := []struct {
+		name    string
+		spec    config.EventFilter
+		result  *config.ProbeResult
+		changed bool
+		want    bool
+	}{
+		{"empty matches everything", config.EventFilter{}, healthy, false, true},
+		{"empty matches pause notices", config.EventFilter{}, paused, false, true},
+
+		{"payer listed", config.EventFilter{Payers: []string{"Aetna", "Cigna"}}, failed, false, true},
+		{"payer not listed", config.EventFilter{Payers: []string{"Aetna"}}, failed, false, false},
+		{"glob", config.EventFilter{PayerPatterns: []string{"Delta Dental*"}}, healthy, false, true},
+		{"glob is anchored", config.EventFilter{PayerPatterns: []string{"Dental*"}}, healthy, false, false},
+		{"glob character class", config.EventFilter{PayerPatterns: []string{"[AC]*"}}, slow, false, true},
+		{"regex", config.EventFilter{PayerPatterns: []string{"/^(Aetna|Cigna)$/"}}, failed, false, true},
+		{"regex is unanchored", config.EventFilter{PayerPatterns: []string{"/Iowa/"}}, healthy, false, true},
+		{"regex misses", config.EventFilter{PayerPatterns: []string{"/^(Aetna|Cigna)$/"}}, healthy, false, false},
+		{"payer or pattern", config.EventFilter{Payers: []string{"Cigna"}, PayerPatterns: []string{"Aet*"}}, slow, false, true},
+
+		{"type listed", config.EventFilter{Types: []string{"login"}}, failed, false, true},
+		{"type not listed", config.EventFilter{Types: []string{"login"}}, slow, false, false},
+
+		{"labels all match", config.EventFilter{Labels: map[string]string{"region": "Iowa", "tier": "gold"}}, healthy, false, true},
+		{"label value differs", config.EventFilter{Labels: map[string]string{"region": "Ohio"}}, healthy, false, false},
+		{"label missing", config.EventFilter{Labels: map[string]string{"region": "Iowa"}}, slow, false, false},
+		{"empty label value needs the key", config.EventFilter{Labels: map[string]string{"region": ""}}, slow, false, false},
+
+		{"unhealthy only", config.EventFilter{Status: config.StatusUnhealthy}, failed, false, true},
+		{"unhealthy only drops healthy", config.EventFilter{Status: config.StatusUnhealthy}, healthy, false, false},
+		{"healthy only", config.EventFilter{Status: config.StatusHealthy}, healthy, false, true},
+		{"healthy only drops failures", config.EventFilter{Status: config.StatusHealthy}, failed, false, false},
+		{"status drops pause notices", config.EventFilter{Status: config.StatusHealthy}, paused, false, false},
+
+		{"at min latency", config.EventFilter{MinLatencyMS: 2500}, slow, false, true},
+		{"below min latency", config.EventFilter{MinLatencyMS: 2501}, slow, false, false},
+		{"at max latency", config.EventFilter{MaxLatencyMS: 800}, healthy, false, true},
+		{"above max latency", config.EventFilter{MaxLatencyMS: 799}, healthy, false, false},
+		{"within bounds", config.EventFilter{MinLatencyMS: 1000, MaxLatencyMS: 3000}, slow, false, true},
+		{"latency drops pause notices", config.EventFilter{MinLatencyMS: 1}, paused, false, false},
+
+		{"transition", config.EventFilter{Transitions: true}, failed, true, true},
+		{"repeated state", config.EventFilter{Transitions: true}, failed, false, false},
+		{"transitions drop pause notices", config.EventFilter{Transitions: true}, paused, true, false},
+
+		{"every field", config.EventFilter{Payers: []string{"Cigna"}, Types: []string{"login"}, Status: config.StatusUnhealthy, MinLatencyMS: 5000, Transitions: true}, failed, true, true},
+	}
+	for _, tt := range tests {
+		filter, err := NewFilter(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: NewFilter error: %v", tt.name, err)
+		}
+		if got := filter.Matches(tt.result, tt.changed); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewFilterRejectsInvalidSpecs(t *testing.T) {
+	tests := []struct {
+		name string
+		spec config.EventFilter
+	}{
+		{"invalid regex", config.EventFilter{PayerPatterns: []string{"/(Aetna/"}}},
+		{"invalid glob", config.EventFilter{PayerPatterns: []string{"Aetna["}}},
+		{"one invalid pattern among valid ones", config.EventFilter{PayerPatterns: []string{"Aet*", "/[/"}}},
+		{"unknown status", config.EventFilter{Status: "degraded"}},
+		{"negative min latency", config.EventFilter{MinLatencyMS: -1}},
+		{"negative max latency", config.EventFilter{MaxLatencyMS: -1}},
+		{"max below min", config.EventFilter{MinLatencyMS: 2000, MaxLatencyMS: 1000}},
+	}
+	for _, tt := range tests {
+		if _, err := NewFilter(tt.spec); err == nil {
+			t.Errorf("%s: NewFilter accepted %+v", tt.name, tt.spec)
+		}
+	}
+}
+
+func TestInvalidFilterIsRejectedOverWebSocket(t *testing.T) {
+	_, url := startHub(t)
+	conn := dial(t, url, ProtocolV1)
+
+	reply := exchange(t, conn, `{"action": "subscribe", "id": "s1", "payer_patterns": ["/(Acme/"]}`)
+	if got, want := fields(t, reply, "action", "id", "code"), `{"action":"subscribe","code":"invalid_filter","id":"s1"}`; got != want {
+		t.Errorf("reply = %s, want %s", got, want)
+	}
+}
+
+func TestTransitionsFilterSuppressesRepeatedStates(t *testing.T) {
+	h, url := startHub(t)
+	conn := dial(t, url, ProtocolV1)
+	exchange(t, conn, `{"action": "subscribe", "transitions": true}`)
+
+	start := time.Now()
+	for i, status := range []int{200, 200, 500, 500, 503, 200, 200, 500} {
+		h.Broadcast(&config.ProbeResult{Payer: "Acme", Type: "login", URL: "https://acme.example.com",
+			StatusCode: status, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	// The first result of an endpoint is not a transition unless it fails
+	for _, want := range []float64{500, 200, 500} {
+		if got := exchange(t, conn, ""); got["status_code"] != want {
+			t.Errorf("status = %v, want %v", got["status_code"], want)
+		}
+	}
+}
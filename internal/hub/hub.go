@@ -2,42 +2,105 @@ package hub
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
 
+	"payer-status-io/internal/auth"
 	"payer-status-io/internal/config"
 )
 
-// Client represents a WebSocket client connection
+// Client represents a WebSocket or SSE client connection. SSE clients
+// have no conn; HandleEvents writes their messages.
 type Client struct {
 	id        string
 	conn      *websocket.Conn
-	send      chan *config.ProbeResult
-	predicate func(*config.ProbeResult) bool
+	send      chan *Envelope
+	protocol  string          // ProtocolV1, ProtocolV2 or ProtocolSSE
+	principal *auth.Principal // Who the client authenticated as
 	logger    *zap.Logger
 	hub       *Hub
+
+	// resumeFrom is the last seq a reconnecting client saw, if it said
+	resumeFrom *uint64
+
+	// filter is replaced by the read pump while the hub reads it; nil
+	// means unsubscribed
+	mu     sync.RWMutex
+	filter *Filter
 }
 
 // Hub manages WebSocket connections and broadcasts
 type Hub struct {
 	clients    map[*Client]bool
 	broadcast  chan *config.ProbeResult
+	relayed    chan *config.ProbeResult // Results probed by another instance
 	register   chan *Client
 	unregister chan *Client
+	done       chan struct{} // Closed when Run stops reading register and unregister
 	mu         sync.RWMutex
 	logger     *zap.Logger
+
+	// trigger runs an on-demand probe requested over the WebSocket
+	trigger TriggerFunc
+
+	// payers in the current config, which subscriptions may name; nil
+	// until SetPayers is called
+	payers map[string]bool
+
+	// Upgrade checks: client credentials and allowed browser origins
+	auth           *auth.Authenticator
+	originPatterns []string
+
+	// seq numbers v2 messages; latest feeds snapshots and incidents
+	seq    atomic.Uint64
+	latest latestResults
+
+	// replay keeps recent broadcasts for clients that resume
+	replay *replayBuffer
+
+	// sinks get every broadcast message, e.g. for webhooks
+	sinks []Sink
+}
+
+// Sink receives every broadcast message along with the result it came
+// from; changed tells whether the result changed its endpoint's health.
+// Publish runs on the hub's goroutine and must not block.
+type Sink interface {
+	Publish(message *Envelope, result *config.ProbeResult, changed bool)
 }
 
-// SubscriptionRequest represents a client subscription filter
+// ProbeRequest asks for an immediate probe of an endpoint, payer or type.
+// Results are broadcast with the request's correlation ID.
+type ProbeRequest struct {
+	Action        string            `json:"action"` // "probe"
+	Payer         string            `json:"payer,omitempty"`
+	Type          string            `json:"type,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	ID            string            `json:"id,omitempty"` // Echoed in the reply
+}
+
+// TriggerFunc dispatches a probe request and returns its correlation ID
+type TriggerFunc func(req ProbeRequest) (string, error)
+
+// ErrThrottled is returned, wrapped, by a TriggerFunc that probed nothing
+// because every selected endpoint ran too recently
+var ErrThrottled = errors.New("every selected endpoint ran too recently")
+
+// SubscriptionRequest changes a client's subscription filter
 type SubscriptionRequest struct {
-	Action string   `json:"action"` // "subscribe"
-	Payers []string `json:"payer,omitempty"`
-	Types  []string `json:"type,omitempty"`
+	Action string `json:"action"`       // subscribe, unsubscribe, add or remove
+	ID     string `json:"id,omitempty"` // Echoed in the reply
+	config.EventFilter
 }
 
 // New creates a new WebSocket hub
@@ -45,9 +108,14 @@ func New(logger *zap.Logger) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan *config.ProbeResult, 1000), // Buffered for back-pressure
+		relayed:    make(chan *config.ProbeResult, 1000),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		done:       make(chan struct{}),
 		logger:     logger,
+
+		originPatterns: []string{"*"},
+		replay:         newReplayBuffer(DefaultReplaySize),
 	}
 }
 
@@ -59,6 +127,7 @@ func (h *Hub) Run(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			h.logger.Info("WebSocket hub stopping due to context cancellation")
+			close(h.done)
 			h.closeAllClients()
 			return ctx.Err()
 
@@ -66,7 +135,12 @@ func (h *Hub) Run(ctx context.Context) error {
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
-			h.logger.Info("Client registered", zap.String("client_id", client.id))
+			h.logger.Info("Client registered",
+				zap.String("client_id", client.id),
+				zap.String("protocol", client.protocol))
+
+			// Start v2 clients off with what they missed or the current state
+			client.catchUp()
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -78,7 +152,14 @@ func (h *Hub) Run(ctx context.Context) error {
 			h.logger.Info("Client unregistered", zap.String("client_id", client.id))
 
 		case result := <-h.broadcast:
-			h.broadcastToClients(result)
+			h.broadcastToClients(result, true)
+
+		case result := <-h.relayed:
+			// The leader resends its latest results whenever this follower
+			// reconnects; clients already have those
+			if !h.latest.stale(result) {
+				h.broadcastToClients(result, false)
+			}
 		}
 	}
 }
@@ -96,12 +177,103 @@ func (h *Hub) Broadcast(result *config.ProbeResult) {
 	}
 }
 
+// Relay broadcasts a result probed by another instance of the cluster. It
+// reaches this hub's clients but not its sinks, which the instance that
+// probed it fed already. Results no newer than the endpoint's latest are
+// dropped.
+func (h *Hub) Relay(result *config.ProbeResult) {
+	select {
+	case h.relayed <- result:
+	default:
+		h.logger.Warn("Relay channel full, dropping message",
+			zap.String("payer", result.Payer),
+			zap.String("type", result.Type))
+	}
+}
+
+// SetTrigger installs the handler for "probe" actions from clients
+func (h *Hub) SetTrigger(trigger TriggerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trigger = trigger
+}
+
+// SetAuthenticator requires clients to authenticate during the upgrade. A
+// disabled or nil authenticator lets everyone in.
+func (h *Hub) SetAuthenticator(authenticator *auth.Authenticator) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.auth = authenticator
+}
+
+// Authenticator returns the authenticator clients are checked with, for
+// other routes that accept the same credentials
+func (h *Hub) Authenticator() *auth.Authenticator {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.auth
+}
+
+// SetOriginPatterns sets the host patterns of browser origins that may
+// connect, e.g. "dashboard.example.com" or "*.example.com"
+func (h *Hub) SetOriginPatterns(patterns []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.originPatterns = patterns
+}
+
+// AddSink registers a receiver of every broadcast message. Call it before
+// Run.
+func (h *Hub) AddSink(sink Sink) {
+	h.sinks = append(h.sinks, sink)
+}
+
+// SetReplaySize sets how many broadcast messages are kept for clients that
+// resume. Call it before Run.
+func (h *Hub) SetReplaySize(size int) {
+	h.replay = newReplayBuffer(size)
+}
+
+// SetPayers sets the payers subscriptions may name. Call it again when the
+// config changes.
+func (h *Hub) SetPayers(payers []config.Payer) {
+	names := make(map[string]bool, len(payers))
+	for _, payer := range payers {
+		names[payer.Name] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.payers = names
+}
+
 // HandleWebSocket handles new WebSocket connections
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	authenticator, originPatterns := h.auth, h.originPatterns
+	h.mu.RUnlock()
+
+	resumeFrom, err := parseResumeFrom(r.URL.Query().Get("resume_from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check credentials before upgrading so failures get a plain HTTP 401
+	principal, err := authenticator.Authenticate(r)
+	if err != nil {
+		h.logger.Warn("WebSocket authentication failed",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.Error(err))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="payer-status"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	// Accept WebSocket connection with security options
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"}, // Configure based on your CORS requirements
-		Subprotocols:   []string{"payer-status-v1"},
+		OriginPatterns: originPatterns,
+		Subprotocols:   []string{ProtocolV2, ProtocolV1}, // Preferred first
 	})
 	if err != nil {
 		h.logger.Error("Failed to accept WebSocket connection", zap.Error(err))
@@ -114,29 +286,70 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	client := &Client{
 		id:        generateClientID(),
 		conn:      conn,
-		send:      make(chan *config.ProbeResult, 256),
-		predicate: func(*config.ProbeResult) bool { return true }, // Accept all by default
+		send:      make(chan *Envelope, 256),
+		protocol:  ProtocolV1,
+		principal: principal,
 		logger:    h.logger,
 		hub:       h,
+
+		resumeFrom: resumeFrom,
+	}
+	if strings.EqualFold(conn.Subprotocol(), ProtocolV2) {
+		client.protocol = ProtocolV2
+	}
+	client.filter = &Filter{} // Accept all allowed payers by default
+	if principal.Subject != "" {
+		h.logger.Info("Client authenticated",
+			zap.String("client_id", client.id),
+			zap.String("subject", principal.Subject),
+			zap.Strings("payers", principal.Payers))
 	}
 
 	// Register client
-	h.register <- client
+	if !client.join(r.Context()) {
+		conn.Close(websocket.StatusGoingAway, "Server shutting down")
+		return
+	}
 
 	// Start client goroutines
 	go client.writePump(context.Background())
 	go client.readPump(context.Background())
 }
 
-// broadcastToClients sends a result to all matching clients
-func (h *Hub) broadcastToClients(result *config.ProbeResult) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// broadcastToClients sends a result to all matching clients, followed for
+// v2 clients by the incident it opens or resolves. publish tells whether
+// the sinks get the messages too.
+func (h *Hub) broadcastToClients(result *config.ProbeResult, publish bool) {
+	messages := []*Envelope{h.newEnvelope(MessageProbeResult, result)}
+	incident := h.latest.record(result)
+	if incident != nil {
+		messages = append(messages, h.newEnvelope(MessageIncident, incident))
+	}
+	for _, message := range messages {
+		h.replay.add(replayEntry{message: message, result: result, changed: incident != nil})
+		if !publish {
+			continue
+		}
+		for _, sink := range h.sinks {
+			sink.Publish(message, result, incident != nil)
+		}
+	}
+
+	// Full clients are removed from the map, so take the write lock
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
+clients:
 	for client := range h.clients {
-		if client.predicate(result) {
+		if !client.matches(result, incident != nil) {
+			continue
+		}
+		for _, message := range messages {
+			if !client.accepts(message) {
+				continue
+			}
 			select {
-			case client.send <- result:
+			case client.send <- message:
 				// Successfully sent
 			default:
 				// Client's send channel is full, close it (back-pressure handling)
@@ -144,11 +357,98 @@ func (h *Hub) broadcastToClients(result *config.ProbeResult) {
 					zap.String("client_id", client.id))
 				close(client.send)
 				delete(h.clients, client)
+				continue clients
 			}
 		}
 	}
 }
 
+// join registers the client with the hub. It gives up, returning false,
+// once the hub has stopped or ctx is done.
+func (c *Client) join(ctx context.Context) bool {
+	select {
+	case c.hub.register <- c:
+		return true
+	case <-c.hub.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// leave unregisters the client, unless the hub has stopped and already let
+// every client go
+func (c *Client) leave() {
+	select {
+	case c.hub.unregister <- c:
+	case <-c.hub.done:
+	}
+}
+
+// accepts reports whether the client's protocol carries the message; v1
+// clients only get probe results and the replies to their own requests
+func (c *Client) accepts(message *Envelope) bool {
+	if c.enveloped() {
+		return true
+	}
+	switch message.Type {
+	case MessageProbeResult, MessageSubscribed, MessageUnsubscribed, MessageProbeAccepted, MessageError:
+		return true
+	default:
+		return false
+	}
+}
+
+// enveloped reports whether the client gets every message type in
+// envelopes, as v2 and SSE clients do
+func (c *Client) enveloped() bool {
+	return c.protocol != ProtocolV1
+}
+
+// reply sends a message to this client alone, dropping it if the client is
+// gone, backed up, or speaks a protocol without that message type
+func (c *Client) reply(message *Envelope) {
+	if !c.accepts(message) {
+		return
+	}
+
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if _, ok := c.hub.clients[c]; !ok {
+		return
+	}
+	select {
+	case c.send <- message:
+	default:
+		c.logger.Warn("Client send channel full, dropping reply",
+			zap.String("client_id", c.id),
+			zap.String("type", message.Type))
+	}
+}
+
+// replyError tells the client why its request failed
+func (c *Client) replyError(header controlHeader, code, message string) {
+	c.reply(c.hub.newEnvelope(MessageError, ErrorData{
+		Code:    code,
+		Message: message,
+		Action:  header.Action,
+		ID:      header.ID,
+	}))
+}
+
+// sendSnapshot sends a v2 client the latest result of every endpoint its
+// subscription and credentials let it see. A snapshot is state rather than
+// change, so a transitions-only filter does not empty it.
+func (c *Client) sendSnapshot() {
+	if !c.enveloped() {
+		return
+	}
+	results := c.hub.latest.snapshot(func(result *config.ProbeResult) bool {
+		return c.matches(result, true)
+	})
+	c.reply(c.hub.newEnvelope(MessageSnapshot, results))
+}
+
 // closeAllClients closes all client connections
 func (h *Hub) closeAllClients() {
 	h.mu.Lock()
@@ -156,7 +456,9 @@ func (h *Hub) closeAllClients() {
 
 	for client := range h.clients {
 		close(client.send)
-		client.conn.Close(websocket.StatusGoingAway, "Server shutting down")
+		if client.conn != nil {
+			client.conn.Close(websocket.StatusGoingAway, "Server shutting down")
+		}
 	}
 	h.clients = make(map[*Client]bool)
 }
@@ -184,12 +486,20 @@ const (
 func (c *Client) writePump(ctx context.Context) {
 	defer func() {
 		c.conn.Close(websocket.StatusInternalError, "write pump closed")
-		c.hub.unregister <- c
+		c.leave()
 	}()
 
 	pingTicker := time.NewTicker(pingPeriod)
 	defer pingTicker.Stop()
 
+	// Heartbeats let v2 clients tell a quiet stream from a dead one
+	var heartbeat <-chan time.Time
+	if c.enveloped() {
+		heartbeatTicker := time.NewTicker(heartbeatPeriod)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -202,14 +512,16 @@ func (c *Client) writePump(ctx context.Context) {
 				return
 			}
 
-			// Create write context with timeout
-			writeCtx, cancel := context.WithTimeout(ctx, writeWait)
-			if err := wsjson.Write(writeCtx, c.conn, message); err != nil {
+			if err := c.write(ctx, message); err != nil {
 				c.hub.logger.Error("Error writing message", zap.Error(err), zap.String("client_id", c.id))
-				cancel()
 				return
 			}
-			cancel()
+
+		case <-heartbeat:
+			if err := c.write(ctx, c.hub.newEnvelope(MessageHeartbeat, nil)); err != nil {
+				c.hub.logger.Error("Error writing heartbeat", zap.Error(err), zap.String("client_id", c.id))
+				return
+			}
 
 		case <-pingTicker.C:
 			// Send ping
@@ -224,11 +536,25 @@ func (c *Client) writePump(ctx context.Context) {
 	}
 }
 
+// write sends a message in the client's protocol: the whole envelope for
+// v2, the bare data for v1
+func (c *Client) write(ctx context.Context, message *Envelope) error {
+	var payload interface{} = message
+	if !c.enveloped() {
+		payload = message.Data
+	}
+
+	// Create write context with timeout
+	writeCtx, cancel := context.WithTimeout(ctx, writeWait)
+	defer cancel()
+	return wsjson.Write(writeCtx, c.conn, payload)
+}
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump(ctx context.Context) {
 	defer func() {
 		c.conn.Close(websocket.StatusInternalError, "read pump closed")
-		c.hub.unregister <- c
+		c.leave()
 	}()
 
 	for {
@@ -241,9 +567,10 @@ func (c *Client) readPump(ctx context.Context) {
 			// Create read context with timeout
 			readCtx, cancel := context.WithTimeout(ctx, pongWait)
 
-			// Read message (for subscription updates)
-			var msg map[string]interface{}
-			if err := wsjson.Read(readCtx, c.conn, &msg); err != nil {
+			// Read message (subscription changes and probe requests); it is
+			// decoded by handleMessage so bad JSON gets an error reply
+			_, data, err := c.conn.Read(readCtx)
+			if err != nil {
 				cancel()
 				if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
 					websocket.CloseStatus(err) == websocket.StatusGoingAway {
@@ -255,86 +582,56 @@ func (c *Client) readPump(ctx context.Context) {
 			}
 			cancel()
 
-			// Handle subscription updates
-			c.handleSubscriptionUpdate(msg)
+			c.handleMessage(data)
 		}
 	}
 }
 
-func (c *Client) handleSubscriptionUpdate(msg map[string]interface{}) {
-	// Simple manual parsing instead of mapstructure
-	action, ok := msg["action"].(string)
-	if !ok || action != "subscribe" {
+// handleProbeRequest triggers an on-demand probe. The result arrives on the
+// stream tagged with the correlation ID, which clients may choose themselves.
+func (c *Client) handleProbeRequest(header controlHeader, req ProbeRequest) {
+	c.hub.mu.RLock()
+	trigger := c.hub.trigger
+	c.hub.mu.RUnlock()
+
+	if trigger == nil {
+		c.logger.Warn("Probe requested but no trigger is configured", zap.String("client_id", c.id))
+		c.replyError(header, ErrorUnavailable, "on-demand probes are not available")
 		return
 	}
 
-	var payers, types []string
-	if payersRaw, ok := msg["payers"]; ok {
-		if payersSlice, ok := payersRaw.([]interface{}); ok {
-			for _, p := range payersSlice {
-				if payer, ok := p.(string); ok {
-					payers = append(payers, payer)
-				}
-			}
-		}
+	// Clients limited to some payers may only probe those payers
+	if c.principal.Payers != nil && (req.Payer == "" || !c.principal.AllowsPayer(req.Payer)) {
+		c.logger.Warn("Client probe request outside its allowed payers",
+			zap.String("client_id", c.id),
+			zap.String("payer", req.Payer))
+		c.replyError(header, ErrorForbidden, "not allowed to probe this payer")
+		return
 	}
 
-	if typesRaw, ok := msg["types"]; ok {
-		if typesSlice, ok := typesRaw.([]interface{}); ok {
-			for _, t := range typesSlice {
-				if msgType, ok := t.(string); ok {
-					types = append(types, msgType)
-				}
-			}
+	correlationID, err := trigger(req)
+	if err != nil {
+		c.logger.Warn("Client probe request failed",
+			zap.String("client_id", c.id),
+			zap.String("payer", req.Payer),
+			zap.String("type", req.Type),
+			zap.Error(err))
+		code := ErrorProbeFailed
+		if errors.Is(err, ErrThrottled) {
+			code = ErrorThrottled
 		}
+		c.replyError(header, code, err.Error())
+		return
 	}
 
-	// Update predicate
-	c.predicate = c.createPredicate(payers, types)
-	c.logger.Info("Client subscription updated",
+	c.logger.Info("Client triggered probe",
 		zap.String("client_id", c.id),
-		zap.Strings("payers", payers),
-		zap.Strings("types", types))
-}
-
-// createPredicate creates a filter function based on subscription criteria
-func (c *Client) createPredicate(payers, types []string) func(*config.ProbeResult) bool {
-	return func(result *config.ProbeResult) bool {
-		// If no filters specified, accept all
-		if len(payers) == 0 && len(types) == 0 {
-			return true
-		}
-
-		// Check payer filter
-		if len(payers) > 0 {
-			payerMatch := false
-			for _, payer := range payers {
-				if result.Payer == payer {
-					payerMatch = true
-					break
-				}
-			}
-			if !payerMatch {
-				return false
-			}
-		}
-
-		// Check type filter
-		if len(types) > 0 {
-			typeMatch := false
-			for _, typ := range types {
-				if result.Type == typ {
-					typeMatch = true
-					break
-				}
-			}
-			if !typeMatch {
-				return false
-			}
-		}
-
-		return true
-	}
+		zap.String("correlation_id", correlationID))
+	c.reply(c.hub.newEnvelope(MessageProbeAccepted, ProbeAccepted{
+		Action:        header.Action,
+		ID:            header.ID,
+		CorrelationID: correlationID,
+	}))
 }
 
 // generateClientID generates a unique client identifier
@@ -0,0 +1,209 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"payer-status-io/internal/config"
+)
+
+// startHub runs a hub with one payer behind a test server
+func startHub(t *testing.T) (*Hub, string) {
+	t.Helper()
+	h := New(zap.NewNop())
+	return h, serveHub(t, h)
+}
+
+// serveHub gives a hub one payer and runs it behind a test server,
+// returning the WebSocket URL
+func serveHub(t *testing.T, h *Hub) string {
+	t.Helper()
+	h.SetPayers([]config.Payer{{Name: "Acme"}})
+	h.SetTrigger(func(req ProbeRequest) (string, error) { return "corr-1", nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Run(ctx)
+	}()
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+		<-done
+	})
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+// dial connects with the given subprotocol, none if empty
+func dial(t *testing.T, url, protocol string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	options := &websocket.DialOptions{}
+	if protocol != "" {
+		options.Subprotocols = []string{protocol}
+	}
+	conn, _, err := websocket.Dial(ctx, url, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+	return conn
+}
+
+// exchange sends a message, if any, and reads the next one
+func exchange(t *testing.T, conn *websocket.Conn, message string) map[string]interface{} {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if message != "" {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var reply map[string]interface{}
+	if err := wsjson.Read(ctx, conn, &reply); err != nil {
+		t.Fatalf("no reply to %s: %v", message, err)
+	}
+	return reply
+}
+
+// fields returns the listed fields of a message as compact JSON
+func fields(t *testing.T, message map[string]interface{}, keys ...string) string {
+	t.Helper()
+	picked := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := message[key]; ok {
+			picked[key] = value
+		}
+	}
+	data, err := json.Marshal(picked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestV1ClientsGetBareReplies(t *testing.T) {
+	h, url := startHub(t)
+
+	for _, protocol := range []string{ProtocolV1, ""} {
+		name := protocol
+		if name == "" {
+			name = "no subprotocol"
+		}
+		t.Run(name, func(t *testing.T) {
+			conn := dial(t, url, protocol)
+
+			tests := []struct {
+				message string
+				keys    []string
+				want    string
+			}{
+				{
+					`{"action": "subscribe", "id": "s1", "payers": ["Acme"]}`,
+					[]string{"action", "id", "payers", "type"},
+					`{"action":"subscribe","id":"s1","payers":["Acme"]}`,
+				},
+				{
+					`{"action": "ping", "id": "x"}`,
+					[]string{"action", "id", "code", "type"},
+					`{"action":"ping","code":"unknown_action","id":"x"}`,
+				},
+				{
+					`{"action": "add", "payers": ["Nobody"]}`,
+					[]string{"action", "code"},
+					`{"action":"add","code":"unknown_payer"}`,
+				},
+				{
+					`not json`,
+					[]string{"code"},
+					`{"code":"invalid_message"}`,
+				},
+				{
+					`{"action": "probe", "id": "p1", "payer": "Acme"}`,
+					[]string{"action", "id", "correlation_id"},
+					`{"action":"probe","correlation_id":"corr-1","id":"p1"}`,
+				},
+				{
+					`{"action": "unsubscribe", "id": "u1"}`,
+					[]string{"action", "id"},
+					`{"action":"unsubscribe","id":"u1"}`,
+				},
+			}
+			for _, tt := range tests {
+				if got := fields(t, exchange(t, conn, tt.message), tt.keys...); got != tt.want {
+					t.Errorf("reply to %s = %s, want %s", tt.message, got, tt.want)
+				}
+			}
+
+			// Results stay bare too, and no snapshot or incident is sent
+			exchange(t, conn, `{"action": "subscribe"}`)
+			h.Broadcast(&config.ProbeResult{Payer: "Acme", Type: "login", URL: "https://acme.example.com", StatusCode: 500, Timestamp: time.Now()})
+			got := fields(t, exchange(t, conn, ""), "payer", "status_code", "type", "data")
+			if want := `{"payer":"Acme","status_code":500,"type":"login"}`; got != want {
+				t.Errorf("result = %s, want %s", got, want)
+			}
+			h.Broadcast(&config.ProbeResult{Payer: "Acme", Type: "login", URL: "https://acme.example.com", StatusCode: 200, Timestamp: time.Now()})
+			got = fields(t, exchange(t, conn, ""), "payer", "status_code", "state")
+			if want := `{"payer":"Acme","status_code":200}`; got != want {
+				t.Errorf("second result = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestV2ClientsGetEnvelopedReplies(t *testing.T) {
+	_, url := startHub(t)
+	conn := dial(t, url, ProtocolV2)
+
+	if got := exchange(t, conn, ""); got["type"] != MessageSnapshot {
+		t.Fatalf("first message = %v, want a snapshot", got)
+	}
+
+	reply := exchange(t, conn, `{"action": "ping", "id": "x"}`)
+	data, _ := reply["data"].(map[string]interface{})
+	if reply["type"] != MessageError || data["code"] != ErrorUnknownAction || data["id"] != "x" {
+		t.Errorf("reply = %v, want an unknown_action error envelope", reply)
+	}
+
+	reply = exchange(t, conn, `{"action": "subscribe", "id": "s1"}`)
+	if reply["type"] != MessageSubscribed {
+		t.Errorf("reply = %v, want a subscribed envelope", reply)
+	}
+	if next := exchange(t, conn, ""); next["type"] != MessageSnapshot {
+		t.Errorf("after subscribing got %v, want a snapshot", next)
+	}
+}
+
+func TestRelayDropsReplayedResults(t *testing.T) {
+	h, url := startHub(t)
+	conn := dial(t, url, ProtocolV1)
+	exchange(t, conn, `{"action": "subscribe"}`)
+
+	first := &config.ProbeResult{Payer: "Acme", Type: "login", URL: "https://acme.example.com", StatusCode: 500, Timestamp: time.Now()}
+	replayed := *first
+	next := &config.ProbeResult{Payer: "Acme", Type: "login", URL: "https://acme.example.com", StatusCode: 200, Timestamp: first.Timestamp.Add(time.Second)}
+	h.Relay(first)
+	h.Relay(&replayed)
+	h.Relay(next)
+
+	for _, want := range []float64{500, 200} {
+		if got := exchange(t, conn, ""); got["status_code"] != want {
+			t.Errorf("relayed status = %v, want %v", got["status_code"], want)
+		}
+	}
+}
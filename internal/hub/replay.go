@@ -0,0 +1,193 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// DefaultReplaySize is how many broadcast messages are kept for resuming
+// clients unless SetReplaySize says otherwise
+const DefaultReplaySize = 1000
+
+// Reasons a resuming client gets a gap notice
+const (
+	GapEvicted  = "evicted"     // Some missed messages are no longer kept
+	GapUnknown  = "unknown_seq" // resume_from is ahead of the server, e.g. after a restart
+	GapTooLarge = "too_large"   // More missed messages than the client's queue holds
+)
+
+// Gap tells a resuming client that the messages it missed cannot be
+// replayed. A snapshot follows.
+type Gap struct {
+	ResumeFrom uint64 `json:"resume_from"`
+	OldestSeq  uint64 `json:"oldest_seq,omitempty"` // Oldest message still kept
+	Reason     string `json:"reason"`
+}
+
+// replayEntry is a broadcast message kept for resuming clients, with what
+// their filters need to decide whether it was meant for them
+type replayEntry struct {
+	message *Envelope
+	result  *config.ProbeResult
+	changed bool
+}
+
+// replayBuffer is a ring of the most recent broadcast messages
+type replayBuffer struct {
+	mu      sync.Mutex
+	entries []replayEntry
+	next    int    // Where the next entry goes
+	count   int    // How many entries are in use
+	evicted uint64 // Highest seq no longer kept
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{entries: make([]replayEntry, size)}
+}
+
+// add keeps an entry, evicting the oldest when the buffer is full
+func (b *replayBuffer) add(entry replayEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.entries) == 0 {
+		b.evicted = entry.message.Seq
+		return
+	}
+	if b.count == len(b.entries) {
+		b.evicted = b.entries[b.next].message.Seq
+	} else {
+		b.count++
+	}
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+}
+
+// since returns the entries after seq, oldest first. ok is false if some
+// of them were evicted.
+func (b *replayBuffer) since(seq uint64) (entries []replayEntry, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if seq < b.evicted {
+		return nil, false
+	}
+	if b.count == 0 {
+		return nil, true
+	}
+	start := (b.next - b.count + len(b.entries)) % len(b.entries)
+	for i := 0; i < b.count; i++ {
+		entry := b.entries[(start+i)%len(b.entries)]
+		if entry.message.Seq > seq {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, true
+}
+
+// oldest returns the seq of the oldest entry kept, or 0 if there is none
+func (b *replayBuffer) oldest() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.count == 0 {
+		return 0
+	}
+	return b.entries[(b.next-b.count+len(b.entries))%len(b.entries)].message.Seq
+}
+
+// results returns the probe results kept, oldest first
+func (b *replayBuffer) results() []*config.ProbeResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	results := make([]*config.ProbeResult, 0, b.count)
+	if b.count == 0 {
+		return results
+	}
+	start := (b.next - b.count + len(b.entries)) % len(b.entries)
+	for i := 0; i < b.count; i++ {
+		entry := b.entries[(start+i)%len(b.entries)]
+		if entry.message.Type == MessageProbeResult {
+			results = append(results, entry.result)
+		}
+	}
+	return results
+}
+
+// History returns the recent probe results kept for resuming clients,
+// oldest first. How far back it goes depends on the replay buffer size
+// and on how many incidents share the buffer.
+func (h *Hub) History() []*config.ProbeResult {
+	return h.replay.results()
+}
+
+// parseResumeFrom reads the seq a client resumes from; empty means none
+func parseResumeFrom(value string) (*uint64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume_from %q", value)
+	}
+	return &seq, nil
+}
+
+// catchUp brings a new v2 or SSE client up to date. A resuming client gets the
+// messages it missed, or a gap notice when they cannot all be replayed;
+// everyone else, and clients after a gap, get a snapshot. Run calls it
+// between broadcasts, so nothing is missed or sent twice.
+func (c *Client) catchUp() {
+	if !c.enveloped() {
+		return
+	}
+	if c.resumeFrom == nil {
+		c.sendSnapshot()
+		return
+	}
+
+	resumeFrom := *c.resumeFrom
+	reason := GapUnknown
+	if resumeFrom <= c.hub.seq.Load() {
+		entries, ok := c.hub.replay.since(resumeFrom)
+		var missed []*Envelope
+		for _, entry := range entries {
+			if c.accepts(entry.message) && c.matches(entry.result, entry.changed) {
+				missed = append(missed, entry.message)
+			}
+		}
+
+		switch {
+		case !ok:
+			reason = GapEvicted
+		case len(missed) > cap(c.send)-len(c.send):
+			reason = GapTooLarge
+		default:
+			for _, message := range missed {
+				c.reply(message)
+			}
+			c.logger.Info("Client resumed",
+				zap.String("client_id", c.id),
+				zap.Uint64("resume_from", resumeFrom),
+				zap.Int("replayed", len(missed)))
+			return
+		}
+	}
+
+	c.logger.Info("Client cannot resume, sending a snapshot",
+		zap.String("client_id", c.id),
+		zap.Uint64("resume_from", resumeFrom),
+		zap.String("reason", reason))
+	c.reply(c.hub.newEnvelope(MessageGap, Gap{
+		ResumeFrom: resumeFrom,
+		OldestSeq:  c.hub.replay.oldest(),
+		Reason:     reason,
+	}))
+	c.sendSnapshot()
+}
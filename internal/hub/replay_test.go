@@ -0,0 +1,180 @@
+package hub
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// entry makes a replay entry for a probe result with the given seq
+func entry(seq uint64) replayEntry {
+	result := &config.ProbeResult{Payer: "Acme", Type: fmt.Sprint("t", seq), StatusCode: 200}
+	return replayEntry{message: &Envelope{Seq: seq, Type: MessageProbeResult, Data: result}, result: result}
+}
+
+// seqs returns the seq of each entry
+func seqs(entries []replayEntry) []uint64 {
+	var seqs []uint64
+	for _, e := range entries {
+		seqs = append(seqs, e.message.Seq)
+	}
+	return seqs
+}
+
+func TestReplayBufferWrapsAround(t *testing.T) {
+	b := newReplayBuffer(3)
+	if entries, ok := b.since(0); !ok || len(entries) != 0 || b.oldest() != 0 {
+		t.Fatalf("empty buffer: since(0) = %v, %v; oldest %d", seqs(entries), ok, b.oldest())
+	}
+
+	for seq := uint64(1); seq <= 7; seq++ {
+		b.add(entry(seq))
+	}
+
+	// 5, 6 and 7 are kept, in a ring that has wrapped twice
+	tests := []struct {
+		since uint64
+		want  []uint64
+		ok    bool
+	}{
+		{0, nil, false},
+		{3, nil, false},
+		{4, []uint64{5, 6, 7}, true},
+		{5, []uint64{6, 7}, true},
+		{6, []uint64{7}, true},
+		{7, nil, true},
+	}
+	for _, tt := range tests {
+		entries, ok := b.since(tt.since)
+		if ok != tt.ok || fmt.Sprint(seqs(entries)) != fmt.Sprint(tt.want) {
+			t.Errorf("since(%d) = %v, %v; want %v, %v", tt.since, seqs(entries), ok, tt.want, tt.ok)
+		}
+	}
+	if got := b.oldest(); got != 5 {
+		t.Errorf("oldest = %d, want 5", got)
+	}
+
+	var types []string
+	for _, result := range b.results() {
+		types = append(types, result.Type)
+	}
+	if fmt.Sprint(types) != "[t5 t6 t7]" {
+		t.Errorf("results = %v, want [t5 t6 t7]", types)
+	}
+}
+
+func TestReplayBufferDisabled(t *testing.T) {
+	b := newReplayBuffer(0)
+	b.add(entry(1))
+	b.add(entry(2))
+
+	if _, ok := b.since(1); ok {
+		t.Error("since(1) succeeded with replay disabled")
+	}
+	if entries, ok := b.since(2); !ok || len(entries) != 0 {
+		t.Errorf("since(2) = %v, %v; want nothing to replay", seqs(entries), ok)
+	}
+	if b.oldest() != 0 || len(b.results()) != 0 {
+		t.Error("a disabled buffer kept entries")
+	}
+}
+
+// broadcastSeqs broadcasts healthy results, so no incidents are opened,
+// and returns the seq each reached a v2 client with
+func broadcastSeqs(t *testing.T, h *Hub, url string, n int) []uint64 {
+	t.Helper()
+	conn := dial(t, url, ProtocolV2)
+	if got := exchange(t, conn, ""); got["type"] != MessageSnapshot {
+		t.Fatalf("first message = %v, want a snapshot", got)
+	}
+
+	var seqs []uint64
+	for i := 0; i < n; i++ {
+		h.Broadcast(&config.ProbeResult{Payer: "Acme", Type: fmt.Sprint("t", i), URL: "https://acme.example.com",
+			StatusCode: 200, Timestamp: time.Now()})
+		got := exchange(t, conn, "")
+		if got["type"] != MessageProbeResult {
+			t.Fatalf("got %v, want a probe result", got)
+		}
+		seqs = append(seqs, uint64(got["seq"].(float64)))
+	}
+	return seqs
+}
+
+// resume connects a v2 client that last saw seq
+func resume(t *testing.T, url string, seq uint64) func() map[string]interface{} {
+	t.Helper()
+	conn := dial(t, fmt.Sprintf("%s?resume_from=%d", url, seq), ProtocolV2)
+	return func() map[string]interface{} {
+		t.Helper()
+		return exchange(t, conn, "")
+	}
+}
+
+func TestResumeWithinBuffer(t *testing.T) {
+	h, url := startHub(t)
+	sent := broadcastSeqs(t, h, url, 4)
+
+	next := resume(t, url, sent[1])
+	for _, want := range sent[2:] {
+		if got := next(); got["type"] != MessageProbeResult || uint64(got["seq"].(float64)) != want {
+			t.Errorf("replayed %v, want the probe result with seq %d", got, want)
+		}
+	}
+
+	// Live results follow the replay, without a snapshot
+	h.Broadcast(&config.ProbeResult{Payer: "Acme", Type: "live", URL: "https://acme.example.com", StatusCode: 200, Timestamp: time.Now()})
+	if got := next(); got["type"] != MessageProbeResult || got["data"].(map[string]interface{})["type"] != "live" {
+		t.Errorf("after the replay got %v, want the live result", got)
+	}
+}
+
+func TestResumePastEviction(t *testing.T) {
+	h := New(zap.NewNop())
+	h.SetReplaySize(2)
+	url := serveHub(t, h)
+	sent := broadcastSeqs(t, h, url, 4)
+
+	next := resume(t, url, sent[0])
+	gap := next()
+	if gap["type"] != MessageGap {
+		t.Fatalf("first message = %v, want a gap notice", gap)
+	}
+	want := fmt.Sprintf(`{"oldest_seq":%d,"reason":"%s","resume_from":%d}`, sent[2], GapEvicted, sent[0])
+	if got := fields(t, gap["data"].(map[string]interface{}), "resume_from", "oldest_seq", "reason"); got != want {
+		t.Errorf("gap = %s, want %s", got, want)
+	}
+	if got := next(); got["type"] != MessageSnapshot {
+		t.Errorf("after the gap got %v, want a snapshot", got)
+	}
+}
+
+func TestResumeAcrossWraparound(t *testing.T) {
+	h := New(zap.NewNop())
+	h.SetReplaySize(3)
+	url := serveHub(t, h)
+	sent := broadcastSeqs(t, h, url, 7)
+
+	// The oldest message kept is the last one the client missed
+	next := resume(t, url, sent[3])
+	for _, want := range sent[4:] {
+		if got := next(); got["type"] != MessageProbeResult || uint64(got["seq"].(float64)) != want {
+			t.Errorf("replayed %v, want the probe result with seq %d", got, want)
+		}
+	}
+}
+
+func TestResumeFromTheFuture(t *testing.T) {
+	h, url := startHub(t)
+	sent := broadcastSeqs(t, h, url, 1)
+
+	next := resume(t, url, sent[0]+100)
+	gap := next()
+	if gap["type"] != MessageGap || gap["data"].(map[string]interface{})["reason"] != GapUnknown {
+		t.Errorf("first message = %v, want an unknown_seq gap notice", gap)
+	}
+}
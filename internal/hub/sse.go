@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// HandleEvents streams the v2 envelopes as Server-Sent Events: the event
+// ID is the seq and the event name the message type. Filters come from the
+// query string, and Last-Event-ID resumes like resume_from does.
+func (h *Hub) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.RLock()
+	authenticator := h.auth
+	h.mu.RUnlock()
+
+	principal, err := authenticator.Authenticate(r)
+	if err != nil {
+		h.logger.Warn("SSE authentication failed",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.Error(err))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="payer-status"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Browsers reconnect with Last-Event-ID; other clients may use the query
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("resume_from")
+	}
+	resumeFrom, err := parseResumeFrom(lastEventID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := &Client{
+		id:        generateClientID(),
+		send:      make(chan *Envelope, 256),
+		protocol:  ProtocolSSE,
+		principal: principal,
+		logger:    h.logger,
+		hub:       h,
+
+		resumeFrom: resumeFrom,
+	}
+
+	spec, err := filterSpecFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code, err := client.checkPayers(spec); err != nil {
+		status := http.StatusBadRequest
+		if code == ErrorForbidden {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if client.filter, err = NewFilter(spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	// Send the headers now; the first message may be a while
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		h.logger.Error("SSE streaming is not supported", zap.Error(err))
+		return
+	}
+
+	h.logger.Info("SSE client connected",
+		zap.String("client_id", client.id),
+		zap.String("subject", principal.Subject),
+		zap.Any("filter", spec))
+
+	if !client.join(r.Context()) {
+		return
+	}
+	client.streamEvents(r.Context(), w, rc)
+}
+
+// streamEvents writes the client's messages until it goes away or the hub
+// drops it. The stream outlives the server's WriteTimeout, so every write
+// sets its own deadline instead.
+func (c *Client) streamEvents(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController) {
+	write := func(message *Envelope) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if err := rc.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", message.Seq, message.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	heartbeat := time.NewTicker(heartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Debug("SSE client disconnected", zap.String("client_id", c.id))
+			c.leave()
+			return
+
+		case message, ok := <-c.send:
+			if !ok {
+				c.logger.Debug("Send channel closed", zap.String("client_id", c.id))
+				return
+			}
+			if err := write(message); err != nil {
+				c.logger.Error("Error writing event", zap.Error(err), zap.String("client_id", c.id))
+				c.leave()
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := write(c.hub.newEnvelope(MessageHeartbeat, nil)); err != nil {
+				c.logger.Error("Error writing heartbeat", zap.Error(err), zap.String("client_id", c.id))
+				c.leave()
+				return
+			}
+		}
+	}
+}
+
+// filterSpecFromQuery reads a filter from repeated payer, payer_pattern,
+// type and label=key=value parameters and single status, min_latency_ms,
+// max_latency_ms and transitions parameters
+func filterSpecFromQuery(query url.Values) (config.EventFilter, error) {
+	spec := config.EventFilter{
+		Payers:        query["payer"],
+		PayerPatterns: query["payer_pattern"],
+		Types:         query["type"],
+		Status:        query.Get("status"),
+	}
+	for _, pair := range query["label"] {
+		key, value, _ := strings.Cut(pair, "=")
+		if key == "" {
+			return spec, fmt.Errorf("label %q must be key=value", pair)
+		}
+		if spec.Labels == nil {
+			spec.Labels = make(map[string]string)
+		}
+		spec.Labels[key] = value
+	}
+
+	var err error
+	if v := query.Get("min_latency_ms"); v != "" {
+		if spec.MinLatencyMS, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return spec, fmt.Errorf("invalid min_latency_ms %q", v)
+		}
+	}
+	if v := query.Get("max_latency_ms"); v != "" {
+		if spec.MaxLatencyMS, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return spec, fmt.Errorf("invalid max_latency_ms %q", v)
+		}
+	}
+	if v := query.Get("transitions"); v != "" {
+		if spec.Transitions, err = strconv.ParseBool(v); err != nil {
+			return spec, fmt.Errorf("invalid transitions %q", v)
+		}
+	}
+	return spec, nil
+}
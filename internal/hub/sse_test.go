@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"nhooyr.io/websocket"
+)
+
+// stoppedHub returns a hub whose Run has already returned
+func stoppedHub(t *testing.T) *Hub {
+	t.Helper()
+	h := New(zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.Run(ctx)
+	return h
+}
+
+// within fails the test if fn does not return in time
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return after the hub stopped", what)
+	}
+}
+
+func TestSSEAfterHubStops(t *testing.T) {
+	h := stoppedHub(t)
+	server := httptest.NewServer(http.HandlerFunc(h.HandleEvents))
+	defer server.Close()
+
+	within(t, "SSE request", func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+	})
+}
+
+func TestSSEDisconnectAfterHubStops(t *testing.T) {
+	h := stoppedHub(t)
+	client := &Client{id: "sse", send: make(chan *Envelope, 1), protocol: ProtocolSSE, logger: zap.NewNop(), hub: h}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	within(t, "streamEvents", func() {
+		client.streamEvents(ctx, w, http.NewResponseController(w))
+	})
+}
+
+func TestWebSocketAfterHubStops(t *testing.T) {
+	h := stoppedHub(t)
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	defer server.Close()
+
+	within(t, "WebSocket connection", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _, err = conn.Read(ctx)
+		if status := websocket.CloseStatus(err); status != websocket.StatusGoingAway {
+			t.Errorf("read error = %v, want the server going away", err)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -28,13 +29,35 @@ type Metrics struct {
 	schedulerTasksTotal *prometheus.GaugeVec
 	httpClientsTotal    *prometheus.GaugeVec
 
+	// Scheduler accounting
+	tasksSkippedTotal *prometheus.CounterVec
+	tasksDroppedTotal *prometheus.CounterVec
+	probeOverdue      *prometheus.GaugeVec
+
+	// Global and per-host concurrency gate
+	gateQueueDepth *prometheus.GaugeVec
+	gateInFlight   *prometheus.GaugeVec
+	gateWait       *prometheus.HistogramVec
+
+	// Webhook deliveries
+	webhookDeliveries *prometheus.CounterVec
+	webhookRetries    *prometheus.CounterVec
+
+	// Broker publishing
+	brokerMessages *prometheus.CounterVec
+
+	// Endpoint labels added to the probe metrics
+	labelKeys []string
+
 	logger *zap.Logger
 }
 
-// New creates a new metrics collector
-func New(logger *zap.Logger) *Metrics {
+// New creates a new metrics collector. The endpoint labels named in
+// labelKeys are added to the probe duration and count metrics.
+func New(logger *zap.Logger, labelKeys []string) *Metrics {
 	m := &Metrics{
-		logger: logger,
+		labelKeys: labelKeys,
+		logger:    logger,
 	}
 
 	m.initMetrics()
@@ -45,6 +68,8 @@ func New(logger *zap.Logger) *Metrics {
 
 // initMetrics initializes all Prometheus metrics
 func (m *Metrics) initMetrics() {
+	probeLabels := append([]string{"payer", "type", "status_code"}, m.labelKeys...)
+
 	// Probe duration histogram (as per .windsurfrules)
 	m.probeDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -52,7 +77,7 @@ func (m *Metrics) initMetrics() {
 			Help:    "Duration of health probe requests in seconds",
 			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15), // 1ms to ~32s
 		},
-		[]string{"payer", "type", "status_code"},
+		probeLabels,
 	)
 
 	// Probe total counter (as per .windsurfrules)
@@ -61,7 +86,7 @@ func (m *Metrics) initMetrics() {
 			Name: "probe_total",
 			Help: "Total number of health probes executed",
 		},
-		[]string{"payer", "type", "status_code"},
+		probeLabels,
 	)
 
 	// WebSocket active connections (as per .windsurfrules)
@@ -108,6 +133,84 @@ func (m *Metrics) initMetrics() {
 		},
 		[]string{"hostname"},
 	)
+
+	// Scheduled runs that never reached a worker
+	m.tasksSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scheduler_tasks_skipped_total",
+			Help: "Total number of scheduled probes skipped before dispatch",
+		},
+		[]string{"payer", "type", "reason"}, // paused, rate_limited, deferred
+	)
+
+	m.tasksDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scheduler_tasks_dropped_total",
+			Help: "Total number of probes dropped because the task channel was full",
+		},
+		[]string{"payer", "type"},
+	)
+
+	// Endpoints that have gone too long without a probe
+	m.probeOverdue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_overdue",
+			Help: "Whether an endpoint has missed several scheduled probes (1 = overdue)",
+		},
+		[]string{"payer", "type", "url"},
+	)
+
+	// Probes waiting for a concurrency or rate slot; host "*" is global
+	m.gateQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_gate_queue_depth",
+			Help: "Number of probes waiting at the concurrency gate",
+		},
+		[]string{"host"},
+	)
+
+	m.gateInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_gate_in_flight",
+			Help: "Number of probes holding a concurrency gate slot",
+		},
+		[]string{"host"},
+	)
+
+	m.gateWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "probe_gate_wait_seconds",
+			Help:    "Time probes spent waiting at the concurrency gate",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15), // 1ms to ~32s
+		},
+		[]string{"host"},
+	)
+
+	// Events delivered to webhooks, or given up on
+	m.webhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total number of webhook events by outcome",
+		},
+		[]string{"webhook", "result"}, // success, dead_letter, dropped
+	)
+
+	m.webhookRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_retries_total",
+			Help: "Total number of webhook delivery attempts retried after a failure",
+		},
+		[]string{"webhook"},
+	)
+
+	// Messages published to NATS and MQTT brokers, or given up on
+	m.brokerMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "broker_messages_total",
+			Help: "Total number of broker messages by outcome",
+		},
+		[]string{"publisher", "result"}, // published, failed, dropped
+	)
 }
 
 // registerMetrics registers all metrics with Prometheus
@@ -120,6 +223,15 @@ func (m *Metrics) registerMetrics() {
 		m.configReloadTotal,
 		m.schedulerTasksTotal,
 		m.httpClientsTotal,
+		m.tasksSkippedTotal,
+		m.tasksDroppedTotal,
+		m.probeOverdue,
+		m.gateQueueDepth,
+		m.gateInFlight,
+		m.gateWait,
+		m.webhookDeliveries,
+		m.webhookRetries,
+		m.brokerMessages,
 	)
 
 	m.logger.Info("Prometheus metrics registered")
@@ -137,6 +249,9 @@ func (m *Metrics) RecordProbe(result *config.ProbeResult) {
 		"type":        result.Type,
 		"status_code": statusCode,
 	}
+	for _, key := range m.labelKeys {
+		labels[key] = result.Labels[key]
+	}
 
 	// Record duration
 	duration := float64(result.LatencyMS) / 1000.0 // Convert to seconds
@@ -184,6 +299,57 @@ func (m *Metrics) SetHTTPClients(hostname string, count int) {
 	m.httpClientsTotal.WithLabelValues(hostname).Set(float64(count))
 }
 
+// RecordTaskSkipped counts a scheduled probe skipped before dispatch
+func (m *Metrics) RecordTaskSkipped(payer, endpointType, reason string) {
+	m.tasksSkippedTotal.WithLabelValues(payer, endpointType, reason).Inc()
+}
+
+// RecordTaskDropped counts a probe dropped because the task channel was full
+func (m *Metrics) RecordTaskDropped(payer, endpointType string) {
+	m.tasksDroppedTotal.WithLabelValues(payer, endpointType).Inc()
+}
+
+// SetProbeOverdue flags or clears an overdue endpoint
+func (m *Metrics) SetProbeOverdue(payer, endpointType, url string, overdue bool) {
+	if overdue {
+		m.probeOverdue.WithLabelValues(payer, endpointType, url).Set(1)
+		return
+	}
+	m.probeOverdue.DeleteLabelValues(payer, endpointType, url)
+}
+
+// SetGateQueueDepth updates the number of probes waiting for a host
+func (m *Metrics) SetGateQueueDepth(host string, depth int) {
+	m.gateQueueDepth.WithLabelValues(host).Set(float64(depth))
+}
+
+// SetGateInFlight updates the number of in-flight probes for a host
+func (m *Metrics) SetGateInFlight(host string, inFlight int) {
+	m.gateInFlight.WithLabelValues(host).Set(float64(inFlight))
+}
+
+// ObserveGateWait records how long a probe waited at the gate
+func (m *Metrics) ObserveGateWait(host string, wait time.Duration) {
+	m.gateWait.WithLabelValues(host).Observe(wait.Seconds())
+}
+
+// RecordWebhookDelivery counts a webhook event delivered, dead-lettered or
+// dropped
+func (m *Metrics) RecordWebhookDelivery(webhook, result string) {
+	m.webhookDeliveries.WithLabelValues(webhook, result).Inc()
+}
+
+// RecordWebhookRetry counts a failed webhook attempt that will be retried
+func (m *Metrics) RecordWebhookRetry(webhook string) {
+	m.webhookRetries.WithLabelValues(webhook).Inc()
+}
+
+// RecordBrokerPublish counts a message published to a broker, failed or
+// dropped
+func (m *Metrics) RecordBrokerPublish(publisher, result string) {
+	m.brokerMessages.WithLabelValues(publisher, result).Inc()
+}
+
 // Handler returns the Prometheus metrics HTTP handler
 func (m *Metrics) Handler() http.Handler {
 	return promhttp.Handler()
@@ -192,7 +358,7 @@ func (m *Metrics) Handler() http.Handler {
 // GetStats returns metrics statistics
 func (m *Metrics) GetStats() map[string]interface{} {
 	return map[string]interface{}{
-		"metrics_registered": 7,
-		"endpoint":          "/metrics",
+		"metrics_registered": 16,
+		"endpoint":           "/metrics",
 	}
 }
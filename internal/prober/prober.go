@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -22,19 +23,75 @@ type Prober struct {
 	mu      sync.RWMutex
 	logger  *zap.Logger
 	timeout time.Duration
+
+	maxRedirects        int
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
 }
 
+// maxRedirectsKey carries a request's redirect limit to CheckRedirect, since
+// clients are shared by every endpoint on a host
+type maxRedirectsKey struct{}
+
 // New creates a new prober with optimized HTTP clients
 func New(logger *zap.Logger, timeout time.Duration) *Prober {
+	defaults := config.DefaultServerConfig()
 	return &Prober{
 		clients: make(map[string]*http.Client),
 		logger:  logger,
 		timeout: timeout,
+
+		maxRedirects:        defaults.MaxRedirects,
+		maxIdleConns:        defaults.MaxIdleConns,
+		maxIdleConnsPerHost: defaults.MaxIdleConnsPerHost,
+		idleConnTimeout:     defaults.IdleConnTimeout,
 	}
 }
 
+// Configure applies the server's probe settings. Changed transport settings
+// replace the pooled clients; in-flight probes finish on the old ones.
+func (p *Prober) Configure(settings config.ServerConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.timeout = settings.ProbeTimeout
+	p.maxRedirects = settings.MaxRedirects
+
+	if p.maxIdleConns == settings.MaxIdleConns &&
+		p.maxIdleConnsPerHost == settings.MaxIdleConnsPerHost &&
+		p.idleConnTimeout == settings.IdleConnTimeout {
+		return
+	}
+
+	p.maxIdleConns = settings.MaxIdleConns
+	p.maxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	p.idleConnTimeout = settings.IdleConnTimeout
+
+	for _, client := range p.clients {
+		if transport, ok := client.Transport.(*http.Transport); ok {
+			transport.CloseIdleConnections()
+		}
+	}
+	p.clients = make(map[string]*http.Client)
+
+	p.logger.Info("HTTP transport settings changed, recreating clients",
+		zap.Int("max_idle_conns", settings.MaxIdleConns),
+		zap.Int("max_idle_conns_per_host", settings.MaxIdleConnsPerHost),
+		zap.Duration("idle_conn_timeout", settings.IdleConnTimeout))
+}
+
 // ProbeTask executes a health probe for the given task
 func (p *Prober) ProbeTask(ctx context.Context, task *scheduler.Task) *config.ProbeResult {
+	p.mu.RLock()
+	timeout := task.Endpoint.GetTimeout(p.timeout)
+	maxRedirects := task.Endpoint.GetMaxRedirects(p.maxRedirects)
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, maxRedirectsKey{}, maxRedirects)
+
 	start := time.Now()
 	
 	result := &config.ProbeResult{
@@ -42,6 +99,9 @@ func (p *Prober) ProbeTask(ctx context.Context, task *scheduler.Task) *config.Pr
 		Payer:     task.Payer,
 		Type:      task.Endpoint.Type,
 		URL:       p.resolveURL(task.Endpoint),
+		Labels:    task.Endpoint.Labels,
+
+		CorrelationID: task.CorrelationID,
 	}
 
 	// Create HTTP request
@@ -67,6 +127,9 @@ func (p *Prober) ProbeTask(ctx context.Context, task *scheduler.Task) *config.Pr
 	// Record results
 	result.StatusCode = resp.StatusCode
 	result.LatencyMS = time.Since(start).Milliseconds()
+	if limit := task.Endpoint.Thresholds.MaxLatency; limit > 0 && result.Healthy() && result.LatencyMS > limit.Milliseconds() {
+		result.Err = fmt.Sprintf("latency %dms exceeds max_latency %s", result.LatencyMS, limit)
+	}
 
 	p.logger.Debug("Probe completed",
 		zap.String("payer", task.Payer),
@@ -93,6 +156,13 @@ func (p *Prober) createRequest(ctx context.Context, endpoint config.Endpoint) (*
 	req.Header.Set("Accept", "text/html,application/json,*/*")
 	req.Header.Set("Cache-Control", "no-cache")
 
+	for name, value := range endpoint.Headers {
+		if strings.Contains(value, "${") {
+			value = os.ExpandEnv(value)
+		}
+		req.Header.Set(name, value)
+	}
+
 	return req, nil
 }
 
@@ -108,6 +178,16 @@ func (p *Prober) resolveURL(endpoint config.Endpoint) string {
 	return url
 }
 
+// Hostname returns the host an endpoint's probes are sent to, or an empty
+// string if its URL cannot be parsed
+func (p *Prober) Hostname(endpoint config.Endpoint) string {
+	u, err := url.Parse(p.resolveURL(endpoint))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 // getClient returns an optimized HTTP client for the hostname
 func (p *Prober) getClient(hostname string) *http.Client {
 	p.mu.RLock()
@@ -129,9 +209,9 @@ func (p *Prober) getClient(hostname string) *http.Client {
 
 	// Create optimized transport for this hostname
 	transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        p.maxIdleConns,
+		MaxIdleConnsPerHost: p.maxIdleConnsPerHost,
+		IdleConnTimeout:     p.idleConnTimeout,
 		DisableCompression:  false,
 		ForceAttemptHTTP2:   true,
 		TLSClientConfig: &tls.Config{
@@ -140,13 +220,21 @@ func (p *Prober) getClient(hostname string) *http.Client {
 		},
 	}
 
+	// Timeouts come from each request's context, since endpoints on the
+	// same host may override the default
 	client = &http.Client{
 		Transport: transport,
-		Timeout:   p.timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Allow up to 5 redirects
-			if len(via) >= 5 {
-				return fmt.Errorf("too many redirects")
+			maxRedirects, ok := req.Context().Value(maxRedirectsKey{}).(int)
+			if !ok {
+				maxRedirects = config.DefaultServerConfig().MaxRedirects
+			}
+			if maxRedirects == 0 {
+				// Redirects disabled: report the 3xx response itself
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
 			}
 			return nil
 		},
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so the scheduling loop can be driven deterministically
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of time.Timer used by the scheduler
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r *realTimer) C() <-chan time.Time { return r.t.C }
+func (r *realTimer) Stop() bool          { return r.t.Stop() }
+
+// FakeClock is a manually advanced Clock. Timers fire only when Advance
+// moves the clock past their deadline.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+// NewFakeClock creates a fake clock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+// Now returns the fake current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer that fires once the clock reaches now+d
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		ch:       make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers[t] = struct{}{}
+	return t
+}
+
+// Advance moves the clock forward and fires every timer that is now due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.deadline.After(c.now) {
+			delete(c.timers, t)
+			t.ch <- c.now
+		}
+	}
+}
+
+// Timers returns the number of pending timers, letting callers wait until
+// the scheduler has gone back to sleep before advancing again
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	_, pending := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return pending
+}
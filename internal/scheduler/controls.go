@@ -0,0 +1,367 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// ControlAction is a runtime override applied to matching endpoints
+type ControlAction string
+
+const (
+	// ControlPause stops scheduled probes until removed or expired
+	ControlPause ControlAction = "pause"
+	// ControlMute keeps probing but marks results as muted so alerting
+	// consumers can ignore them
+	ControlMute ControlAction = "mute"
+)
+
+// Control is a pause or mute applied to every endpoint its selector matches
+type Control struct {
+	ID        string        `json:"id"`
+	Action    ControlAction `json:"action"`
+	Selector  Selector      `json:"selector"`
+	Reason    string        `json:"reason,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"` // Nil means until removed
+}
+
+// active reports whether the control applies at t
+func (c *Control) active(t time.Time) bool {
+	return c.ExpiresAt == nil || t.Before(*c.ExpiresAt)
+}
+
+// ErrControlNotFound is returned when no control can be removed for a selector
+var ErrControlNotFound = errors.New("no matching control")
+
+// controlState is the on-disk format for persisted controls
+type controlState struct {
+	Controls []*Control `json:"controls"`
+}
+
+// EndpointStatus describes the schedule and controls of one endpoint
+type EndpointStatus struct {
+	Payer      string            `json:"payer"`
+	Type       string            `json:"type"`
+	URL        string            `json:"url"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	NextRun    time.Time         `json:"next_run"`
+	LastRun    *time.Time        `json:"last_run,omitempty"`
+	IntervalMS int64             `json:"interval_ms"`
+	Paused     bool              `json:"paused"`
+	Muted      bool              `json:"muted"`
+	PauseUntil *time.Time        `json:"pause_until,omitempty"` // Nil while paused means indefinitely
+	MuteUntil  *time.Time        `json:"mute_until,omitempty"`
+	Skipped    uint64            `json:"skipped"`
+	Dropped    uint64            `json:"dropped"`
+	Overdue    bool              `json:"overdue"`
+}
+
+// SetStatePath enables persistence of controls to path and restores any
+// controls saved by a previous run
+func (s *Scheduler) SetStatePath(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statePath = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read scheduler state %s: %w", path, err)
+	}
+
+	var state controlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse scheduler state %s: %w", path, err)
+	}
+
+	now := s.clock.Now()
+	s.controls = s.controls[:0]
+	for _, c := range state.Controls {
+		if c.active(now) {
+			s.controls = append(s.controls, c)
+		}
+	}
+
+	s.logger.Info("Restored endpoint controls",
+		zap.String("state_path", path),
+		zap.Int("controls", len(s.controls)))
+
+	return nil
+}
+
+// AddControl pauses or mutes the selected endpoints. A zero expiresAt keeps
+// the control until it is removed.
+func (s *Scheduler) AddControl(action ControlAction, sel Selector, expiresAt time.Time, reason string) (*Control, error) {
+	if action != ControlPause && action != ControlMute {
+		return nil, fmt.Errorf("unknown control action %q", action)
+	}
+	if sel.IsEmpty() {
+		return nil, ErrEmptySelector
+	}
+
+	now := s.clock.Now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return nil, fmt.Errorf("expiry %s is in the past", expiresAt.Format(time.RFC3339))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.countMatching(sel) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	control := &Control{
+		ID:        newCorrelationID(),
+		Action:    action,
+		Selector:  sel,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	if !expiresAt.IsZero() {
+		control.ExpiresAt = &expiresAt
+	}
+	s.controls = append(s.controls, control)
+	s.saveControls()
+
+	s.logger.Info("Endpoint control added",
+		zap.String("id", control.ID),
+		zap.String("action", string(action)),
+		zap.String("payer", sel.Payer),
+		zap.String("type", sel.Type),
+		zap.String("url", sel.URL),
+		zap.Any("labels", sel.Labels),
+		zap.Time("expires_at", expiresAt))
+
+	return control, nil
+}
+
+// RemoveControls removes controls of the given action that only apply to
+// endpoints sel selects, returning how many were removed. This is resume
+// for pauses and unmute for mutes. A control reaching beyond sel is kept,
+// since removing it would also resume endpoints outside the selection; if
+// nothing could be removed, the error names such controls so they can be
+// removed by ID.
+func (s *Scheduler) RemoveControls(action ControlAction, sel Selector) (int, error) {
+	if sel.IsEmpty() {
+		return 0, ErrEmptySelector
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := s.removeControlsLocked(func(c *Control) bool {
+		return c.Action == action && sel.Covers(c.Selector)
+	})
+	if removed > 0 {
+		return removed, nil
+	}
+
+	var broader []string
+	now := s.clock.Now()
+	for _, c := range s.controls {
+		if c.Action == action && c.active(now) && s.overlaps(c.Selector, sel) {
+			broader = append(broader, c.ID)
+		}
+	}
+	if len(broader) > 0 {
+		return 0, fmt.Errorf("%w: %s %v also covers endpoints outside the selection, remove it by id",
+			ErrControlNotFound, action, broader)
+	}
+	return 0, fmt.Errorf("%w: no %s control for the selection", ErrControlNotFound, action)
+}
+
+// overlaps reports whether a loaded endpoint is selected by both a and b
+func (s *Scheduler) overlaps(a, b Selector) bool {
+	for _, task := range *s.heap {
+		if a.Matches(task.Payer, task.Endpoint) && b.Matches(task.Payer, task.Endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveControl removes a single control by ID
+func (s *Scheduler) RemoveControl(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.removeControlsLocked(func(c *Control) bool { return c.ID == id }) > 0
+}
+
+// removeControlsLocked drops controls matching fn and persists the result
+func (s *Scheduler) removeControlsLocked(fn func(*Control) bool) int {
+	kept := s.controls[:0]
+	removed := 0
+	for _, c := range s.controls {
+		if fn(c) {
+			removed++
+			s.logger.Info("Endpoint control removed",
+				zap.String("id", c.ID),
+				zap.String("action", string(c.Action)))
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.controls = kept
+
+	if removed > 0 {
+		s.saveControls()
+	}
+	return removed
+}
+
+// Controls returns the active controls
+func (s *Scheduler) Controls() []Control {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	controls := make([]Control, 0, len(s.controls))
+	for _, c := range s.controls {
+		if c.active(now) {
+			controls = append(controls, *c)
+		}
+	}
+	return controls
+}
+
+// IsMuted reports whether results for the endpoint should be marked muted
+func (s *Scheduler) IsMuted(payer string, endpoint config.Endpoint) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, muted := s.controlUntil(ControlMute, payer, endpoint, s.clock.Now())
+	return muted
+}
+
+// Status returns the schedule and control state of every endpoint, ordered
+// by payer and type
+func (s *Scheduler) Status() []EndpointStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.clock.Now()
+	statuses := make([]EndpointStatus, 0, s.heap.Len())
+	for _, task := range *s.heap {
+		status := EndpointStatus{
+			Payer:      task.Payer,
+			Type:       task.Endpoint.Type,
+			URL:        task.Endpoint.GetURL(),
+			Labels:     task.Endpoint.Labels,
+			NextRun:    task.NextRun,
+			IntervalMS: task.Interval.Milliseconds(),
+		}
+		if last, ok := s.lastRun[task.Key]; ok {
+			status.LastRun = &last
+		}
+		status.PauseUntil, status.Paused = s.controlUntil(ControlPause, task.Payer, task.Endpoint, now)
+		status.MuteUntil, status.Muted = s.controlUntil(ControlMute, task.Payer, task.Endpoint, now)
+		if counters, ok := s.taskCounters[task.Key]; ok {
+			status.Skipped = counters.skipped
+			status.Dropped = counters.dropped
+			status.Overdue = counters.overdue
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Payer != statuses[j].Payer {
+			return statuses[i].Payer < statuses[j].Payer
+		}
+		if statuses[i].Type != statuses[j].Type {
+			return statuses[i].Type < statuses[j].Type
+		}
+		return statuses[i].URL < statuses[j].URL
+	})
+
+	return statuses
+}
+
+// controlUntil reports whether an active control of the given action covers
+// the endpoint, and when the latest such control expires (nil if never)
+func (s *Scheduler) controlUntil(action ControlAction, payer string, endpoint config.Endpoint, now time.Time) (*time.Time, bool) {
+	var until *time.Time
+	found := false
+	for _, c := range s.controls {
+		if c.Action != action || !c.active(now) || !c.Selector.Matches(payer, endpoint) {
+			continue
+		}
+		if c.ExpiresAt == nil {
+			return nil, true
+		}
+		if until == nil || c.ExpiresAt.After(*until) {
+			until = c.ExpiresAt
+		}
+		found = true
+	}
+	return until, found
+}
+
+// isPaused reports whether scheduled probes for the endpoint are paused
+func (s *Scheduler) isPaused(payer string, endpoint config.Endpoint, now time.Time) bool {
+	_, paused := s.controlUntil(ControlPause, payer, endpoint, now)
+	return paused
+}
+
+// pruneControls drops expired controls so they don't accumulate on disk
+func (s *Scheduler) pruneControls(now time.Time) {
+	for _, c := range s.controls {
+		if !c.active(now) {
+			s.removeControlsLocked(func(c *Control) bool { return !c.active(now) })
+			return
+		}
+	}
+}
+
+// countMatching returns the number of loaded endpoints selected by sel
+func (s *Scheduler) countMatching(sel Selector) int {
+	count := 0
+	for _, task := range *s.heap {
+		if sel.Matches(task.Payer, task.Endpoint) {
+			count++
+		}
+	}
+	return count
+}
+
+// saveControls writes the controls to the state file, if one is configured.
+// The file is replaced atomically so a crash never leaves it half-written.
+func (s *Scheduler) saveControls() {
+	if s.statePath == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(controlState{Controls: s.controls}, "", "  ")
+	if err != nil {
+		s.logger.Error("Failed to encode scheduler state", zap.Error(err))
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0o755); err != nil {
+		s.logger.Error("Failed to create scheduler state directory", zap.Error(err))
+		return
+	}
+
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		s.logger.Error("Failed to write scheduler state", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, s.statePath); err != nil {
+		s.logger.Error("Failed to replace scheduler state", zap.Error(err))
+	}
+}
@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// newControlScheduler loads Acme and Beta, each with a login and a claims
+// endpoint, into a scheduler that is not started
+func newControlScheduler(t *testing.T, now time.Time) *Scheduler {
+	t.Helper()
+	s := New(zap.NewNop(), 10)
+	s.SetClock(NewFakeClock(now))
+	payer := func(name, region string) config.Payer {
+		return config.Payer{Name: name, Endpoints: []config.Endpoint{
+			{Type: "login", URL: "https://" + name + ".example.com/login", Schedule: 5 * time.Minute, Labels: map[string]string{"region": region}},
+			{Type: "claims", URL: "https://" + name + ".example.com/claims", Schedule: 5 * time.Minute, Labels: map[string]string{"region": region}},
+		}}
+	}
+	s.LoadConfig(&config.Config{Payers: []config.Payer{payer("Acme", "east"), payer("Beta", "west")}})
+	return s
+}
+
+// controlled lists "payer/type" for every endpoint with the given control
+func controlled(s *Scheduler, action ControlAction) string {
+	var names []string
+	for _, status := range s.Status() {
+		if (action == ControlPause && status.Paused) || (action == ControlMute && status.Muted) {
+			names = append(names, status.Payer+"/"+status.Type)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRemoveControlsBySelectorCoverage(t *testing.T) {
+	east := map[string]string{"region": "east"}
+
+	tests := []struct {
+		name    string
+		pauses  []Selector
+		mutes   []Selector
+		remove  Selector
+		removed int
+		err     error
+		named   []int  // Indexes of pauses the error must name
+		paused  string // Endpoints still paused afterwards
+	}{
+		{
+			name:    "same selector",
+			pauses:  []Selector{{Payer: "Acme", Type: "login"}},
+			remove:  Selector{Payer: "Acme", Type: "login"},
+			removed: 1,
+		},
+		{
+			name:    "broader selector removes every covered control",
+			pauses:  []Selector{{Payer: "Acme", Type: "login"}, {Payer: "Acme", Type: "claims"}, {Payer: "Beta", Type: "login"}},
+			remove:  Selector{Payer: "Acme"},
+			removed: 2,
+			paused:  "Beta/login",
+		},
+		{
+			name:    "labels cover a control with more criteria",
+			pauses:  []Selector{{Payer: "Acme", Labels: east}},
+			remove:  Selector{Labels: east},
+			removed: 1,
+		},
+		{
+			name:   "narrower selector keeps the broader control",
+			pauses: []Selector{{Payer: "Acme"}},
+			remove: Selector{Payer: "Acme", Type: "login"},
+			err:    ErrControlNotFound,
+			named:  []int{0},
+			paused: "Acme/claims Acme/login",
+		},
+		{
+			name:   "overlapping selector keeps the control",
+			pauses: []Selector{{Type: "login"}, {Payer: "Beta", Type: "claims"}},
+			remove: Selector{Payer: "Acme"},
+			err:    ErrControlNotFound,
+			named:  []int{0},
+			paused: "Acme/login Beta/claims Beta/login",
+		},
+		{
+			name:    "covered controls go while broader ones stay",
+			pauses:  []Selector{{Payer: "Acme"}, {Payer: "Acme", Type: "login"}},
+			remove:  Selector{Payer: "Acme", Type: "login"},
+			removed: 1,
+			paused:  "Acme/claims Acme/login",
+		},
+		{
+			name:   "nothing paused",
+			pauses: []Selector{{Payer: "Beta"}},
+			remove: Selector{Payer: "Acme"},
+			err:    ErrControlNotFound,
+			paused: "Beta/claims Beta/login",
+		},
+		{
+			name:   "resume leaves mutes",
+			mutes:  []Selector{{Payer: "Acme"}},
+			remove: Selector{Payer: "Acme"},
+			err:    ErrControlNotFound,
+		},
+		{
+			name:   "empty selector",
+			pauses: []Selector{{Payer: "Acme"}},
+			remove: Selector{},
+			err:    ErrEmptySelector,
+			paused: "Acme/claims Acme/login",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newControlScheduler(t, epoch)
+			var ids []string
+			for _, sel := range tt.pauses {
+				control, err := s.AddControl(ControlPause, sel, time.Time{}, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, control.ID)
+			}
+			for _, sel := range tt.mutes {
+				if _, err := s.AddControl(ControlMute, sel, time.Time{}, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed, err := s.RemoveControls(ControlPause, tt.remove)
+			if removed != tt.removed || !errors.Is(err, tt.err) {
+				t.Fatalf("RemoveControls = %d, %v; want %d, %v", removed, err, tt.removed, tt.err)
+			}
+			for _, i := range tt.named {
+				if !strings.Contains(err.Error(), ids[i]) {
+					t.Errorf("error %q does not name control %s", err, ids[i])
+				}
+			}
+			if got := controlled(s, ControlPause); got != tt.paused {
+				t.Errorf("paused = %q, want %q", got, tt.paused)
+			}
+			if want := len(tt.mutes); want > 0 && len(s.Controls()) != want {
+				t.Errorf("%d controls left, want the %d mutes", len(s.Controls()), want)
+			}
+		})
+	}
+}
+
+func TestControlsPersistAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "scheduler_state.json")
+
+	s := newControlScheduler(t, epoch)
+	if err := s.SetStatePath(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		action  ControlAction
+		sel     Selector
+		expires time.Time
+	}{
+		{ControlPause, Selector{Payer: "Acme", Type: "login"}, time.Time{}},
+		{ControlMute, Selector{Payer: "Beta"}, epoch.Add(time.Hour)},
+		{ControlPause, Selector{Payer: "Beta", Type: "claims"}, epoch.Add(10 * time.Minute)},
+	} {
+		if _, err := s.AddControl(c.action, c.sel, c.expires, "maintenance"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// After a restart 30 minutes on, the expired pause is gone
+	restarted := newControlScheduler(t, epoch.Add(30*time.Minute))
+	if err := restarted.SetStatePath(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := controlled(restarted, ControlPause); got != "Acme/login" {
+		t.Errorf("paused after restart = %q, want Acme/login", got)
+	}
+	if got := controlled(restarted, ControlMute); got != "Beta/claims Beta/login" {
+		t.Errorf("muted after restart = %q, want Beta/claims Beta/login", got)
+	}
+	for _, c := range restarted.Controls() {
+		if c.Reason != "maintenance" || !c.CreatedAt.Equal(epoch) {
+			t.Errorf("restored control %+v lost its reason or creation time", c)
+		}
+	}
+	if status := restarted.Status(); status[2].MuteUntil == nil || !status[2].MuteUntil.Equal(epoch.Add(time.Hour)) {
+		t.Errorf("Beta mute until %v, want %s", status[2].MuteUntil, epoch.Add(time.Hour))
+	}
+
+	// Removals are saved too
+	if removed, err := restarted.RemoveControls(ControlPause, Selector{Payer: "Acme"}); removed != 1 || err != nil {
+		t.Fatalf("RemoveControls = %d, %v; want 1", removed, err)
+	}
+	again := newControlScheduler(t, epoch.Add(40*time.Minute))
+	if err := again.SetStatePath(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := controlled(again, ControlPause); got != "" {
+		t.Errorf("paused after second restart = %q, want nothing", got)
+	}
+	if controls := again.Controls(); len(controls) != 1 || controls[0].Action != ControlMute {
+		t.Errorf("controls after second restart = %+v, want the Beta mute", controls)
+	}
+}
+
+func TestSetStatePathRejectsCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheduler_state.json")
+	s := newControlScheduler(t, epoch)
+	if err := s.SetStatePath(path); err != nil {
+		t.Fatalf("missing state file: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newControlScheduler(t, epoch).SetStatePath(path); err == nil {
+		t.Error("corrupt state file was accepted")
+	}
+}
@@ -0,0 +1,254 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// GlobalScope is the host label used for the global ceiling
+const GlobalScope = "*"
+
+// Limits caps concurrency and request rate. Zero values mean unlimited.
+type Limits struct {
+	MaxInFlight       int
+	RequestsPerSecond float64
+	Burst             int // Defaults to 1 when RequestsPerSecond is set
+}
+
+// GateObserver receives gate queue and wait measurements
+type GateObserver interface {
+	SetGateQueueDepth(host string, depth int)
+	SetGateInFlight(host string, inFlight int)
+	ObserveGateWait(host string, wait time.Duration)
+}
+
+// ceiling is one set of limits with its own slots and token bucket
+type ceiling struct {
+	slots    chan struct{} // nil when in-flight is unlimited
+	limiter  *rate.Limiter // nil when the rate is unlimited
+	waiting  int
+	inFlight int
+}
+
+func newCeiling(limits Limits) *ceiling {
+	c := &ceiling{}
+	if limits.MaxInFlight > 0 {
+		c.slots = make(chan struct{}, limits.MaxInFlight)
+	}
+	if limits.RequestsPerSecond > 0 {
+		burst := limits.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		c.limiter = rate.NewLimiter(rate.Limit(limits.RequestsPerSecond), burst)
+	}
+	return c
+}
+
+// Gate enforces global and per-host ceilings on probes between the
+// scheduler and the worker pool, complementing the per-endpoint token bucket.
+// The scheduler takes a task's slot before handing it to a worker, so a
+// busy host holds back its own tasks rather than parking the workers.
+type Gate struct {
+	mu       sync.Mutex
+	global   *ceiling
+	perHost  Limits
+	hosts    map[string]*ceiling
+	observer GateObserver
+}
+
+// NewGate creates a gate with the given global and per-host limits
+func NewGate(global, perHost Limits) *Gate {
+	return &Gate{
+		global:  newCeiling(global),
+		perHost: perHost,
+		hosts:   make(map[string]*ceiling),
+	}
+}
+
+// SetLimits replaces the ceilings. Probes already holding a slot release it
+// to the ceiling they acquired it from.
+func (g *Gate) SetLimits(global, perHost Limits) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.global = newCeiling(global)
+	g.perHost = perHost
+	g.hosts = make(map[string]*ceiling)
+}
+
+// SetObserver installs the receiver of queue depth and wait measurements
+func (g *Gate) SetObserver(observer GateObserver) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.observer = observer
+}
+
+// Acquire waits until a probe to host may start under both the host's and
+// the global ceiling. The host is acquired first so a probe queued behind a
+// busy payer does not hold a global slot. The returned release must be
+// called when the probe finishes.
+func (g *Gate) Acquire(ctx context.Context, host string) (func(), error) {
+	start := time.Now()
+
+	g.mu.Lock()
+	globalCeiling := g.global
+	g.mu.Unlock()
+
+	hostCeiling := g.host(host)
+	if err := g.enter(ctx, host, hostCeiling); err != nil {
+		return nil, err
+	}
+	if err := g.enter(ctx, GlobalScope, globalCeiling); err != nil {
+		g.leave(host, hostCeiling)
+		return nil, err
+	}
+
+	g.mu.Lock()
+	observer := g.observer
+	g.mu.Unlock()
+	if observer != nil {
+		observer.ObserveGateWait(host, time.Since(start))
+	}
+
+	return g.releaser(host, hostCeiling, globalCeiling), nil
+}
+
+// TryAcquire is Acquire without waiting: it reports false, holding
+// nothing, when either ceiling has no free slot or token right now
+func (g *Gate) TryAcquire(host string) (func(), bool) {
+	g.mu.Lock()
+	globalCeiling := g.global
+	g.mu.Unlock()
+
+	hostCeiling := g.host(host)
+	if !g.tryEnter(host, hostCeiling) {
+		return nil, false
+	}
+	if !g.tryEnter(GlobalScope, globalCeiling) {
+		g.leave(host, hostCeiling)
+		return nil, false
+	}
+	return g.releaser(host, hostCeiling, globalCeiling), true
+}
+
+// releaser returns a release func that frees both slots once
+func (g *Gate) releaser(host string, hostCeiling, globalCeiling *ceiling) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.leave(GlobalScope, globalCeiling)
+			g.leave(host, hostCeiling)
+		})
+	}
+}
+
+// host returns the ceiling for a host, creating it on first use
+func (g *Gate) host(host string) *ceiling {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.hosts[host]
+	if !ok {
+		c = newCeiling(g.perHost)
+		g.hosts[host] = c
+	}
+	return c
+}
+
+// enter waits for a token and a slot from one ceiling
+func (g *Gate) enter(ctx context.Context, scope string, c *ceiling) error {
+	g.adjust(scope, c, 1, 0)
+	defer g.adjust(scope, c, -1, 0)
+
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	g.adjust(scope, c, 0, 1)
+	return nil
+}
+
+// tryEnter takes a token and a slot from one ceiling if both are free
+func (g *Gate) tryEnter(scope string, c *ceiling) bool {
+	var reservation *rate.Reservation
+	if c.limiter != nil {
+		reservation = c.limiter.Reserve()
+		if !reservation.OK() || reservation.Delay() > 0 {
+			reservation.Cancel()
+			return false
+		}
+	}
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			if reservation != nil {
+				reservation.Cancel()
+			}
+			return false
+		}
+	}
+
+	g.adjust(scope, c, 0, 1)
+	return true
+}
+
+// leave frees a slot taken by enter or tryEnter
+func (g *Gate) leave(scope string, c *ceiling) {
+	if c.slots != nil {
+		<-c.slots
+	}
+	g.adjust(scope, c, 0, -1)
+}
+
+// adjust updates a ceiling's counters and reports them to the observer
+func (g *Gate) adjust(scope string, c *ceiling, waiting, inFlight int) {
+	g.mu.Lock()
+	c.waiting += waiting
+	c.inFlight += inFlight
+	depth, running, observer := c.waiting, c.inFlight, g.observer
+	g.mu.Unlock()
+
+	if observer == nil {
+		return
+	}
+	if waiting != 0 {
+		observer.SetGateQueueDepth(scope, depth)
+	}
+	if inFlight != 0 {
+		observer.SetGateInFlight(scope, running)
+	}
+}
+
+// GetStats returns the queue depth and in-flight count per scope
+func (g *Gate) GetStats() map[string]interface{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	hosts := make(map[string]interface{}, len(g.hosts))
+	for host, c := range g.hosts {
+		if c.waiting == 0 && c.inFlight == 0 {
+			continue
+		}
+		hosts[host] = map[string]int{"waiting": c.waiting, "in_flight": c.inFlight}
+	}
+
+	return map[string]interface{}{
+		"global_waiting":   g.global.waiting,
+		"global_in_flight": g.global.inFlight,
+		"busy_hosts":       hosts,
+	}
+}
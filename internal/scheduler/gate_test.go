@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+func TestGateTryAcquireHonoursCeilings(t *testing.T) {
+	gate := NewGate(Limits{MaxInFlight: 2}, Limits{MaxInFlight: 1})
+	held := make(map[string]func())
+
+	steps := []struct {
+		host    string
+		release string // Released before trying host
+		want    bool
+	}{
+		{host: "a", want: true},
+		{host: "a", want: false}, // Host ceiling
+		{host: "b", want: true},
+		{host: "c", want: false}, // Global ceiling
+		{host: "c", release: "a", want: true},
+		{host: "a", want: false}, // Global ceiling again
+		{host: "a", release: "b", want: true},
+	}
+	for i, step := range steps {
+		if step.release != "" {
+			held[step.release]()
+			delete(held, step.release)
+		}
+		release, ok := gate.TryAcquire(step.host)
+		if ok != step.want {
+			t.Fatalf("step %d: TryAcquire(%s) = %v, want %v", i, step.host, ok, step.want)
+		}
+		if ok {
+			held[step.host] = release
+		}
+	}
+
+	if inFlight := gate.GetStats()["global_in_flight"]; inFlight != 2 {
+		t.Errorf("global in flight = %v, want 2", inFlight)
+	}
+	for _, release := range held {
+		release()
+		release() // A second call is a no-op
+	}
+	if inFlight := gate.GetStats()["global_in_flight"]; inFlight != 0 {
+		t.Errorf("global in flight after release = %v, want 0", inFlight)
+	}
+}
+
+func TestGateAcquireHonoursCeilings(t *testing.T) {
+	gate := NewGate(Limits{MaxInFlight: 3}, Limits{MaxInFlight: 2})
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	peak := make(map[string]int)
+	var total, peakTotal int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 24; i++ {
+		host := []string{"a", "b", "c"}[i%3]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := gate.Acquire(context.Background(), host)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+
+			mu.Lock()
+			running[host]++
+			peak[host] = max(peak[host], running[host])
+			mu.Unlock()
+			n := atomic.AddInt32(&total, 1)
+			for {
+				p := atomic.LoadInt32(&peakTotal)
+				if n <= p || atomic.CompareAndSwapInt32(&peakTotal, p, n) {
+					break
+				}
+			}
+
+			time.Sleep(2 * time.Millisecond)
+
+			atomic.AddInt32(&total, -1)
+			mu.Lock()
+			running[host]--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if peakTotal > 3 {
+		t.Errorf("%d probes in flight at once, global ceiling is 3", peakTotal)
+	}
+	for host, n := range peak {
+		if n > 2 {
+			t.Errorf("%d probes in flight to %s at once, host ceiling is 2", n, host)
+		}
+	}
+}
+
+func TestGateCancelUnblocksWaiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		holder string // Host holding the only slot
+		waiter string
+	}{
+		{"host ceiling", "a", "a"},
+		{"global ceiling", "a", "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := NewGate(Limits{MaxInFlight: 1}, Limits{MaxInFlight: 1})
+			release, err := gate.Acquire(context.Background(), tt.holder)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				_, err := gate.Acquire(ctx, tt.waiter)
+				done <- err
+			}()
+
+			// Cancel only once the waiter is queued
+			deadline := time.Now().Add(5 * time.Second)
+			for waiting(gate, tt.waiter) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("the waiter never queued")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("Acquire error = %v, want context.Canceled", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("cancelling did not unblock the waiter")
+			}
+
+			// The cancelled waiter holds nothing once the slot frees up
+			release()
+			next, ok := gate.TryAcquire(tt.waiter)
+			if !ok {
+				t.Fatal("the cancelled waiter kept a slot")
+			}
+			next()
+		})
+	}
+}
+
+// waiting returns how many callers wait on a host's or the global ceiling
+func waiting(gate *Gate, host string) int {
+	stats := gate.GetStats()
+	n := stats["global_waiting"].(int)
+	if busy, ok := stats["busy_hosts"].(map[string]interface{})[host]; ok {
+		n += busy.(map[string]int)["waiting"]
+	}
+	return n
+}
+
+func TestDispatchDefersTasksForBusyHost(t *testing.T) {
+	h := newHarness(t, 10,
+		endpoint("a", time.Minute),
+		config.Endpoint{Type: "a2", URL: "https://a.example.com/other", Schedule: time.Minute},
+		endpoint("b", time.Minute),
+	)
+	h.s.SetGate(NewGate(Limits{}, Limits{MaxInFlight: 1}), func(endpoint config.Endpoint) string {
+		u, _ := url.Parse(endpoint.URL)
+		return u.Hostname()
+	})
+	if err := h.s.SetOverflowPolicy(OverflowRetry, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	// One of the two tasks for a.example.com waits; b is not held up
+	h.advance(time.Minute)
+	tasks := drain(h)
+	if got := types(tasks); len(got) != 2 || got[1] != "b" {
+		t.Fatalf("dispatched %v, want one of a and a2, and b", got)
+	}
+	if skipped, _ := h.observer.counts(); skipped[SkipDeferred] != 1 {
+		t.Errorf("observer saw %v skips, want one deferred", skipped)
+	}
+
+	// Still busy at the retry
+	h.advance(5 * time.Second)
+	if got := types(drain(h)); len(got) != 0 {
+		t.Errorf("dispatched %v while the host was busy", got)
+	}
+
+	for _, task := range tasks {
+		task.Release()
+	}
+	h.advance(5 * time.Second)
+	if got := types(drain(h)); len(got) != 1 || got[0][0] != 'a' {
+		t.Errorf("dispatched %v once the host was free, want the deferred task", got)
+	}
+}
+
+// drain empties the task channel without releasing the tasks' slots
+func drain(h *harness) []*Task {
+	var tasks []*Task
+	for {
+		select {
+		case task := <-h.s.GetTaskChannel():
+			tasks = append(tasks, task)
+		default:
+			return tasks
+		}
+	}
+}
+
+// types returns the sorted endpoint types of tasks
+func types(tasks []*Task) []string {
+	var types []string
+	for _, task := range tasks {
+		types = append(types, task.Endpoint.Type)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,320 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"payer-status-io/internal/config"
+)
+
+// OverflowPolicy decides what happens to a due task when the task channel
+// is full or the gate has no slot for its host
+type OverflowPolicy string
+
+const (
+	// OverflowDrop skips the run; the task waits for its next interval
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowRetry puts the task back in the heap to try again shortly
+	OverflowRetry OverflowPolicy = "retry"
+	// OverflowBlock waits for a free slot up to a deadline, then drops
+	OverflowBlock OverflowPolicy = "block"
+)
+
+const (
+	defaultOverflowWait  = 5 * time.Second
+	defaultOverdueFactor = 3.0
+)
+
+// Skip reasons reported to the Observer
+const (
+	SkipPaused      = "paused"
+	SkipRateLimited = "rate_limited"
+	SkipDeferred    = "deferred" // Channel full or host busy under the retry policy
+)
+
+// Observer receives scheduling events, typically to export them as metrics
+type Observer interface {
+	RecordTaskSkipped(payer, endpointType, reason string)
+	RecordTaskDropped(payer, endpointType string)
+	SetProbeOverdue(payer, endpointType, url string, overdue bool)
+}
+
+// taskCounters tracks per-endpoint scheduling outcomes
+type taskCounters struct {
+	since   time.Time // When the endpoint was first scheduled
+	skipped uint64
+	dropped uint64
+	overdue bool
+}
+
+// pendingDispatch is a due task that passed the pause and rate checks
+type pendingDispatch struct {
+	task        *Task
+	reservation *rate.Reservation
+}
+
+// CheckSettings reports whether Configure would accept the settings
+func CheckSettings(settings config.ServerConfig) error {
+	return checkOverflowPolicy(OverflowPolicy(settings.OverflowPolicy))
+}
+
+// checkOverflowPolicy rejects unknown policies
+func checkOverflowPolicy(policy OverflowPolicy) error {
+	switch policy {
+	case OverflowDrop, OverflowRetry, OverflowBlock:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow policy %q", policy)
+	}
+}
+
+// SetOverflowPolicy chooses how to handle a full task channel. wait is the
+// retry delay for OverflowRetry and the deadline for OverflowBlock.
+func (s *Scheduler) SetOverflowPolicy(policy OverflowPolicy, wait time.Duration) error {
+	if err := checkOverflowPolicy(policy); err != nil {
+		return err
+	}
+	if wait <= 0 {
+		wait = defaultOverflowWait
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overflowPolicy = policy
+	s.overflowWait = wait
+	return nil
+}
+
+// Configure applies the server's scheduling settings. It is safe to call
+// on every config reload.
+func (s *Scheduler) Configure(settings config.ServerConfig) error {
+	if err := s.SetOverflowPolicy(OverflowPolicy(settings.OverflowPolicy), settings.OverflowWait); err != nil {
+		return err
+	}
+	s.SetOverdueFactor(settings.OverdueFactor)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jitterPct = settings.JitterPct
+	if settings.TriggerMinGap > 0 {
+		s.triggerMinGap = settings.TriggerMinGap
+	}
+	return nil
+}
+
+// SetOverdueFactor sets how many intervals an endpoint may go without a run
+// before it is reported overdue
+func (s *Scheduler) SetOverdueFactor(factor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if factor > 1 {
+		s.overdueFactor = factor
+	}
+}
+
+// SetObserver installs the receiver of skip, drop and overdue events
+func (s *Scheduler) SetObserver(observer Observer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observer = observer
+}
+
+// SetGate makes dispatch take a slot from gate for each task, naming the
+// endpoint's host with hostname. A task whose host or the whole pool is at
+// capacity is handled like a full channel under the overflow policy.
+func (s *Scheduler) SetGate(gate *Gate, hostname func(config.Endpoint) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gate = gate
+	s.hostname = hostname
+}
+
+// offer hands a copy of task to the workers if its gate slot and room in
+// the channel are both free now. The caller must hold the scheduler lock,
+// at least for reading.
+func (s *Scheduler) offer(task *Task) (*Task, bool) {
+	// Workers receive a copy so the heap entry keeps its own schedule
+	run := *task
+	if s.gate != nil {
+		release, ok := s.gate.TryAcquire(s.hostname(task.Endpoint))
+		if !ok {
+			return &run, false
+		}
+		run.release = release
+	}
+
+	select {
+	case s.taskChan <- &run:
+		return &run, true
+	default:
+		return &run, false
+	}
+}
+
+// dispatch hands a task to the workers according to the overflow policy.
+// It is called without the scheduler lock so a blocking send does not stall
+// triggers, reloads or status requests.
+func (s *Scheduler) dispatch(ctx context.Context, task *Task, policy OverflowPolicy, wait time.Duration) bool {
+	s.mu.RLock()
+	run, sent := s.offer(task)
+	gate, hostname := s.gate, s.hostname
+	s.mu.RUnlock()
+	if sent {
+		return true
+	}
+
+	if policy != OverflowBlock {
+		run.Release()
+		return false
+	}
+
+	timer := s.clock.NewTimer(wait)
+	defer timer.Stop()
+	blockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-timer.C():
+			cancel()
+		case <-blockCtx.Done():
+		}
+	}()
+
+	// Wait for the host as well as the channel, within the same deadline
+	if gate != nil && run.release == nil {
+		release, err := gate.Acquire(blockCtx, hostname(task.Endpoint))
+		if err != nil {
+			return false
+		}
+		run.release = release
+	}
+
+	select {
+	case s.taskChan <- run:
+		return true
+	case <-blockCtx.Done():
+		run.Release()
+		return false
+	}
+}
+
+// settle records the outcome of a dispatch. Unsent tasks get their rate
+// limiter token back, and are retried shortly under OverflowRetry.
+func (s *Scheduler) settle(p pendingDispatch, sent bool, policy OverflowPolicy, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	task := p.task
+	counters := s.counters(task.Key, now)
+
+	if sent {
+		s.lastRun[task.Key] = now
+		s.setOverdue(task, counters, false)
+		s.logger.Debug("Task scheduled for execution",
+			zap.String("payer", task.Payer),
+			zap.String("type", task.Endpoint.Type))
+		return
+	}
+
+	p.reservation.CancelAt(now)
+
+	if policy == OverflowRetry {
+		counters.skipped++
+		if s.observer != nil {
+			s.observer.RecordTaskSkipped(task.Payer, task.Endpoint.Type, SkipDeferred)
+		}
+		s.logger.Warn("Workers busy, retrying task",
+			zap.String("payer", task.Payer),
+			zap.String("type", task.Endpoint.Type),
+			zap.Duration("retry_in", wait))
+
+		task.NextRun = now.Add(wait)
+		task.Interval = wait
+		heap.Init(s.heap)
+		return
+	}
+
+	counters.dropped++
+	if s.observer != nil {
+		s.observer.RecordTaskDropped(task.Payer, task.Endpoint.Type)
+	}
+	s.logger.Warn("Workers busy, dropping task",
+		zap.String("payer", task.Payer),
+		zap.String("type", task.Endpoint.Type),
+		zap.String("policy", string(policy)))
+}
+
+// recordSkip counts a run skipped before dispatch
+func (s *Scheduler) recordSkip(task *Task, reason string, now time.Time) {
+	s.counters(task.Key, now).skipped++
+	if s.observer != nil {
+		s.observer.RecordTaskSkipped(task.Payer, task.Endpoint.Type, reason)
+	}
+}
+
+// counters returns the counters for an endpoint, creating them on first use
+func (s *Scheduler) counters(key string, now time.Time) *taskCounters {
+	c, ok := s.taskCounters[key]
+	if !ok {
+		c = &taskCounters{since: now}
+		s.taskCounters[key] = c
+	}
+	return c
+}
+
+// checkOverdue flags endpoints that have not been dispatched within
+// overdueFactor times their interval. Paused endpoints, and every endpoint
+// while on standby, are never overdue.
+func (s *Scheduler) checkOverdue(now time.Time) {
+	for _, task := range *s.heap {
+		counters := s.counters(task.Key, now)
+
+		if s.standby || s.isPaused(task.Payer, task.Endpoint, now) {
+			s.setOverdue(task, counters, false)
+			continue
+		}
+
+		last := counters.since
+		if run, ok := s.lastRun[task.Key]; ok && run.After(last) {
+			last = run
+		}
+
+		expected := task.Interval
+		if interval := task.Schedule.IntervalAt(now); interval > expected {
+			expected = interval
+		}
+
+		limit := time.Duration(s.overdueFactor * float64(expected))
+		s.setOverdue(task, counters, now.Sub(last) > limit)
+	}
+}
+
+// setOverdue records an overdue transition and notifies the observer
+func (s *Scheduler) setOverdue(task *Task, counters *taskCounters, overdue bool) {
+	if counters.overdue == overdue {
+		return
+	}
+	counters.overdue = overdue
+
+	if overdue {
+		s.logger.Warn("Probe overdue",
+			zap.String("payer", task.Payer),
+			zap.String("type", task.Endpoint.Type),
+			zap.String("url", task.Endpoint.GetURL()))
+	} else {
+		s.logger.Info("Probe no longer overdue",
+			zap.String("payer", task.Payer),
+			zap.String("type", task.Endpoint.Type),
+			zap.String("url", task.Endpoint.GetURL()))
+	}
+
+	if s.observer != nil {
+		s.observer.SetProbeOverdue(task.Payer, task.Endpoint.Type, task.Endpoint.GetURL(), overdue)
+	}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+// Schedule computes when a task should next run
+type Schedule interface {
+	Next(now time.Time) time.Time
+
+	// IntervalAt returns the nominal interval in effect at now, or zero if
+	// the schedule has none
+	IntervalAt(now time.Time) time.Duration
+}
+
+// intervalSchedule runs at a fixed interval, optionally varied by schedule
+// profiles, with jitter applied to every run
+type intervalSchedule struct {
+	profiles *config.ProfileSchedule
+	jitter   func(time.Duration) time.Duration
+}
+
+// Next returns now plus the jittered interval in effect, pulled forward to
+// the start of a profile window that probes more often. Runs pulled to a
+// boundary are spread after it by the jitter of the shorter interval, so
+// endpoints sharing a profile do not all fire at once.
+func (s *intervalSchedule) Next(now time.Time) time.Time {
+	interval := s.profiles.IntervalAt(now)
+	next := now.Add(s.jitter(interval))
+
+	if boundary := s.profiles.NextBoundary(now); !boundary.IsZero() && boundary.Before(next) {
+		if shorter := s.profiles.IntervalAt(boundary); shorter < interval {
+			spread := s.jitter(shorter) - shorter
+			if spread < 0 {
+				spread = -spread
+			}
+			if pulled := boundary.Add(spread); pulled.Before(next) {
+				next = pulled
+			}
+		}
+	}
+
+	return next
+}
+
+// IntervalAt returns the interval of the profile in effect at now
+func (s *intervalSchedule) IntervalAt(now time.Time) time.Duration {
+	return s.profiles.IntervalAt(now)
+}
+
+// cronSchedule runs at the times matched by a cron expression. Jitter is not
+// applied, since cron users ask for specific wall-clock times.
+type cronSchedule struct {
+	expr *config.CronExpr
+	loc  *time.Location
+}
+
+// Next returns the next matching minute after now
+func (s *cronSchedule) Next(now time.Time) time.Time {
+	next := s.expr.Next(now.In(s.loc))
+	if next.IsZero() {
+		// Validation rejects expressions that never match, so this only
+		// guards the heap against a zero run time
+		return now.Add(15 * time.Minute)
+	}
+	return next
+}
+
+// IntervalAt returns zero, since the gaps between cron runs vary
+func (s *cronSchedule) IntervalAt(now time.Time) time.Duration {
+	return 0
+}
+
+// newSchedule builds the schedule for an endpoint. The config has already
+// been validated, so parse errors fall back to the fixed interval.
+func (s *Scheduler) newSchedule(endpoint config.Endpoint) Schedule {
+	if endpoint.IsCron() {
+		expr, err := config.ParseCron(endpoint.Cron)
+		loc, locErr := endpoint.Location()
+		if err == nil && locErr == nil {
+			return &cronSchedule{expr: expr, loc: loc}
+		}
+	}
+	return &intervalSchedule{profiles: endpoint.ProfileSchedule(), jitter: s.addJitter}
+}
@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// businessHours probes hourly, and every 5 minutes from 08:00 UTC
+var businessHours = config.Endpoint{
+	Type:     "login",
+	URL:      "https://example.com/login",
+	Schedule: time.Hour,
+	Profiles: []config.ScheduleProfile{
+		{Start: "08:00", End: "18:00", Interval: 5 * time.Minute},
+	},
+}
+
+func TestIntervalScheduleSpreadsProfileBoundary(t *testing.T) {
+	now := time.Date(2024, 1, 10, 7, 30, 0, 0, time.UTC)
+	boundary := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		jitter func(time.Duration) time.Duration
+		want   time.Time
+	}{
+		{"no jitter", func(d time.Duration) time.Duration { return d }, boundary},
+		{"late jitter", func(d time.Duration) time.Duration { return d + d/10 }, boundary.Add(30 * time.Second)},
+		{"early jitter is mirrored after the boundary", func(d time.Duration) time.Duration { return d - d/10 }, boundary.Add(30 * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := &intervalSchedule{profiles: businessHours.ProfileSchedule(), jitter: tt.jitter}
+			if got := schedule.Next(now); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalScheduleBoundaryJitterBounds(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	s.jitterPct = 0.2
+	schedule := s.newSchedule(businessHours)
+
+	now := time.Date(2024, 1, 10, 7, 30, 0, 0, time.UTC)
+	boundary := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+	limit := boundary.Add(time.Minute) // 20% of the 5 minute interval
+
+	distinct := make(map[time.Time]bool)
+	for i := 0; i < 200; i++ {
+		next := schedule.Next(now)
+		if next.Before(boundary) || next.After(limit) {
+			t.Fatalf("Next(%s) = %s, want within [%s, %s]", now, next, boundary, limit)
+		}
+		distinct[next] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("every run was pulled to %v, want them spread", distinct)
+	}
+}
+
+func TestCronScheduleIntervalAt(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	schedule := s.newSchedule(config.Endpoint{Cron: "*/10 * * * *"})
+	if _, ok := schedule.(*cronSchedule); !ok {
+		t.Fatalf("newSchedule() = %T, want *cronSchedule", schedule)
+	}
+
+	now := time.Date(2024, 1, 10, 7, 31, 0, 0, time.UTC)
+	if got, want := schedule.Next(now), time.Date(2024, 1, 10, 7, 40, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, want %s", now, got, want)
+	}
+	if got := schedule.IntervalAt(now); got != 0 {
+		t.Errorf("IntervalAt() = %s, want 0", got)
+	}
+}
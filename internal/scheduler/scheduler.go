@@ -1,8 +1,10 @@
 package scheduler
 
 import (
+	"container/heap"
 	"context"
 	"math/rand"
+	"reflect"
 	"sync"
 	"time"
 
@@ -20,8 +22,32 @@ type Scheduler struct {
 	logger    *zap.Logger
 	mu        sync.RWMutex
 	jitterPct float64 // Jitter percentage (0.1 = 10%)
+
+	lastRun       map[string]time.Time // Last dispatch per endpoint, for triggers
+	triggerMinGap time.Duration
+	stopped       bool
+
+	controls  []*Control // Runtime pauses and mutes
+	statePath string     // Where controls are persisted, empty to disable
+
+	standby bool // Another instance of the cluster runs the scheduled probes
+
+	clock Clock
+	wake  chan struct{} // Interrupts the loop's sleep when the heap changes
+
+	overflowPolicy OverflowPolicy
+	overflowWait   time.Duration // Retry delay or block deadline
+	overdueFactor  float64       // Intervals without a run before overdue
+	taskCounters   map[string]*taskCounters
+	observer       Observer
+
+	gate     *Gate                         // Global and per-host ceilings, nil for none
+	hostname func(config.Endpoint) string // The host an endpoint's gate slot is taken for
 }
 
+// idleWait bounds how long the loop sleeps when nothing is scheduled
+const idleWait = time.Minute
+
 // New creates a new scheduler
 func New(logger *zap.Logger, taskChanSize int) *Scheduler {
 	return &Scheduler{
@@ -30,70 +56,161 @@ func New(logger *zap.Logger, taskChanSize int) *Scheduler {
 		taskChan:  make(chan *Task, taskChanSize),
 		logger:    logger,
 		jitterPct: 0.1, // 10% jitter as per .windsurfrules
+
+		lastRun:       make(map[string]time.Time),
+		triggerMinGap: defaultTriggerMinGap,
+
+		clock: realClock{},
+		wake:  make(chan struct{}, 1),
+
+		overflowPolicy: OverflowDrop,
+		overflowWait:   defaultOverflowWait,
+		overdueFactor:  defaultOverdueFactor,
+		taskCounters:   make(map[string]*taskCounters),
 	}
 }
 
-// LoadConfig loads endpoints from configuration and creates tasks
+// SetClock replaces the wall clock, for deterministic scheduling in tests.
+// It must be called before LoadConfig and Start.
+func (s *Scheduler) SetClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// LoadConfig applies a configuration to the scheduler. Endpoints that are
+// unchanged since the previous load keep their next run time, rate limiter
+// and run history; only added, removed and changed endpoints are touched.
 func (s *Scheduler) LoadConfig(cfg *config.Config) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Clear existing tasks and limiters
-	s.heap = NewTaskHeap()
-	s.limiters = make(map[string]*rate.Limiter)
+	now := s.clock.Now()
 
-	now := time.Now()
-	
-	for _, payer := range cfg.Payers {
-		for _, endpoint := range payer.Endpoints {
-			// Create task
-			task := &Task{
-				Payer:    payer.Name,
-				Endpoint: endpoint,
-				NextRun:  now.Add(s.addJitter(endpoint.GetSchedule())),
-				Interval: endpoint.GetSchedule(),
-			}
-			
-			s.heap.PushTask(task)
-
-			// Create rate limiter for this endpoint
-			limiterKey := s.getLimiterKey(payer.Name, endpoint.Type)
-			interval := endpoint.GetSchedule()
-			
-			// Ensure minimum interval of 1 minute as per .windsurfrules
-			if interval < time.Minute {
-				interval = time.Minute
-			}
-			
-			s.limiters[limiterKey] = rate.NewLimiter(rate.Every(interval), 1)
+	existing := make(map[string]*Task, s.heap.Len())
+	for _, task := range *s.heap {
+		existing[task.Key] = task
+	}
+
+	tasks := make(TaskHeap, 0, len(existing))
+	limiters := make(map[string]*rate.Limiter, len(existing))
+	var added, changed, unchanged int
+
+	for _, ref := range cfg.EndpointRefs() {
+		task, ok := existing[ref.Key]
+		delete(existing, ref.Key)
+
+		if ok && reflect.DeepEqual(task.Endpoint, ref.Endpoint) {
+			unchanged++
+			limiters[ref.Key] = s.limiters[ref.Key]
+			tasks = append(tasks, task)
+			continue
+		}
+
+		// Changed endpoints restart their schedule but keep their run
+		// history. A fresh task is built because workers may still hold
+		// the old one.
+		if ok {
+			changed++
+		} else {
+			added++
+		}
+
+		task = &Task{
+			Key:      ref.Key,
+			Payer:    ref.Payer,
+			Endpoint: ref.Endpoint,
+			Schedule: s.newSchedule(ref.Endpoint),
 		}
+		task.NextRun = task.Schedule.Next(now)
+		task.Interval = task.NextRun.Sub(now)
+		limiters[ref.Key] = s.newLimiter(ref.Endpoint)
+		tasks = append(tasks, task)
 	}
 
+	// Whatever is left in existing was removed from the config
+	for key, task := range existing {
+		delete(s.lastRun, key)
+		if counters, ok := s.taskCounters[key]; ok {
+			s.setOverdue(task, counters, false)
+			delete(s.taskCounters, key)
+		}
+	}
+
+	heap.Init(&tasks)
+	s.heap = &tasks
+	s.limiters = limiters
+	s.notify()
+
 	s.logger.Info("Scheduler loaded configuration",
 		zap.Int("total_tasks", s.heap.Len()),
+		zap.Int("added", added),
+		zap.Int("removed", len(existing)),
+		zap.Int("changed", changed),
+		zap.Int("unchanged", unchanged),
 		zap.Int("rate_limiters", len(s.limiters)))
 }
 
-// Start begins the scheduling loop
+// newLimiter creates the rate limiter for an endpoint, sized for the
+// shortest interval any of its schedule profiles can produce
+func (s *Scheduler) newLimiter(endpoint config.Endpoint) *rate.Limiter {
+	interval := endpoint.MinInterval()
+
+	// Ensure minimum interval of 1 minute as per .windsurfrules
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	return rate.NewLimiter(rate.Every(interval), 1)
+}
+
+// Start begins the scheduling loop. It sleeps until the task at the head of
+// the heap is due, and is woken early when a reload changes the heap.
 func (s *Scheduler) Start(ctx context.Context) error {
 	s.logger.Info("Starting scheduler")
-	
-	ticker := time.NewTicker(100 * time.Millisecond) // Check every 100ms
-	defer ticker.Stop()
 
 	for {
+		timer := s.clock.NewTimer(s.untilNext())
+
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			s.logger.Info("Scheduler stopping due to context cancellation")
+			s.mu.Lock()
+			s.stopped = true
 			close(s.taskChan)
+			s.mu.Unlock()
 			return ctx.Err()
-			
-		case <-ticker.C:
+
+		case <-timer.C():
 			s.processNextTasks(ctx)
+
+		case <-s.wake:
+			timer.Stop()
 		}
 	}
 }
 
+// untilNext returns how long to sleep before the next task is due
+func (s *Scheduler) untilNext() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	task := s.heap.PeekTask()
+	if task == nil {
+		return idleWait
+	}
+	return task.NextRun.Sub(s.clock.Now())
+}
+
+// notify wakes the scheduling loop without blocking
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
 // GetTaskChannel returns the channel for receiving scheduled tasks
 func (s *Scheduler) GetTaskChannel() <-chan *Task {
 	return s.taskChan
@@ -101,46 +218,74 @@ func (s *Scheduler) GetTaskChannel() <-chan *Task {
 
 // processNextTasks checks for and processes ready tasks
 func (s *Scheduler) processNextTasks(ctx context.Context) {
+	ready, policy, wait := s.collectDueTasks()
+
+	for _, p := range ready {
+		sent := s.dispatch(ctx, p.task, policy, wait)
+		s.settle(p, sent, policy, wait)
+	}
+}
+
+// collectDueTasks pops every due task, reschedules it and returns those
+// that are neither paused nor rate limited, each holding a limiter token
+func (s *Scheduler) collectDueTasks() ([]pendingDispatch, OverflowPolicy, time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
-	
+	now := s.clock.Now()
+	s.pruneControls(now)
+	s.checkOverdue(now)
+
+	var ready []pendingDispatch
 	for {
 		task := s.heap.PeekTask()
 		if task == nil || task.NextRun.After(now) {
 			break // No more ready tasks
 		}
 
-		// Remove task from heap
+		// Remove task from heap and reschedule it for its next run
 		task = s.heap.PopTask()
-		
+		task.NextRun = task.Schedule.Next(now)
+		task.Interval = task.NextRun.Sub(now)
+		s.heap.PushTask(task)
+
+		// Standby instances keep the schedule but leave probing to the leader
+		if s.standby {
+			continue
+		}
+
+		// Paused endpoints keep their place in the heap but are not probed
+		if s.isPaused(task.Payer, task.Endpoint, now) {
+			s.logger.Debug("Endpoint paused, skipping task",
+				zap.String("payer", task.Payer),
+				zap.String("type", task.Endpoint.Type))
+			s.recordSkip(task, SkipPaused, now)
+			continue
+		}
+
 		// Check rate limiter
-		limiterKey := s.getLimiterKey(task.Payer, task.Endpoint.Type)
-		limiter, exists := s.limiters[limiterKey]
-		
-		if exists && limiter.Allow() {
-			// Send task to workers (non-blocking)
-			select {
-			case s.taskChan <- task:
-				s.logger.Debug("Task scheduled for execution",
-					zap.String("payer", task.Payer),
-					zap.String("type", task.Endpoint.Type))
-			default:
-				s.logger.Warn("Task channel full, dropping task",
-					zap.String("payer", task.Payer),
-					zap.String("type", task.Endpoint.Type))
-			}
-		} else if !exists {
+		limiter, exists := s.limiters[task.Key]
+		if !exists {
 			s.logger.Error("No rate limiter found for task",
 				zap.String("payer", task.Payer),
 				zap.String("type", task.Endpoint.Type))
+			continue
 		}
 
-		// Reschedule task for next run
-		task.NextRun = now.Add(s.addJitter(task.Interval))
-		s.heap.PushTask(task)
+		reservation := limiter.ReserveN(now, 1)
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			reservation.CancelAt(now)
+			s.logger.Debug("Endpoint rate limited, skipping task",
+				zap.String("payer", task.Payer),
+				zap.String("type", task.Endpoint.Type))
+			s.recordSkip(task, SkipRateLimited, now)
+			continue
+		}
+
+		ready = append(ready, pendingDispatch{task: task, reservation: reservation})
 	}
+
+	return ready, s.overflowPolicy, s.overflowWait
 }
 
 // addJitter adds random jitter to prevent thundering herd
@@ -154,20 +299,30 @@ func (s *Scheduler) addJitter(duration time.Duration) time.Duration {
 	return duration + time.Duration(jitter)
 }
 
-// getLimiterKey creates a unique key for rate limiters
-func (s *Scheduler) getLimiterKey(payer, endpointType string) string {
-	return payer + ":" + endpointType
-}
-
 // GetStats returns scheduler statistics
 func (s *Scheduler) GetStats() map[string]interface{} {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
+	var skipped, dropped uint64
+	overdue := 0
+	for _, c := range s.taskCounters {
+		skipped += c.skipped
+		dropped += c.dropped
+		if c.overdue {
+			overdue++
+		}
+	}
+
 	return map[string]interface{}{
 		"total_tasks":     s.heap.Len(),
 		"rate_limiters":   len(s.limiters),
 		"task_chan_size":  len(s.taskChan),
 		"task_chan_cap":   cap(s.taskChan),
+		"overflow_policy": string(s.overflowPolicy),
+		"skipped_total":   skipped,
+		"dropped_total":   dropped,
+		"overdue_tasks":   overdue,
+		"standby":         s.standby,
 	}
 }
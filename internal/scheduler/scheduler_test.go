@@ -0,0 +1,630 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+var epoch = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+// recorder is an Observer that keeps what it is told
+type recorder struct {
+	mu      sync.Mutex
+	skipped map[string]int // By reason
+	dropped int
+	overdue map[string]bool // By endpoint type
+	changes []string        // Overdue transitions, as "type=overdue"
+}
+
+func newRecorder() *recorder {
+	return &recorder{skipped: make(map[string]int), overdue: make(map[string]bool)}
+}
+
+func (r *recorder) RecordTaskSkipped(payer, endpointType, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped[reason]++
+}
+
+func (r *recorder) RecordTaskDropped(payer, endpointType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped++
+}
+
+func (r *recorder) SetProbeOverdue(payer, endpointType, url string, overdue bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overdue[endpointType] = overdue
+	r.changes = append(r.changes, fmt.Sprintf("%s=%v", endpointType, overdue))
+}
+
+func (r *recorder) counts() (map[string]int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	skipped := make(map[string]int, len(r.skipped))
+	for reason, n := range r.skipped {
+		skipped[reason] = n
+	}
+	return skipped, r.dropped
+}
+
+func (r *recorder) isOverdue(endpointType string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.overdue[endpointType]
+}
+
+func (r *recorder) overdueChanges() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.changes...)
+}
+
+// harness runs a scheduler on a fake clock without jitter
+type harness struct {
+	t        *testing.T
+	s        *Scheduler
+	clock    *FakeClock
+	observer *recorder
+}
+
+func newHarness(t *testing.T, channelSize int, endpoints ...config.Endpoint) *harness {
+	t.Helper()
+	h := &harness{
+		t:        t,
+		s:        New(zap.NewNop(), channelSize),
+		clock:    NewFakeClock(epoch),
+		observer: newRecorder(),
+	}
+	h.s.jitterPct = 0
+	h.s.SetClock(h.clock)
+	h.s.SetObserver(h.observer)
+	h.s.LoadConfig(&config.Config{Payers: []config.Payer{{Name: "Acme", Endpoints: endpoints}}})
+	return h
+}
+
+// start runs the loop until the test ends and waits for it to sleep
+func (h *harness) start() {
+	// Setup may have left a wake-up pending; the loop reads the heap anyway
+	select {
+	case <-h.s.wake:
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.s.Start(ctx)
+	}()
+	h.t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	h.waitTimers(1)
+}
+
+// waitTimers waits until the loop, or a blocked dispatch, holds n timers
+func (h *harness) waitTimers(n int) {
+	h.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for h.clock.Timers() != n {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %d pending timers, have %d", n, h.clock.Timers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitSettled waits until a dispatch of the endpoint at the given time has
+// been recorded
+func (h *harness) waitSettled(endpointType string, at time.Time) {
+	h.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if last := h.status(endpointType).LastRun; last != nil && last.Equal(at) {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s to run at %s", endpointType, at)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// advance moves the clock and waits for the loop to dispatch what is due
+// and go back to sleep
+func (h *harness) advance(d time.Duration) {
+	h.t.Helper()
+	h.clock.Advance(d)
+	h.waitTimers(1)
+}
+
+// received drains the task channel, returning the endpoint types in order
+func (h *harness) received() []string {
+	var types []string
+	for {
+		select {
+		case task := <-h.s.GetTaskChannel():
+			types = append(types, task.Endpoint.Type)
+		default:
+			return types
+		}
+	}
+}
+
+// status returns the status of the endpoint of the given type
+func (h *harness) status(endpointType string) EndpointStatus {
+	h.t.Helper()
+	for _, status := range h.s.Status() {
+		if status.Type == endpointType {
+			return status
+		}
+	}
+	h.t.Fatalf("no endpoint of type %s", endpointType)
+	return EndpointStatus{}
+}
+
+func endpoint(endpointType string, schedule time.Duration) config.Endpoint {
+	return config.Endpoint{Type: endpointType, URL: "https://" + endpointType + ".example.com/", Schedule: schedule}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTaskHeapOrdering(t *testing.T) {
+	h := NewTaskHeap()
+	for _, offset := range []int{5, 1, 4, 2, 3} {
+		h.PushTask(&Task{Key: string(rune('0' + offset)), NextRun: epoch.Add(time.Duration(offset) * time.Minute)})
+	}
+
+	if peek := h.PeekTask(); peek.Key != "1" {
+		t.Errorf("PeekTask() = %s, want 1", peek.Key)
+	}
+	var order string
+	for task := h.PopTask(); task != nil; task = h.PopTask() {
+		order += task.Key
+	}
+	if order != "12345" {
+		t.Errorf("pop order = %s, want 12345", order)
+	}
+	if h.PeekTask() != nil {
+		t.Error("PeekTask() on an empty heap is not nil")
+	}
+}
+
+func TestSchedulerDispatchesInOrder(t *testing.T) {
+	h := newHarness(t, 10,
+		endpoint("slow", 3*time.Minute),
+		endpoint("fast", time.Minute),
+		endpoint("medium", 2*time.Minute),
+	)
+	h.start()
+
+	steps := []struct {
+		advance time.Duration
+		want    []string
+	}{
+		{30 * time.Second, nil},
+		{30 * time.Second, []string{"fast"}},
+		{time.Minute, []string{"fast", "medium"}},
+		{time.Minute, []string{"fast", "slow"}},
+		{time.Minute, []string{"fast", "medium"}},
+	}
+	for i, step := range steps {
+		h.advance(step.advance)
+		got := h.received()
+		// Tasks due at the same instant have no defined order
+		if len(got) == 2 && got[0] != "fast" {
+			got[0], got[1] = got[1], got[0]
+		}
+		if !equal(got, step.want) {
+			t.Errorf("step %d: dispatched %v, want %v", i, got, step.want)
+		}
+	}
+
+	if next := h.status("slow").NextRun; !next.Equal(epoch.Add(6 * time.Minute)) {
+		t.Errorf("slow NextRun = %s, want %s", next, epoch.Add(6*time.Minute))
+	}
+}
+
+func TestSchedulerSleepsUntilNextTask(t *testing.T) {
+	h := newHarness(t, 10, endpoint("a", 5*time.Minute))
+	h.start()
+
+	h.advance(5*time.Minute - time.Second)
+	if got := h.received(); len(got) != 0 {
+		t.Fatalf("dispatched %v a second early", got)
+	}
+	h.advance(time.Second)
+	if got := h.received(); !equal(got, []string{"a"}) {
+		t.Fatalf("dispatched %v when due, want [a]", got)
+	}
+	if last := h.status("a").LastRun; last == nil || !last.Equal(epoch.Add(5*time.Minute)) {
+		t.Errorf("LastRun = %v, want %s", last, epoch.Add(5*time.Minute))
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	interval := 10 * time.Minute
+
+	for _, pct := range []float64{0.1, 0.25, 0.5} {
+		s.jitterPct = pct
+		low := interval - time.Duration(float64(interval)*pct)
+		high := interval + time.Duration(float64(interval)*pct)
+
+		var below, above bool
+		for i := 0; i < 1000; i++ {
+			got := s.addJitter(interval)
+			if got < low || got > high {
+				t.Fatalf("jitter %.2f: addJitter(%s) = %s, want within [%s, %s]", pct, interval, got, low, high)
+			}
+			below = below || got < interval
+			above = above || got > interval
+		}
+		if !below || !above {
+			t.Errorf("jitter %.2f never varied in both directions", pct)
+		}
+	}
+
+	s.jitterPct = 0
+	if got := s.addJitter(interval); got != interval {
+		t.Errorf("addJitter without jitter = %s, want %s", got, interval)
+	}
+}
+
+func TestLoadConfigJittersFirstRun(t *testing.T) {
+	s := New(zap.NewNop(), 1)
+	s.SetClock(NewFakeClock(epoch))
+	s.jitterPct = 0.1
+
+	endpoints := make([]config.Endpoint, 50)
+	for i := range endpoints {
+		endpoints[i] = config.Endpoint{Type: "login", URL: "https://example.com/" + string(rune('a'+i%26)) + string(rune('a'+i/26)), Schedule: 10 * time.Minute}
+	}
+	s.LoadConfig(&config.Config{Payers: []config.Payer{{Name: "Acme", Endpoints: endpoints}}})
+
+	distinct := make(map[time.Time]bool)
+	for _, status := range s.Status() {
+		if status.NextRun.Before(epoch.Add(9*time.Minute)) || status.NextRun.After(epoch.Add(11*time.Minute)) {
+			t.Errorf("%s NextRun = %s, want within 10m ± 10%%", status.URL, status.NextRun)
+		}
+		distinct[status.NextRun] = true
+	}
+	if len(distinct) < 2 {
+		t.Error("every endpoint got the same first run")
+	}
+}
+
+func TestOverflowDrop(t *testing.T) {
+	h := newHarness(t, 1, endpoint("a", time.Minute), endpoint("b", time.Minute))
+	if err := h.s.SetOverflowPolicy(OverflowDrop, 0); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	h.advance(time.Minute)
+	if got := h.received(); len(got) != 1 {
+		t.Fatalf("dispatched %v, want one task", got)
+	}
+	_, dropped := h.observer.counts()
+	if dropped != 1 {
+		t.Errorf("observer saw %d drops, want 1", dropped)
+	}
+	if total := h.status("a").Dropped + h.status("b").Dropped; total != 1 {
+		t.Errorf("status counts %d drops, want 1", total)
+	}
+
+	// The dropped task waits for its next interval rather than retrying
+	h.advance(59 * time.Second)
+	if got := h.received(); len(got) != 0 {
+		t.Errorf("dispatched %v before the next interval", got)
+	}
+}
+
+func TestOverflowRetry(t *testing.T) {
+	h := newHarness(t, 1, endpoint("a", time.Minute), endpoint("b", time.Minute))
+	if err := h.s.SetOverflowPolicy(OverflowRetry, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	h.advance(time.Minute)
+	first := h.received()
+	if len(first) != 1 {
+		t.Fatalf("dispatched %v, want one task", first)
+	}
+	deferred := "a"
+	if first[0] == "a" {
+		deferred = "b"
+	}
+
+	skipped, dropped := h.observer.counts()
+	if skipped[SkipDeferred] != 1 || dropped != 0 {
+		t.Errorf("observer saw %v skips and %d drops, want one deferred skip", skipped, dropped)
+	}
+	status := h.status(deferred)
+	if status.Skipped != 1 || !status.NextRun.Equal(epoch.Add(time.Minute+5*time.Second)) {
+		t.Errorf("deferred task skipped %d, next run %s; want 1 and %s", status.Skipped, status.NextRun, epoch.Add(time.Minute+5*time.Second))
+	}
+
+	// The retry gets its rate limiter token back
+	h.advance(5 * time.Second)
+	if got := h.received(); !equal(got, []string{deferred}) {
+		t.Errorf("retry dispatched %v, want [%s]", got, deferred)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	h := newHarness(t, 1, endpoint("a", time.Minute), endpoint("b", time.Minute))
+	if err := h.s.SetOverflowPolicy(OverflowBlock, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	// The second task blocks on its deadline timer until a slot frees up
+	h.clock.Advance(time.Minute)
+	h.waitTimers(1)
+	<-h.s.GetTaskChannel()
+	select {
+	case <-h.s.GetTaskChannel():
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked task was not delivered once a slot freed up")
+	}
+	h.waitSettled("a", epoch.Add(time.Minute))
+	h.waitSettled("b", epoch.Add(time.Minute))
+	h.waitTimers(1)
+	if _, dropped := h.observer.counts(); dropped != 0 {
+		t.Errorf("observer saw %d drops, want 0", dropped)
+	}
+
+	// Nobody reads this time, so the second task is dropped at the deadline
+	h.clock.Advance(time.Minute)
+	h.waitTimers(1)
+	h.clock.Advance(10 * time.Second)
+	h.waitTimers(1)
+	if got := h.received(); len(got) != 1 {
+		t.Fatalf("dispatched %v, want one task", got)
+	}
+	if _, dropped := h.observer.counts(); dropped != 1 {
+		t.Errorf("observer saw %d drops, want 1", dropped)
+	}
+}
+
+func TestSkipAccounting(t *testing.T) {
+	h := newHarness(t, 10, endpoint("paused", time.Minute), endpoint("limited", time.Minute))
+	if _, err := h.s.AddControl(ControlPause, Selector{Type: "paused"}, time.Time{}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	// Spend the limiter token of "limited" as a run just before would
+	h.s.mu.Lock()
+	for _, task := range *h.s.heap {
+		if task.Endpoint.Type == "limited" {
+			h.s.limiters[task.Key].ReserveN(epoch.Add(30*time.Second), 1)
+		}
+	}
+	h.s.mu.Unlock()
+
+	h.advance(time.Minute)
+	if got := h.received(); len(got) != 0 {
+		t.Errorf("dispatched %v, want nothing", got)
+	}
+
+	skipped, _ := h.observer.counts()
+	if skipped[SkipPaused] != 1 || skipped[SkipRateLimited] != 1 {
+		t.Errorf("observer saw skips %v, want one paused and one rate_limited", skipped)
+	}
+	if got := h.status("paused").Skipped; got != 1 {
+		t.Errorf("paused endpoint skipped %d, want 1", got)
+	}
+	if got := h.status("limited").Skipped; got != 1 {
+		t.Errorf("limited endpoint skipped %d, want 1", got)
+	}
+
+	// Both keep their schedule
+	h.advance(time.Minute)
+	if got := h.received(); !equal(got, []string{"limited"}) {
+		t.Errorf("dispatched %v, want [limited]", got)
+	}
+	if got := h.status("paused").Skipped; got != 2 {
+		t.Errorf("paused endpoint skipped %d, want 2", got)
+	}
+}
+
+func TestOverdueAccounting(t *testing.T) {
+	h := newHarness(t, 1, endpoint("a", time.Minute), endpoint("paused", time.Minute))
+	if _, err := h.s.AddControl(ControlPause, Selector{Type: "paused"}, time.Time{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	// The first run fills the channel; nobody reads it, so later runs drop
+	h.advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		h.advance(time.Minute)
+	}
+	// Three intervals since the last run is not yet more than three
+	if h.status("a").Overdue || h.observer.isOverdue("a") {
+		t.Fatal("overdue after exactly three intervals")
+	}
+
+	h.advance(time.Minute)
+	if !h.status("a").Overdue || !h.observer.isOverdue("a") {
+		t.Fatal("not overdue after four intervals without a run")
+	}
+	if h.status("paused").Overdue {
+		t.Error("paused endpoint reported overdue")
+	}
+	if got := h.status("a").Dropped; got != 4 {
+		t.Errorf("dropped %d runs, want 4", got)
+	}
+
+	// A run clears it
+	h.received()
+	h.advance(time.Minute)
+	if h.status("a").Overdue || h.observer.isOverdue("a") {
+		t.Error("still overdue after a run")
+	}
+}
+
+func TestOverdueClears(t *testing.T) {
+	tests := []struct {
+		name  string
+		clear func(t *testing.T, h *harness)
+	}{
+		{"after the next run", func(t *testing.T, h *harness) {
+			h.received()
+			h.advance(time.Minute)
+			if got := h.status("a").LastRun; got == nil || !got.Equal(h.clock.Now()) {
+				t.Errorf("last run %v, want %s", got, h.clock.Now())
+			}
+		}},
+		{"when paused", func(t *testing.T, h *harness) {
+			if _, err := h.s.AddControl(ControlPause, Selector{Type: "a"}, time.Time{}, ""); err != nil {
+				t.Fatal(err)
+			}
+			h.advance(time.Minute)
+		}},
+		{"on standby", func(t *testing.T, h *harness) {
+			h.s.SetStandby(true)
+			h.advance(time.Minute)
+		}},
+		{"when removed from the config", func(t *testing.T, h *harness) {
+			h.s.LoadConfig(&config.Config{Payers: []config.Payer{{Name: "Acme", Endpoints: []config.Endpoint{endpoint("b", time.Minute)}}}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, 1, endpoint("a", time.Minute), endpoint("b", time.Hour))
+			h.start()
+
+			// Nobody reads the channel, so runs drop until a is overdue
+			for i := 0; i < 5; i++ {
+				h.advance(time.Minute)
+			}
+			if !h.observer.isOverdue("a") {
+				t.Fatal("not overdue after five intervals without a run")
+			}
+
+			tt.clear(t, h)
+			if h.observer.isOverdue("a") {
+				t.Error("overdue metric not cleared")
+			}
+			if got := h.observer.overdueChanges(); !equal(got, []string{"a=true", "a=false"}) {
+				t.Errorf("overdue changes %v, want [a=true a=false]", got)
+			}
+			for _, status := range h.s.Status() {
+				if status.Overdue {
+					t.Errorf("%s still reported overdue", status.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestStandbyIsNeverOverdue(t *testing.T) {
+	h := newHarness(t, 10, endpoint("a", time.Minute))
+	h.s.SetStandby(true)
+	h.start()
+
+	for i := 0; i < 5; i++ {
+		h.advance(time.Minute)
+	}
+	if got := h.received(); len(got) != 0 {
+		t.Errorf("standby dispatched %v", got)
+	}
+	if h.status("a").Overdue {
+		t.Error("standby endpoint reported overdue")
+	}
+
+	h.s.SetStandby(false)
+	h.advance(time.Minute)
+	if got := h.received(); !equal(got, []string{"a"}) {
+		t.Errorf("dispatched %v after standby, want [a]", got)
+	}
+}
+
+func TestLoadConfigKeepsUnchangedSchedules(t *testing.T) {
+	h := newHarness(t, 10,
+		endpoint("kept", 10*time.Minute),
+		endpoint("changed", 10*time.Minute),
+		endpoint("removed", 10*time.Minute),
+	)
+	h.start()
+
+	h.advance(4 * time.Minute)
+	changed := endpoint("changed", 7*time.Minute)
+	changed.Timeout = 5 * time.Second
+	h.s.LoadConfig(&config.Config{Payers: []config.Payer{{Name: "Acme", Endpoints: []config.Endpoint{
+		endpoint("kept", 10*time.Minute),
+		changed,
+		endpoint("added", 3*time.Minute),
+	}}}})
+
+	wantNext := map[string]time.Time{
+		"kept":    epoch.Add(10 * time.Minute), // From the first load
+		"changed": epoch.Add(11 * time.Minute), // Restarted at the reload
+		"added":   epoch.Add(7 * time.Minute),
+	}
+	statuses := h.s.Status()
+	if len(statuses) != len(wantNext) {
+		t.Errorf("%d endpoints after the reload, want %d", len(statuses), len(wantNext))
+	}
+	for _, status := range statuses {
+		if want, ok := wantNext[status.Type]; !ok || !status.NextRun.Equal(want) {
+			t.Errorf("%s NextRun = %s, want %s", status.Type, status.NextRun, want)
+		}
+	}
+
+	// "removed" would have run at 10m and 20m
+	steps := []struct {
+		at   time.Duration
+		want []string
+	}{
+		{7 * time.Minute, []string{"added"}},
+		{10 * time.Minute, []string{"added", "kept"}},
+		{11 * time.Minute, []string{"changed"}},
+		{13 * time.Minute, []string{"added"}},
+		{16 * time.Minute, []string{"added"}},
+		{18 * time.Minute, []string{"changed"}},
+		{19 * time.Minute, []string{"added"}},
+		{20 * time.Minute, []string{"kept"}},
+	}
+	for i, step := range steps {
+		// The loop may still be waking for the reload, so wait for each
+		// dispatch rather than for the loop to sleep
+		at := epoch.Add(step.at)
+		h.clock.Advance(at.Sub(h.clock.Now()))
+		for _, endpointType := range step.want {
+			h.waitSettled(endpointType, at)
+		}
+		h.waitTimers(1)
+
+		got := h.received()
+		sort.Strings(got)
+		if !equal(got, step.want) {
+			t.Errorf("step %d: dispatched %v, want %v", i, got, step.want)
+		}
+	}
+}
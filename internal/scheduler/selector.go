@@ -0,0 +1,56 @@
+package scheduler
+
+import "payer-status-io/internal/config"
+
+// Selector picks endpoints by payer, type, URL and labels. Empty fields
+// match everything; every label given must match.
+type Selector struct {
+	Payer  string            `json:"payer,omitempty"`
+	Type   string            `json:"type,omitempty"`
+	URL    string            `json:"url,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// IsEmpty reports whether the selector matches every endpoint
+func (s Selector) IsEmpty() bool {
+	return s.Payer == "" && s.Type == "" && s.URL == "" && len(s.Labels) == 0
+}
+
+// Matches reports whether the endpoint is selected
+func (s Selector) Matches(payer string, endpoint config.Endpoint) bool {
+	if s.Payer != "" && s.Payer != payer {
+		return false
+	}
+	if s.Type != "" && s.Type != endpoint.Type {
+		return false
+	}
+	if s.URL != "" && s.URL != endpoint.URL {
+		return false
+	}
+	return MatchLabels(s.Labels, endpoint.Labels)
+}
+
+// Covers reports whether s matches every endpoint other can match: each
+// criterion of s is also a criterion of other
+func (s Selector) Covers(other Selector) bool {
+	if s.Payer != "" && s.Payer != other.Payer {
+		return false
+	}
+	if s.Type != "" && s.Type != other.Type {
+		return false
+	}
+	if s.URL != "" && s.URL != other.URL {
+		return false
+	}
+	return MatchLabels(s.Labels, other.Labels)
+}
+
+// MatchLabels reports whether labels has every key of want with the same value
+func MatchLabels(want, labels map[string]string) bool {
+	for key, value := range want {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
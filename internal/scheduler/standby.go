@@ -0,0 +1,30 @@
+package scheduler
+
+// SetStandby stops or resumes scheduled probes, for an instance that is
+// not the leader of its cluster. On-demand triggers still run. Leaving
+// standby restarts the overdue clock, as if the endpoints were new.
+func (s *Scheduler) SetStandby(standby bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.standby == standby {
+		return
+	}
+	s.standby = standby
+
+	if standby {
+		s.logger.Info("Standing by, scheduled probes are left to the cluster leader")
+		return
+	}
+	now := s.clock.Now()
+	for _, counters := range s.taskCounters {
+		counters.since = now
+	}
+	s.logger.Info("Running scheduled probes")
+}
+
+// Standby reports whether scheduled probes are left to another instance
+func (s *Scheduler) Standby() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.standby
+}
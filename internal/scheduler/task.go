@@ -9,10 +9,37 @@ import (
 
 // Task represents a scheduled probe task
 type Task struct {
+	Key      string // Unique per endpoint, stable across config reloads
 	Payer    string
 	Endpoint config.Endpoint
 	NextRun  time.Time
-	Interval time.Duration
+	Interval time.Duration // Gap between the last run and NextRun
+	Schedule Schedule
+
+	// CorrelationID is set on tasks dispatched by an on-demand trigger
+	CorrelationID string
+	results       chan<- *config.ProbeResult
+	release       func() // Frees the gate slot taken at dispatch
+}
+
+// Release frees the gate slot the scheduler took for the task. Workers
+// call it when the probe finishes; it is safe to call more than once.
+func (t *Task) Release() {
+	if t.release != nil {
+		t.release()
+	}
+}
+
+// Complete hands the probe result back to the trigger that dispatched the
+// task, if any. It never blocks.
+func (t *Task) Complete(result *config.ProbeResult) {
+	if t.results == nil {
+		return
+	}
+	select {
+	case t.results <- result:
+	default:
+	}
 }
 
 // TaskHeap implements heap.Interface for Task scheduling
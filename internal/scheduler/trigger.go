@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+)
+
+// defaultTriggerMinGap is the minimum time between two runs of the same
+// endpoint when one of them is an on-demand trigger
+const defaultTriggerMinGap = 30 * time.Second
+
+var (
+	// ErrNoMatch is returned when a trigger selects no endpoints
+	ErrNoMatch = errors.New("no endpoints match the trigger request")
+	// ErrEmptySelector is returned when a trigger names no payer, type or URL
+	ErrEmptySelector = errors.New("trigger request must set payer, type or url")
+)
+
+// TriggerRequest selects endpoints to probe immediately. At least one
+// selector field is required.
+type TriggerRequest struct {
+	Selector
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// ThrottledEndpoint is an endpoint skipped by a trigger because it ran too recently
+type ThrottledEndpoint struct {
+	Payer      string        `json:"payer"`
+	Type       string        `json:"type"`
+	URL        string        `json:"url"`
+	RetryAfter time.Duration `json:"-"`
+
+	RetryAfterMS int64 `json:"retry_after_ms"`
+}
+
+// Trigger describes an on-demand probe that has been dispatched to workers
+type Trigger struct {
+	CorrelationID string              `json:"correlation_id"`
+	Dispatched    int                 `json:"dispatched"`
+	Throttled     []ThrottledEndpoint `json:"throttled,omitempty"`
+
+	// Results receives one ProbeResult per dispatched endpoint
+	Results <-chan *config.ProbeResult `json:"-"`
+}
+
+// RetryAfter returns how long until the first throttled endpoint may be
+// probed again, or 0 if none was throttled
+func (t *Trigger) RetryAfter() time.Duration {
+	var retryAfter time.Duration
+	for i, throttled := range t.Throttled {
+		if i == 0 || throttled.RetryAfter < retryAfter {
+			retryAfter = throttled.RetryAfter
+		}
+	}
+	return retryAfter
+}
+
+// Trigger dispatches the selected endpoints to the worker pool immediately,
+// ahead of anything waiting in the heap. Endpoints that ran within the
+// minimum gap are reported as throttled instead of being probed again.
+func (s *Scheduler) Trigger(req TriggerRequest) (*Trigger, error) {
+	if req.IsEmpty() {
+		return nil, ErrEmptySelector
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = newCorrelationID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil, fmt.Errorf("scheduler is stopped")
+	}
+
+	var selected []*Task
+	for _, task := range *s.heap {
+		if req.Matches(task.Payer, task.Endpoint) {
+			selected = append(selected, task)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	now := s.clock.Now()
+	results := make(chan *config.ProbeResult, len(selected))
+	trigger := &Trigger{
+		CorrelationID: req.CorrelationID,
+		Results:       results,
+	}
+
+	for _, task := range selected {
+		key := task.Key
+		if last, ok := s.lastRun[key]; ok && now.Sub(last) < s.triggerMinGap {
+			trigger.Throttled = append(trigger.Throttled, ThrottledEndpoint{
+				Payer:      task.Payer,
+				Type:       task.Endpoint.Type,
+				URL:        task.Endpoint.URL,
+				RetryAfter: s.triggerMinGap - now.Sub(last),
+
+				RetryAfterMS: (s.triggerMinGap - now.Sub(last)).Milliseconds(),
+			})
+			continue
+		}
+
+		request := *task
+		request.NextRun = now
+		request.CorrelationID = req.CorrelationID
+		request.results = results
+
+		if run, sent := s.offer(&request); sent {
+			s.lastRun[key] = now
+			trigger.Dispatched++
+		} else {
+			run.Release()
+			if trigger.Dispatched == 0 {
+				return nil, fmt.Errorf("workers busy")
+			}
+			s.counters(task.Key, now).dropped++
+			if s.observer != nil {
+				s.observer.RecordTaskDropped(task.Payer, task.Endpoint.Type)
+			}
+			s.logger.Warn("Workers busy, dropping triggered probe",
+				zap.String("payer", task.Payer),
+				zap.String("type", task.Endpoint.Type))
+		}
+	}
+
+	// Triggered tasks bypass the heap, but the loop re-reads its head so
+	// the next sleep is computed from current state
+	s.notify()
+
+	s.logger.Info("On-demand probe triggered",
+		zap.String("correlation_id", req.CorrelationID),
+		zap.String("payer", req.Payer),
+		zap.String("type", req.Type),
+		zap.Int("dispatched", trigger.Dispatched),
+		zap.Int("throttled", len(trigger.Throttled)))
+
+	return trigger, nil
+}
+
+// newCorrelationID returns a random identifier for a trigger
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"payer-status-io/internal/config"
+)
+
+func TestTriggerThrottlesWithinMinGap(t *testing.T) {
+	h := newHarness(t, 10, endpoint("a", time.Hour), endpoint("b", time.Hour))
+
+	trigger, err := h.s.Trigger(TriggerRequest{Selector: Selector{Type: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Dispatched != 1 || len(trigger.Throttled) != 0 {
+		t.Fatalf("first trigger dispatched %d, throttled %v; want 1 and none", trigger.Dispatched, trigger.Throttled)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(trigger.CorrelationID) {
+		t.Errorf("generated correlation ID %q, want 16 hex digits", trigger.CorrelationID)
+	}
+	if got := h.received(); !equal(got, []string{"a"}) {
+		t.Errorf("dispatched %v, want [a]", got)
+	}
+
+	// Within the gap "a" is throttled, while "b" has never run
+	h.clock.Advance(10 * time.Second)
+	trigger, err = h.s.Trigger(TriggerRequest{Selector: Selector{Payer: "Acme"}, CorrelationID: "mine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.CorrelationID != "mine" || trigger.Dispatched != 1 {
+		t.Errorf("trigger %q dispatched %d, want mine and 1", trigger.CorrelationID, trigger.Dispatched)
+	}
+	if len(trigger.Throttled) != 1 || trigger.Throttled[0].Type != "a" || trigger.RetryAfter() != 20*time.Second {
+		t.Errorf("throttled %+v, retry after %s; want a, 20s", trigger.Throttled, trigger.RetryAfter())
+	}
+	if got := h.received(); !equal(got, []string{"b"}) {
+		t.Errorf("dispatched %v, want [b]", got)
+	}
+
+	trigger, err = h.s.Trigger(TriggerRequest{Selector: Selector{Type: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Dispatched != 0 || trigger.RetryAfter() != 20*time.Second {
+		t.Errorf("trigger inside the gap dispatched %d, retry after %s; want 0 and 20s", trigger.Dispatched, trigger.RetryAfter())
+	}
+
+	h.clock.Advance(20 * time.Second)
+	trigger, err = h.s.Trigger(TriggerRequest{Selector: Selector{Type: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Dispatched != 1 || len(trigger.Throttled) != 0 {
+		t.Errorf("trigger after the gap dispatched %d, throttled %v; want 1 and none", trigger.Dispatched, trigger.Throttled)
+	}
+}
+
+func TestTriggerCountsScheduledRuns(t *testing.T) {
+	h := newHarness(t, 10, endpoint("a", time.Minute))
+	h.start()
+	h.advance(time.Minute)
+	h.waitSettled("a", epoch.Add(time.Minute))
+	h.received()
+
+	trigger, err := h.s.Trigger(TriggerRequest{Selector: Selector{Type: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Dispatched != 0 || trigger.RetryAfter() != defaultTriggerMinGap {
+		t.Errorf("trigger right after a scheduled run dispatched %d, retry after %s; want 0 and %s", trigger.Dispatched, trigger.RetryAfter(), defaultTriggerMinGap)
+	}
+}
+
+func TestTriggerSelectors(t *testing.T) {
+	h := newHarness(t, 10,
+		endpoint("a", time.Hour),
+		config.Endpoint{Type: "b", URL: "https://b.example.com/", Labels: map[string]string{"region": "east"}},
+	)
+
+	tests := []struct {
+		selector Selector
+		want     []string
+		err      error
+	}{
+		{Selector{}, nil, ErrEmptySelector},
+		{Selector{Payer: "Nobody"}, nil, ErrNoMatch},
+		{Selector{Payer: "Acme", Type: "missing"}, nil, ErrNoMatch},
+		{Selector{Labels: map[string]string{"region": "west"}}, nil, ErrNoMatch},
+		{Selector{URL: "https://a.example.com/"}, []string{"a"}, nil},
+		{Selector{Labels: map[string]string{"region": "east"}}, []string{"b"}, nil},
+	}
+	for _, tt := range tests {
+		_, err := h.s.Trigger(TriggerRequest{Selector: tt.selector})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Trigger(%+v) error = %v, want %v", tt.selector, err, tt.err)
+		}
+		if got := h.received(); !equal(got, tt.want) {
+			t.Errorf("Trigger(%+v) dispatched %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxBackoff caps the delay between attempts
+const maxBackoff = 5 * time.Minute
+
+// Request headers set on every delivery
+const (
+	HeaderEvent     = "X-Payer-Status-Event"     // Message type, e.g. incident
+	HeaderDelivery  = "X-Payer-Status-Delivery"  // Message seq, the same on every attempt
+	HeaderSignature = "X-Payer-Status-Signature" // sha256=<hex HMAC of the body>, when a secret is set
+)
+
+// permanentError is a failure retrying will not fix, such as a 400
+type permanentError struct{ error }
+
+// deliver sends a webhook's events in order until its queue is closed
+func (d *Dispatcher) deliver(t *target) {
+	defer d.workers.Done()
+
+	for ev := range t.queue {
+		attempts, err := d.attempt(t, ev)
+		observer := d.currentObserver()
+		if err == nil {
+			if observer != nil {
+				observer.RecordWebhookDelivery(t.Name, ResultSuccess)
+			}
+			continue
+		}
+
+		d.logger.Error("Webhook delivery failed, writing to the dead-letter file",
+			zap.String("webhook", t.Name),
+			zap.Uint64("seq", ev.message.Seq),
+			zap.Int("attempts", attempts),
+			zap.Error(err))
+		if err := d.deadLetter.write(t.Name, ev, attempts, err); err != nil {
+			d.logger.Error("Failed to write webhook dead letter",
+				zap.String("webhook", t.Name),
+				zap.Uint64("seq", ev.message.Seq),
+				zap.Error(err))
+		}
+		if observer != nil {
+			observer.RecordWebhookDelivery(t.Name, ResultDeadLetter)
+		}
+	}
+}
+
+// attempt POSTs an event until it succeeds, fails permanently, runs out of
+// attempts or the dispatcher stops. It returns the attempts made.
+func (d *Dispatcher) attempt(t *target, ev event) (int, error) {
+	backoff := t.GetBackoff()
+	var err error
+	for attempt := 1; ; attempt++ {
+		if d.ctx.Err() != nil {
+			if err == nil {
+				err = d.ctx.Err()
+			}
+			return attempt - 1, fmt.Errorf("dispatcher stopped: %w", err)
+		}
+
+		err = d.post(t, ev)
+		var permanent permanentError
+		if err == nil || errors.As(err, &permanent) || attempt >= t.GetMaxAttempts() {
+			return attempt, err
+		}
+
+		d.logger.Warn("Webhook delivery failed, retrying",
+			zap.String("webhook", t.Name),
+			zap.Uint64("seq", ev.message.Seq),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		if observer := d.currentObserver(); observer != nil {
+			observer.RecordWebhookRetry(t.Name)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one delivery attempt. 2xx responses succeed; other 4xx
+// responses than 408 and 429 fail permanently.
+func (d *Dispatcher) post(t *target, ev event) error {
+	ctx, cancel := context.WithTimeout(d.ctx, t.GetTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, os.ExpandEnv(t.URL), bytes.NewReader(ev.body))
+	if err != nil {
+		return permanentError{err}
+	}
+	for name, value := range t.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "payer-status-io-webhook")
+	req.Header.Set(HeaderEvent, ev.message.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(ev.message.Seq, 10))
+	if secret := os.ExpandEnv(t.Secret); secret != "" {
+		req.Header.Set(HeaderSignature, Sign([]byte(secret), ev.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Let the connection be reused
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return permanentError{fmt.Errorf("receiver rejected the event with %s", resp.Status)}
+	default:
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+}
+
+// Sign returns the signature header value for a body: "sha256=" and the
+// hex HMAC-SHA256 of the body keyed with the secret
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeadLetter is one line of the dead-letter file
+type DeadLetter struct {
+	Webhook  string          `json:"webhook"`
+	FailedAt time.Time       `json:"failed_at"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Message  json.RawMessage `json:"message"` // The envelope that was POSTed
+}
+
+// deadLetterFile appends undeliverable events to a JSON Lines file
+type deadLetterFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *deadLetterFile) setPath(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.path = path
+}
+
+func (f *deadLetterFile) write(webhook string, ev event, attempts int, cause error) error {
+	line, err := json.Marshal(DeadLetter{
+		Webhook:  webhook,
+		FailedAt: time.Now().UTC(),
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Message:  ev.body,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.path == "" {
+		return fmt.Errorf("no webhook_dead_letter_path set")
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("creating dead-letter directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening dead-letter file: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("writing dead-letter file: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,206 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// queueSize is how many events may wait for one webhook before new ones
+// are dropped
+const queueSize = 256
+
+// Delivery results reported to the Observer
+const (
+	ResultSuccess    = "success"
+	ResultDeadLetter = "dead_letter" // Every attempt failed, or the receiver rejected the event
+	ResultDropped    = "dropped"     // The webhook's queue was full
+)
+
+// Observer receives delivery outcomes, typically to export them as metrics
+type Observer interface {
+	RecordWebhookDelivery(webhook, result string)
+	RecordWebhookRetry(webhook string)
+}
+
+// Dispatcher POSTs broadcast messages to the configured webhooks. It is a
+// hub.Sink: each webhook has a queue and a goroutine, so a slow receiver
+// delays neither the hub nor the other webhooks.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	targets  map[string]*target
+	closed   bool
+	observer Observer
+
+	deadLetter *deadLetterFile
+	client     *http.Client
+	logger     *zap.Logger
+
+	// ctx is cancelled when Run returns, cutting retries short
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// target is one configured webhook and its queue
+type target struct {
+	config.Webhook
+	filter *hub.Filter
+	events map[string]bool
+	queue  chan event
+}
+
+// event is a message queued for delivery, already encoded
+type event struct {
+	message *hub.Envelope
+	body    []byte
+}
+
+// New creates a dispatcher with no webhooks
+func New(logger *zap.Logger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		targets:    make(map[string]*target),
+		deadLetter: &deadLetterFile{},
+		client:     &http.Client{},
+		logger:     logger,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// SetObserver installs the receiver of delivery outcomes
+func (d *Dispatcher) SetObserver(observer Observer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observer = observer
+}
+
+// Check reports whether Configure would accept the webhooks
+func Check(webhooks []config.Webhook) error {
+	for _, webhook := range webhooks {
+		if _, err := hub.NewFilter(webhook.Filter); err != nil {
+			return fmt.Errorf("webhook %s: %w", webhook.Name, err)
+		}
+	}
+	return nil
+}
+
+// Configure replaces the webhooks and the dead-letter file. Webhooks whose
+// settings are unchanged keep their queue; removed or changed ones deliver
+// what they have queued and then stop.
+func (d *Dispatcher) Configure(webhooks []config.Webhook, deadLetterPath string) error {
+	next := make(map[string]*target, len(webhooks))
+	for _, webhook := range webhooks {
+		filter, err := hub.NewFilter(webhook.Filter)
+		if err != nil {
+			return fmt.Errorf("webhook %s: %w", webhook.Name, err)
+		}
+		events := make(map[string]bool)
+		for _, event := range webhook.GetEvents() {
+			events[event] = true
+		}
+		next[webhook.Name] = &target{Webhook: webhook, filter: filter, events: events}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return fmt.Errorf("dispatcher is stopped")
+	}
+
+	d.deadLetter.setPath(deadLetterPath)
+	for name, old := range d.targets {
+		if t, ok := next[name]; ok && reflect.DeepEqual(t.Webhook, old.Webhook) {
+			next[name] = old
+			continue
+		}
+		close(old.queue)
+	}
+	for _, t := range next {
+		if t.queue == nil {
+			t.queue = make(chan event, queueSize)
+			d.workers.Add(1)
+			go d.deliver(t)
+		}
+	}
+	d.targets = next
+
+	if len(next) > 0 {
+		d.logger.Info("Webhooks configured", zap.Int("webhooks", len(next)))
+	}
+	return nil
+}
+
+// Publish queues a probe result or incident for the webhooks that want it.
+// Muted results, their incidents and pause notices are not sent. It
+// implements hub.Sink and never blocks.
+func (d *Dispatcher) Publish(message *hub.Envelope, result *config.ProbeResult, changed bool) {
+	if !result.Alerting() {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed || len(d.targets) == 0 {
+		return
+	}
+
+	var body []byte
+	for _, t := range d.targets {
+		if !t.events[message.Type] || !t.filter.Matches(result, changed) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(message); err != nil {
+				d.logger.Error("Failed to encode webhook event", zap.Error(err))
+				return
+			}
+		}
+
+		select {
+		case t.queue <- event{message: message, body: body}:
+		default:
+			d.logger.Warn("Webhook queue full, dropping event",
+				zap.String("webhook", t.Name),
+				zap.Uint64("seq", message.Seq))
+			if d.observer != nil {
+				d.observer.RecordWebhookDelivery(t.Name, ResultDropped)
+			}
+		}
+	}
+}
+
+// Run waits for ctx to end, then stops the webhooks. Events still queued
+// or being retried go to the dead-letter file.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	d.mu.Lock()
+	d.closed = true
+	d.cancel()
+	for _, t := range d.targets {
+		close(t.queue)
+	}
+	d.mu.Unlock()
+
+	d.workers.Wait()
+	d.logger.Info("Webhook dispatcher stopped")
+	return ctx.Err()
+}
+
+// currentObserver returns the observer for the delivery goroutines
+func (d *Dispatcher) currentObserver() Observer {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.observer
+}
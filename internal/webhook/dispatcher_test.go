@@ -0,0 +1,325 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"payer-status-io/internal/config"
+	"payer-status-io/internal/hub"
+)
+
+// recorder counts delivery outcomes
+type recorder struct {
+	mu         sync.Mutex
+	deliveries map[string]int
+	retries    int
+}
+
+func (r *recorder) RecordWebhookDelivery(webhook, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries[result]++
+}
+
+func (r *recorder) RecordWebhookRetry(webhook string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries++
+}
+
+func (r *recorder) counts() (map[string]int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deliveries := make(map[string]int, len(r.deliveries))
+	for result, n := range r.deliveries {
+		deliveries[result] = n
+	}
+	return deliveries, r.retries
+}
+
+// request is what the receiver got on one attempt
+type request struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook endpoint answering with the given statuses in turn,
+// then repeating the last one
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+	got      chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	t.Helper()
+	r := &receiver{statuses: statuses, got: make(chan struct{}, 100)}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	status := r.statuses[min(len(r.requests), len(r.statuses)-1)]
+	r.requests = append(r.requests, request{at: time.Now(), header: req.Header.Clone(), body: body})
+	r.mu.Unlock()
+	w.WriteHeader(status)
+	r.got <- struct{}{}
+}
+
+// wait blocks until the receiver has seen n requests
+func (r *receiver) wait(t *testing.T, n int) []request {
+	t.Helper()
+	for {
+		r.mu.Lock()
+		requests := append([]request(nil), r.requests...)
+		r.mu.Unlock()
+		if len(requests) >= n {
+			return requests
+		}
+		select {
+		case <-r.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("receiver got %d requests, want %d", len(requests), n)
+		}
+	}
+}
+
+// start configures and runs a dispatcher, stopping it when the test ends
+func start(t *testing.T, webhooks ...config.Webhook) (*Dispatcher, *recorder, string) {
+	t.Helper()
+	d := New(zap.NewNop())
+	observer := &recorder{deliveries: make(map[string]int)}
+	d.SetObserver(observer)
+	deadLetters := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	if err := d.Configure(webhooks, deadLetters); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d, observer, deadLetters
+}
+
+// publish sends an unhealthy result as an incident envelope
+func publish(d *Dispatcher, seq uint64, result *config.ProbeResult) {
+	d.Publish(&hub.Envelope{Type: hub.MessageIncident, Seq: seq, TS: result.Timestamp, Data: result}, result, true)
+}
+
+func unhealthy(payer string) *config.ProbeResult {
+	return &config.ProbeResult{Payer: payer, Type: "login", URL: "https://" + payer + ".example.com", StatusCode: 503, Timestamp: time.Now().UTC()}
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readDeadLetters returns the lines of the dead-letter file
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("dead letter %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestDeliverySignsEvents(t *testing.T) {
+	r, url := newReceiver(t, http.StatusOK)
+	t.Setenv("WEBHOOK_TEST_SECRET", "s3cret")
+	d, observer, _ := start(t, config.Webhook{
+		Name:    "signed",
+		URL:     url,
+		Secret:  "${WEBHOOK_TEST_SECRET}",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	})
+
+	publish(d, 42, unhealthy("acme"))
+	got := r.wait(t, 1)[0]
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.header.Get(HeaderSignature) != want {
+		t.Errorf("signature = %q, want %q", got.header.Get(HeaderSignature), want)
+	}
+	for name, want := range map[string]string{
+		HeaderEvent:     hub.MessageIncident,
+		HeaderDelivery:  "42",
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer token",
+	} {
+		if got.header.Get(name) != want {
+			t.Errorf("%s = %q, want %q", name, got.header.Get(name), want)
+		}
+	}
+
+	var envelope struct {
+		Type string             `json:"type"`
+		Seq  uint64             `json:"seq"`
+		Data config.ProbeResult `json:"data"`
+	}
+	if err := json.Unmarshal(got.body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != hub.MessageIncident || envelope.Seq != 42 || envelope.Data.Payer != "acme" {
+		t.Errorf("body = %s, want the published envelope", got.body)
+	}
+	waitFor(t, "the success to be recorded", func() bool {
+		deliveries, _ := observer.counts()
+		return deliveries[ResultSuccess] == 1
+	})
+}
+
+func TestDeliveryWithoutSecretIsUnsigned(t *testing.T) {
+	r, url := newReceiver(t, http.StatusNoContent)
+	d, _, _ := start(t, config.Webhook{Name: "plain", URL: url})
+
+	publish(d, 1, unhealthy("acme"))
+	if got := r.wait(t, 1)[0]; got.header.Get(HeaderSignature) != "" {
+		t.Errorf("signature = %q, want none", got.header.Get(HeaderSignature))
+	}
+}
+
+func TestDeliveryRetriesWithBackoff(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	r, url := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusOK)
+	d, observer, _ := start(t, config.Webhook{Name: "flaky", URL: url, Backoff: backoff, MaxAttempts: 5})
+
+	publish(d, 7, unhealthy("acme"))
+	requests := r.wait(t, 4)
+
+	for i := 1; i < len(requests); i++ {
+		if requests[i].header.Get(HeaderDelivery) != "7" {
+			t.Errorf("attempt %d delivery = %q, want the same seq on every attempt", i+1, requests[i].header.Get(HeaderDelivery))
+		}
+		want := backoff << (i - 1)
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < want {
+			t.Errorf("retry %d came after %v, want at least %v", i, gap, want)
+		}
+	}
+	waitFor(t, "the success to be recorded", func() bool {
+		deliveries, retries := observer.counts()
+		return deliveries[ResultSuccess] == 1 && retries == 3
+	})
+}
+
+func TestDeliveryDeadLetters(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantAttempts int
+	}{
+		{"retries exhausted", http.StatusInternalServerError, 3},
+		{"rejected", http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, url := newReceiver(t, tt.status)
+			d, observer, deadLetters := start(t, config.Webhook{Name: "broken", URL: url, Backoff: time.Millisecond, MaxAttempts: 3})
+
+			publish(d, 9, unhealthy("acme"))
+			waitFor(t, "the dead letter to be recorded", func() bool {
+				deliveries, _ := observer.counts()
+				return deliveries[ResultDeadLetter] == 1
+			})
+			if got := len(r.wait(t, tt.wantAttempts)); got != tt.wantAttempts {
+				t.Errorf("receiver got %d requests, want %d", got, tt.wantAttempts)
+			}
+
+			letters := readDeadLetters(t, deadLetters)
+			if len(letters) != 1 {
+				t.Fatalf("dead letters = %+v, want one", letters)
+			}
+			letter := letters[0]
+			if letter.Webhook != "broken" || letter.Attempts != tt.wantAttempts || letter.Error == "" {
+				t.Errorf("dead letter = %+v, want webhook broken after %d attempts with an error", letter, tt.wantAttempts)
+			}
+			var envelope hub.Envelope
+			if err := json.Unmarshal(letter.Message, &envelope); err != nil || envelope.Seq != 9 {
+				t.Errorf("dead letter message = %s, want the envelope with seq 9", letter.Message)
+			}
+		})
+	}
+}
+
+func TestPublishSkipsMutedAndPaused(t *testing.T) {
+	r, url := newReceiver(t, http.StatusOK)
+	d, _, _ := start(t, config.Webhook{Name: "alerts", URL: url, Events: []string{hub.MessageProbeResult, hub.MessageIncident}})
+
+	muted := unhealthy("muted")
+	muted.Muted = true
+	paused := &config.ProbeResult{Payer: "paused", Type: "login", URL: "https://paused.example.com", Paused: true, Timestamp: time.Now().UTC()}
+	publish(d, 1, muted)
+	d.Publish(&hub.Envelope{Type: hub.MessageProbeResult, Seq: 2, Data: muted}, muted, true)
+	d.Publish(&hub.Envelope{Type: hub.MessageProbeResult, Seq: 3, Data: paused}, paused, false)
+	publish(d, 4, unhealthy("acme"))
+
+	// Events are delivered in order, so anything queued before the last one
+	// would have arrived first
+	if got := r.wait(t, 1)[0].header.Get(HeaderDelivery); got != "4" {
+		t.Errorf("first delivery = seq %s, want 4", got)
+	}
+}
+
+func TestPublishFiltersEvents(t *testing.T) {
+	r, url := newReceiver(t, http.StatusOK)
+	d, _, _ := start(t, config.Webhook{
+		Name:   "acme-only",
+		URL:    url,
+		Filter: config.EventFilter{Payers: []string{"acme"}},
+	})
+
+	result := unhealthy("acme")
+	d.Publish(&hub.Envelope{Type: hub.MessageProbeResult, Seq: 1, Data: result}, result, true)
+	publish(d, 2, unhealthy("other"))
+	publish(d, 3, unhealthy("acme"))
+
+	if got := r.wait(t, 1)[0].header.Get(HeaderDelivery); got != "3" {
+		t.Errorf("first delivery = seq %s, want 3", got)
+	}
+}